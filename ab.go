@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// NewABGen drives addrA and addrB at the same time with the same command
+// stream, each target with its own num connections, and pairs their results
+// per interval. Worker i of B replays the generator of worker i of A.
+func NewABGen(addrA, addrB string, qps, num, loop int64) (result chan [2]*Result) {
+	RGen.Replicate(2)
+	ra := NewPerfGen(addrA, qps, num, loop, 0)
+	rb := NewPerfGen(addrB, qps, num, loop, int(num))
+
+	result = make(chan [2]*Result, 100)
+	go func() {
+		for {
+			a, okA := <-ra
+			b, okB := <-rb
+			if !okA || !okB {
+				break
+			}
+			result <- [2]*Result{a, b}
+		}
+		close(result)
+	}()
+	return result
+}
+
+// ABLine formats a pair of results side by side with B relative to A.
+func ABLine(a, b *Result) string {
+	side := func(r *Result) string {
+		s := fmt.Sprintf("qps %d\tp50 %dus\tp99 %dus\tp999 %dus\terr %.2f%%", r.QPS, r.P50, r.P99, r.P999, r.ErrRate())
+		if len(r.Cache) > 0 {
+			s += fmt.Sprintf("\thit %.1f%%", r.HitRatio())
+		}
+		return s
+	}
+	line := fmt.Sprintf("A %s | B %s | delta p50 %s\tp99 %s\tp999 %s\terr %+.2f%%",
+		side(a), side(b), relative(a.P50, b.P50), relative(a.P99, b.P99), relative(a.P999, b.P999),
+		b.ErrRate()-a.ErrRate())
+	if a.Server != nil && b.Server != nil {
+		line += fmt.Sprintf(" | server cpu A %d%% B %d%%\tops A %d B %d", a.Server.CPU, b.Server.CPU, a.Server.OPS, b.Server.OPS)
+	}
+	return line
+}
+
+// relative formats the change from a to b in percent.
+func relative(a, b int64) string {
+	if a == 0 {
+		if b == 0 {
+			return "+0.0%"
+		}
+		return "+inf%"
+	}
+	return fmt.Sprintf("%+.1f%%", 100*float64(b-a)/float64(a))
+}
+
+// abPath returns the -out file of one side of an A/B run: run.yml becomes
+// run.a.yml and run.b.yml.
+func abPath(out, side string) string {
+	ext := filepath.Ext(out)
+	return strings.TrimSuffix(out, ext) + "." + side + ext
+}
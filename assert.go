@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Assertion is a threshold checked against the whole run, written as
+//
+//	[COMMAND.]metric op value
+//
+// metric is qps, err (percent), mean, p50, p90, p99, p999 or max, latencies
+// are in us unless suffixed with us, ms or s; op is <, <=, > or >=.
+// "qps >= 100000", "err <= 0.1", "p99 < 2ms", "GET.p999 <= 800us".
+type Assertion struct {
+	Text   string
+	Cmd    string
+	Metric string
+	Op     string
+	Value  float64
+}
+
+var assertMetrics = map[string]bool{
+	"qps": true, "err": true, "mean": true, "p50": true, "p90": true,
+	"p99": true, "p999": true, "max": true,
+}
+
+// ParseAssertion ...
+func ParseAssertion(text string) (*Assertion, error) {
+	a := &Assertion{Text: strings.TrimSpace(text)}
+	s := strings.Replace(a.Text, " ", "", -1)
+	i := strings.IndexAny(s, "<>")
+	if i <= 0 {
+		return nil, fmt.Errorf("assertion %q: no < or > operator", text)
+	}
+	a.Op = s[i : i+1]
+	value := s[i+1:]
+	if strings.HasPrefix(value, "=") {
+		a.Op += "="
+		value = value[1:]
+	}
+
+	a.Metric = strings.ToLower(s[:i])
+	if dot := strings.LastIndexByte(a.Metric, '.'); dot >= 0 {
+		a.Cmd = strings.ToUpper(a.Metric[:dot])
+		a.Metric = a.Metric[dot+1:]
+	}
+	if !assertMetrics[a.Metric] {
+		return nil, fmt.Errorf("assertion %q: unknown metric %s", text, a.Metric)
+	}
+
+	unit := 1.0
+	switch {
+	case strings.HasSuffix(value, "us"):
+		value = value[:len(value)-2]
+	case strings.HasSuffix(value, "ms"):
+		value, unit = value[:len(value)-2], 1000
+	case strings.HasSuffix(value, "s"):
+		value, unit = value[:len(value)-1], 1000000
+	case strings.HasSuffix(value, "%"):
+		value = value[:len(value)-1]
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("assertion %q: bad value", text)
+	}
+	a.Value = v * unit
+	return a, nil
+}
+
+// ParseAssertions parses a list of assertions, each item may hold several
+// separated by commas.
+func ParseAssertions(texts []string) (as []*Assertion, err error) {
+	for _, text := range texts {
+		for _, t := range strings.Split(text, ",") {
+			if strings.TrimSpace(t) == "" {
+				continue
+			}
+			a, err := ParseAssertion(t)
+			if err != nil {
+				return nil, err
+			}
+			as = append(as, a)
+		}
+	}
+	return as, nil
+}
+
+// Measure returns the metric of the assertion in r, ok is false when the
+// command was never sent.
+func (a *Assertion) Measure(r *Result) (v float64, ok bool) {
+	num, errs, qps, hist := r.Num, r.Err, float64(r.QPS), r.Hist
+	if a.Cmd != "" {
+		c := r.Cmds[a.Cmd]
+		if c == nil || c.Num == 0 {
+			return 0, false
+		}
+		num, errs, hist = c.Num, c.Err, c.Hist
+		if r.Num > 0 {
+			qps = float64(r.QPS) * float64(c.Num) / float64(r.Num)
+		}
+	}
+	switch a.Metric {
+	case "qps":
+		return qps, true
+	case "err":
+		if num == 0 {
+			return 0, true
+		}
+		return 100 * float64(errs) / float64(num), true
+	case "mean":
+		return float64(hist.Mean()), true
+	case "max":
+		return float64(hist.Max), true
+	}
+	p, _ := strconv.ParseFloat(strings.TrimPrefix(a.Metric, "p"), 64)
+	if a.Metric == "p999" {
+		p = 99.9
+	}
+	return float64(hist.Percentile(p)), true
+}
+
+// Check returns a description of the violation, "" when a holds.
+func (a *Assertion) Check(r *Result) string {
+	v, ok := a.Measure(r)
+	if !ok {
+		return fmt.Sprintf("%s: command %s was not sent", a.Text, a.Cmd)
+	}
+	var hold bool
+	switch a.Op {
+	case "<":
+		hold = v < a.Value
+	case "<=":
+		hold = v <= a.Value
+	case ">":
+		hold = v > a.Value
+	case ">=":
+		hold = v >= a.Value
+	}
+	if hold {
+		return ""
+	}
+	return fmt.Sprintf("%s: measured %s", a.Text, strconv.FormatFloat(v, 'f', -1, 64))
+}
+
+// CheckAssertions returns the violated assertions.
+func CheckAssertions(as []*Assertion, r *Result) (violations []string) {
+	for _, a := range as {
+		if v := a.Check(r); v != "" {
+			violations = append(violations, v)
+		}
+	}
+	return violations
+}
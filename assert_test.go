@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestAssertions(t *testing.T) {
+	r := &Result{QPS: 1000, Num: 1000, Err: 2, Hist: NewHistogram(), Cmds: map[string]*CmdStatus{}}
+	get := &CmdStatus{Num: 500, Hist: NewHistogram()}
+	for v := int64(1); v <= 1000; v++ {
+		r.Hist.Record(v)
+		if v%2 == 0 {
+			get.Hist.Record(v)
+		}
+	}
+	r.Cmds["GET"] = get
+
+	cases := map[string]bool{
+		"qps >= 1000":      true,
+		"qps > 1000":       false,
+		"err <= 0.2%":      true,
+		"err < 0.2":        false,
+		"p99 <= 1ms":       true,
+		"p50 < 400us":      false,
+		"mean <= 501":      true,
+		"get.qps >= 500":   true,
+		"GET.p999 < 1ms":   false,
+		"GET.err <= 0":     true,
+		"LPUSH.p99 <= 1s":  false,
+		"max <= 0.001s":    true,
+		"p999 >= 998":      true,
+		"HGET.mean <= 10s": false,
+	}
+	for text, hold := range cases {
+		a, err := ParseAssertion(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.Check(r) == ""; got != hold {
+			t.Fatalf("%s: expect %v, get %v (%s)", text, hold, got, a.Check(r))
+		}
+	}
+
+	for _, text := range []string{"p99", "p98 < 1", "qps >= x", "< 1"} {
+		if _, err := ParseAssertion(text); err == nil {
+			t.Fatalf("%s: expect error", text)
+		}
+	}
+	as, err := ParseAssertions([]string{"qps>=1,err<=1", "", "p99<1s"})
+	if err != nil || len(as) != 3 {
+		t.Fatalf("expect 3 assertions, get %d %v", len(as), err)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// AuditStatus counts the acknowledged writes of one data type or one time
+// window. Missing writes are gone, Wrong ones hold another value or score,
+// or are back after their delete. In cache mode a missing key was evicted.
+type AuditStatus struct {
+	Written int64
+	Missing int64
+	Wrong   int64
+}
+
+// String ...
+func (s *AuditStatus) String() string {
+	return fmt.Sprintf("written %d\tmissing %d\twrong %d", s.Written, s.Missing, s.Wrong)
+}
+
+// WriteAudit reads back every key, hash field, set and sorted set member the
+// run wrote and compares it with the last acknowledged write of the
+// model, per data type and per Window of the time the write was replied.
+type WriteAudit struct {
+	Window  time.Duration
+	Total   AuditStatus
+	Types   map[string]*AuditStatus
+	Windows map[time.Time]*AuditStatus
+}
+
+// auditEntry is a write to read back.
+type auditEntry struct {
+	k keyField
+	e modelEntry
+}
+
+// audited returns the known writes of worker w ordered by key.
+func (w *workerModel) audited() []auditEntry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	entries := make([]auditEntry, 0, len(w.entries))
+	for k := range w.entries {
+		if e := w.get(k); e != nil {
+			entries = append(entries, auditEntry{k, *e})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].k, entries[j].k
+		return a.key < b.key || a.key == b.key && a.field < b.field
+	})
+	return entries
+}
+
+// Audit reads back the writes of every worker, partition after partition,
+// on its own connection to addr. It runs once the workers stopped.
+func (m *ConsistencyModel) Audit(addr string, window time.Duration) (*WriteAudit, error) {
+	a := &WriteAudit{Window: window, Types: map[string]*AuditStatus{}, Windows: map[time.Time]*AuditStatus{}}
+	conn, err := redis.Dial("tcp", addr, redis.DialConnectTimeout(time.Second),
+		redis.DialReadTimeout(5*time.Second), redis.DialWriteTimeout(5*time.Second))
+	if err != nil {
+		return a, err
+	}
+	defer conn.Close()
+
+	const batch = 1000
+	for _, w := range m.workers {
+		entries := w.audited()
+		for start := 0; start < len(entries); start += batch {
+			end := start + batch
+			if end > len(entries) {
+				end = len(entries)
+			}
+			for _, ae := range entries[start:end] {
+				switch ae.e.Kind {
+				case KeyExecutor.Name:
+					conn.Send("GET", ae.k.key)
+				case HashExecutor.Name:
+					conn.Send("HGET", ae.k.key, ae.k.field)
+				case SetExecutor.Name:
+					conn.Send("SISMEMBER", ae.k.key, ae.k.field)
+				case SortedSetExecutor.Name:
+					conn.Send("ZSCORE", ae.k.key, ae.k.field)
+				}
+			}
+			if err := conn.Flush(); err != nil {
+				return a, err
+			}
+			for _, ae := range entries[start:end] {
+				reply, err := conn.Receive()
+				if err != nil {
+					return a, fmt.Errorf("%s: %v", ae.k, err)
+				}
+				a.account(ae, reply)
+			}
+		}
+	}
+	return a, nil
+}
+
+// account compares reply with the write ae.
+func (a *WriteAudit) account(ae auditEntry, reply interface{}) {
+	present, value := reply != nil, ""
+	switch r := reply.(type) {
+	case int64:
+		present = r == 1
+	case []byte:
+		value = string(r)
+	}
+
+	var problem string
+	switch {
+	case ae.e.Deleted && present:
+		problem = "back after its delete"
+	case ae.e.Deleted, !present && Conf.Cache:
+	case !present:
+		problem = "missing"
+	case ae.e.Kind == SortedSetExecutor.Name && !sameScore(value, ae.e.Value):
+		problem = "score " + value + ", expect " + ae.e.Value
+	case (ae.e.Kind == KeyExecutor.Name || ae.e.Kind == HashExecutor.Name) && value != ae.e.Value:
+		problem = "another value"
+	}
+
+	at := ae.e.At.Truncate(a.Window)
+	for _, s := range []*AuditStatus{&a.Total, a.typ(ae.e.Kind), a.window(at)} {
+		s.Written++
+		switch {
+		case problem == "missing":
+			s.Missing++
+		case problem != "":
+			s.Wrong++
+		}
+	}
+	if problem != "" && (Conf.Debug || a.Total.Missing+a.Total.Wrong <= mirrorLogLimit) {
+		verb := "written"
+		if ae.e.Deleted {
+			verb = "deleted"
+		}
+		log.Printf("audit: %s %s %s, %s at %s\n", ae.e.Kind, ae.k, problem, verb, ae.e.At.Format(modelTime))
+	}
+}
+
+func sameScore(a, b string) bool {
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	return errA == nil && errB == nil && fa == fb
+}
+
+func (a *WriteAudit) typ(kind string) *AuditStatus {
+	s := a.Types[kind]
+	if s == nil {
+		s = &AuditStatus{}
+		a.Types[kind] = s
+	}
+	return s
+}
+
+func (a *WriteAudit) window(at time.Time) *AuditStatus {
+	s := a.Windows[at]
+	if s == nil {
+		s = &AuditStatus{}
+		a.Windows[at] = s
+	}
+	return s
+}
+
+// Report lists the writes per data type and per window.
+func (a *WriteAudit) Report() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "audit: %s\n", &a.Total)
+	types := make([]string, 0, len(a.Types))
+	for name := range a.Types {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	for _, name := range types {
+		fmt.Fprintf(&b, "%-16s %s\n", name, a.Types[name])
+	}
+	windows := make([]time.Time, 0, len(a.Windows))
+	for at := range a.Windows {
+		windows = append(windows, at)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Before(windows[j]) })
+	for _, at := range windows {
+		fmt.Fprintf(&b, "%-16s %s\n", at.Format("15:04:05"), a.Windows[at])
+	}
+	return b.String()
+}
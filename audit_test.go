@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteAudit(t *testing.T) {
+	at := time.Date(2026, 1, 1, 10, 0, 0, 0, time.Local)
+	a := &WriteAudit{Window: 10 * time.Second, Types: map[string]*AuditStatus{}, Windows: map[time.Time]*AuditStatus{}}
+	audit := func(kind, key, field, value string, deleted bool, sec int, reply interface{}) {
+		e := modelEntry{Kind: kind, Value: value, Deleted: deleted, At: at.Add(time.Duration(sec) * time.Second)}
+		a.account(auditEntry{keyField{key, field}, e}, reply)
+	}
+
+	audit(KeyExecutor.Name, "a", "", "1", false, 1, []byte("1"))
+	audit(KeyExecutor.Name, "b", "", "1", false, 2, nil)
+	audit(KeyExecutor.Name, "c", "", "", true, 3, []byte("1"))
+	audit(KeyExecutor.Name, "d", "", "", true, 4, nil)
+	audit(HashExecutor.Name, "h", "f", "1", false, 12, []byte("2"))
+	audit(SetExecutor.Name, "s", "m", "", false, 13, int64(1))
+	audit(SetExecutor.Name, "s", "n", "", false, 14, int64(0))
+	audit(SortedSetExecutor.Name, "z", "m", "1.5", false, 15, []byte("1.50"))
+	audit(SortedSetExecutor.Name, "z", "n", "1", false, 16, []byte("2"))
+
+	if a.Total.Written != 9 || a.Total.Missing != 2 || a.Total.Wrong != 3 {
+		t.Fatalf("unexpected total %s", &a.Total)
+	}
+	if s := a.Types[SetExecutor.Name]; s.Written != 2 || s.Missing != 1 || s.Wrong != 0 {
+		t.Fatalf("unexpected set %s", s)
+	}
+	if s := a.Windows[at]; len(a.Windows) != 2 || s.Written != 4 || s.Missing != 1 || s.Wrong != 1 {
+		t.Fatalf("unexpected windows %d %s", len(a.Windows), s)
+	}
+	if r := a.Report(); !strings.Contains(r, "10:00:10") || !strings.Contains(r, "sortedset") {
+		t.Fatalf("unexpected report %s", r)
+	}
+}
+
+func TestAuditForgotten(t *testing.T) {
+	w := &workerModel{entries: map[keyField]*modelEntry{}, forgot: map[string]int64{}}
+	w.put(keyField{"s", "a"}, &modelEntry{Kind: SetExecutor.Name})
+	w.put(keyField{"k", ""}, &modelEntry{Kind: KeyExecutor.Name})
+	w.forgot["s"] = w.seq
+	w.put(keyField{"s", "b"}, &modelEntry{Kind: SetExecutor.Name})
+
+	entries := w.audited()
+	if len(entries) != 2 || entries[0].k.key != "k" || entries[1].k != (keyField{"s", "b"}) {
+		t.Fatalf("expect k and the member written after the forget, get %v", entries)
+	}
+	if len(w.recent[0]) != 1 || len(w.recent[1]) != 0 {
+		t.Fatal("expect only keys and hash fields in the recent keys")
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// BatchExecutor sends multi-key commands on -batch keys or fields of the
+// worker, with -cluster the keys of one command share a hash slot.
+var BatchExecutor = &RandomExecutor{Name: "batch"}
+
+func init() {
+	Executors = append(Executors, BatchExecutor)
+
+	// MSET and MGET of the same keys
+	BatchExecutor.Add("mset_mget", 10, func(conn redis.Conn, id int) (rs []*Request) {
+		keys := RGen.Keys(id, Conf.Batch)
+		args := make([]interface{}, 0, 2*len(keys))
+		expect := make(map[string]string, len(keys))
+		written := make([]keyField, len(keys))
+		values := make([]string, len(keys))
+		for i, key := range keys {
+			value := RGen.Value(id)
+			args = append(args, key, value)
+			expect[key] = value
+			written[i], values[i] = keyField{key: key}, value
+		}
+
+		conn.Send("MSET", args...)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("MSET %s", joinArgs(args)),
+			valid: Model.WriteMany(id, written, values, validOK),
+		})
+		conn.Flush()
+
+		conn.Send("MGET", stringArgs(keys)...)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("MGET %s", strings.Join(keys, " ")),
+			valid: func(reply interface{}, err error) error {
+				result, err := redis.Strings(reply, err)
+				if err != nil {
+					return err
+				}
+				if len(result) != len(keys) {
+					return fmt.Errorf("expect %d values, get %d", len(keys), len(result))
+				}
+				for i, key := range keys {
+					if result[i] != expect[key] {
+						return fmt.Errorf("%s: expect %s, get %s", key, expect[key], result[i])
+					}
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// HSET and HMGET of many fields
+	BatchExecutor.Add("hset_hmget", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Hash(id)
+		fields := make([]string, Conf.Batch)
+		args := make([]interface{}, 0, 1+2*len(fields))
+		args = append(args, key)
+		expect := make(map[string]string, len(fields))
+		written := make([]keyField, len(fields))
+		values := make([]string, len(fields))
+		for i := range fields {
+			fields[i] = RGen.HashField(id)
+			value := RGen.Value(id)
+			args = append(args, fields[i], value)
+			expect[fields[i]] = value
+			written[i], values[i] = keyField{key, fields[i]}, value
+		}
+
+		conn.Send("HSET", args...)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("HSET %s", joinArgs(args)),
+			valid: Model.WriteMany(id, written, values, func(reply interface{}, err error) error {
+				result, err := redis.Int(reply, err)
+				if err != nil {
+					return err
+				}
+				if result < 0 || result > len(fields) {
+					return fmt.Errorf("expect 0 to %d, get %d", len(fields), result)
+				}
+				return nil
+			}),
+		})
+		conn.Flush()
+
+		conn.Send("HMGET", append([]interface{}{key}, stringArgs(fields)...)...)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("HMGET %s %s", key, strings.Join(fields, " ")),
+			valid: func(reply interface{}, err error) error {
+				result, err := redis.Strings(reply, err)
+				if err != nil {
+					return err
+				}
+				if len(result) != len(fields) {
+					return fmt.Errorf("expect %d values, get %d", len(fields), len(result))
+				}
+				for i, field := range fields {
+					if result[i] != expect[field] {
+						return fmt.Errorf("%s: expect %s, get %s", field, expect[field], result[i])
+					}
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	BatchExecutor.Add("sunion", 3, setAlgebra("SUNION"))
+	BatchExecutor.Add("sinter", 2, setAlgebra("SINTER"))
+	BatchExecutor.Add("sdiff", 2, setAlgebra("SDIFF"))
+	// the destination is the first set, union grows it and the others shrink
+	// it, so the sets stay within SetSize members
+	BatchExecutor.Add("sunionstore_scard", 2, setAlgebraStore("SUNIONSTORE"))
+	BatchExecutor.Add("sinterstore_scard", 1, setAlgebraStore("SINTERSTORE"))
+	BatchExecutor.Add("sdiffstore_scard", 1, setAlgebraStore("SDIFFSTORE"))
+	BatchExecutor.Add("zunionstore_zcard", 2, zsetStore("ZUNIONSTORE"))
+	BatchExecutor.Add("zinterstore_zcard", 1, zsetStore("ZINTERSTORE"))
+}
+
+// setAlgebra sends cmd, SUNION, SINTER or SDIFF, on -batch sets.
+func setAlgebra(cmd string) func(conn redis.Conn, id int) (rs []*Request) {
+	return func(conn redis.Conn, id int) (rs []*Request) {
+		keys := RGen.Sets(id, Conf.Batch)
+		size := RGen.Param.SetSize
+
+		conn.Send(cmd, stringArgs(keys)...)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("%s %s", cmd, strings.Join(keys, " ")),
+			valid: func(reply interface{}, err error) error {
+				result, err := redis.Strings(reply, err)
+				if err != nil {
+					return err
+				}
+				if int64(len(result)) > size {
+					return fmt.Errorf("expect at most %d members, get %d", size, len(result))
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	}
+}
+
+// setAlgebraStore sends cmd, SUNIONSTORE, SINTERSTORE or SDIFFSTORE, into the
+// first of -batch sets and checks its SCARD.
+func setAlgebraStore(cmd string) func(conn redis.Conn, id int) (rs []*Request) {
+	return func(conn redis.Conn, id int) (rs []*Request) {
+		keys := RGen.Sets(id, Conf.Batch)
+		var stored int64
+
+		conn.Send(cmd, append([]interface{}{keys[0]}, stringArgs(keys)...)...)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("%s %s %s", cmd, keys[0], strings.Join(keys, " ")),
+			valid: Model.Forget(id, keys[0], func(reply interface{}, err error) error {
+				stored, err = redis.Int64(reply, err)
+				return err
+			}),
+		})
+		conn.Flush()
+
+		conn.Send("SCARD", keys[0])
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("SCARD %s", keys[0]),
+			valid: validCard(&stored),
+		})
+		conn.Flush()
+
+		return rs
+	}
+}
+
+// zsetStore sends cmd, ZUNIONSTORE or ZINTERSTORE, into the first of -batch
+// sorted sets and checks its ZCARD. AGGREGATE MAX keeps the scores from
+// growing with every store.
+func zsetStore(cmd string) func(conn redis.Conn, id int) (rs []*Request) {
+	return func(conn redis.Conn, id int) (rs []*Request) {
+		keys := RGen.SortedSets(id, Conf.Batch)
+		var stored int64
+		args := append([]interface{}{keys[0], len(keys)}, stringArgs(keys)...)
+		args = append(args, "AGGREGATE", "MAX")
+
+		conn.Send(cmd, args...)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("%s %s", cmd, joinArgs(args)),
+			valid: Model.Forget(id, keys[0], func(reply interface{}, err error) error {
+				stored, err = redis.Int64(reply, err)
+				return err
+			}),
+		})
+		conn.Flush()
+
+		conn.Send("ZCARD", keys[0])
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("ZCARD %s", keys[0]),
+			valid: validCard(&stored),
+		})
+		conn.Flush()
+
+		return rs
+	}
+}
+
+// validCard expects the cardinality *stored, set by the validator of the
+// store before.
+func validCard(stored *int64) func(reply interface{}, err error) error {
+	return func(reply interface{}, err error) error {
+		result, err := redis.Int64(reply, err)
+		if err != nil {
+			return err
+		}
+		if result != *stored {
+			return fmt.Errorf("expect %d, get %d", *stored, result)
+		}
+		return nil
+	}
+}
+
+func stringArgs(ss []string) []interface{} {
+	args := make([]interface{}, len(ss))
+	for i, s := range ss {
+		args[i] = s
+	}
+	return args
+}
+
+func joinArgs(args []interface{}) string {
+	return strings.TrimSpace(fmt.Sprintln(args...))
+}
+
+// CheckClusterTags checks that the templates of the batch workload give
+// multi-key commands a common hash tag.
+func (param *Param) CheckClusterTags() error {
+	templates := []struct {
+		typ string
+		t   *KeyTemplate
+	}{
+		{"key", param.KeyTemplate},
+		{"set", param.SetTemplate},
+		{"sortedset", param.SortedSetTemplate},
+	}
+	for _, tt := range templates {
+		if err := checkClusterTag(tt.typ, tt.t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkClusterTag checks that the keys of t of one multi-key command can
+// share a hash slot.
+func checkClusterTag(typ string, t *KeyTemplate) error {
+	kind, ok := t.hashTag()
+	if !ok {
+		return fmt.Errorf("%s template %q: -cluster needs a hash tag, like {${tag}}", typ, t.Format)
+	}
+	if kind == segN {
+		return fmt.Errorf("%s template %q: a hash tag of ${n} gives every key its own slot", typ, t.Format)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHashTag(t *testing.T) {
+	cases := []struct {
+		tpl  KeyTemplate
+		kind segKind
+		ok   bool
+	}{
+		{KeyTemplate{Format: "${type}_${n}"}, segLiteral, false},
+		{KeyTemplate{Format: "{${tag}}${type}_${n}", Tags: 16}, segTag, true},
+		{KeyTemplate{Format: "${prefix}{g${group}}_${n}", Group: 8}, segGroup, true},
+		{KeyTemplate{Format: "{w${worker}}_${n}"}, segWorker, true},
+		{KeyTemplate{Format: "{run}_${n}"}, segLiteral, true},
+		{KeyTemplate{Format: "{}_${n}"}, segLiteral, false},
+		{KeyTemplate{Format: "{${n}}"}, segN, true},
+		{KeyTemplate{Format: "{${worker}:${tag}}_${n}", Tags: 4}, segN, true},
+	}
+	for _, c := range cases {
+		if err := c.tpl.Compile("run:", "key"); err != nil {
+			t.Fatal(err)
+		}
+		if kind, ok := c.tpl.hashTag(); kind != c.kind || ok != c.ok {
+			t.Fatalf("%s: expect %d %v, get %d %v", c.tpl.Format, c.kind, c.ok, kind, ok)
+		}
+	}
+}
+
+func TestClusterBatch(t *testing.T) {
+	defer func(cluster bool) { Conf.Cluster = cluster }(Conf.Cluster)
+	Conf.Cluster = true
+	tag := func(key string) string {
+		return key[strings.IndexByte(key, '{')+1 : strings.IndexByte(key, '}')]
+	}
+	for _, tpl := range []*KeyTemplate{
+		{Format: "{${tag}}key_${n}", Tags: 16},
+		{Format: "{${group}}key_${n}", Group: 8},
+	} {
+		rg := newTestGen()
+		rg.Param.KeyTemplate = tpl
+		if err := rg.Param.Compile(); err != nil {
+			t.Fatal(err)
+		}
+		for id := 0; id < int(rg.Num); id++ {
+			r := rg.Range[id]
+			for i := 0; i < 100; i++ {
+				keys := rg.Keys(id, 10)
+				for _, key := range keys {
+					if tag(key) != tag(keys[0]) {
+						t.Fatalf("%s: %s and %s in one batch", tpl.Format, keys[0], key)
+					}
+					n, _ := strconv.ParseInt(key[strings.LastIndexByte(key, '_')+1:], 10, 64)
+					if n < r.KeyMin || n >= r.KeyMin+r.KeySize {
+						t.Fatalf("%s: %s out of the keys of worker %d", tpl.Format, key, id)
+					}
+				}
+			}
+		}
+	}
+}
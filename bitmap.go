@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// BitmapExecutor sets, counts and reads the bits of the bitmaps of the
+// worker, BitmapSize bits long.
+var BitmapExecutor = &RandomExecutor{Name: "bitmap"}
+
+func init() {
+	Executors = append(Executors, BitmapExecutor)
+
+	// SETBIT and GETBIT of a bit
+	BitmapExecutor.Add("setbit_getbit", 10, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Bitmap(id)
+		offset := RGen.BitOffset(id)
+		bit := RGen.Rand[id].Intn(2)
+
+		conn.Send("SETBIT", key, offset, bit)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("SETBIT %s %d %d", key, offset, bit),
+			valid: func(reply interface{}, err error) error {
+				old, err := redis.Int(reply, err)
+				if err != nil {
+					return err
+				}
+				if old != 0 && old != 1 {
+					return fmt.Errorf("expect 0 or 1, get %d", old)
+				}
+				return nil
+			},
+		})
+		conn.Send("GETBIT", key, offset)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("GETBIT %s %d", key, offset),
+			valid: func(reply interface{}, err error) error {
+				result, err := redis.Int(reply, err)
+				if err != nil {
+					return err
+				}
+				if result != bit {
+					return fmt.Errorf("expect %d, get %d", bit, result)
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// BITCOUNT of the bitmap and of a byte range of it
+	BitmapExecutor.Add("bitcount", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Bitmap(id)
+		bytes := (RGen.Param.BitmapSize + 7) / 8
+		start := RGen.Rand[id].Int63n(bytes)
+		end := start + RGen.Rand[id].Int63n(bytes-start)
+		var total int64
+
+		conn.Send("BITCOUNT", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("BITCOUNT %s", key),
+			valid: func(reply interface{}, err error) error {
+				total, err = redis.Int64(reply, err)
+				if err != nil {
+					return err
+				}
+				if total < 0 || total > RGen.Param.BitmapSize {
+					return fmt.Errorf("expect a count from 0 to %d, get %d", RGen.Param.BitmapSize, total)
+				}
+				return nil
+			},
+		})
+		conn.Send("BITCOUNT", key, start, end)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("BITCOUNT %s %d %d", key, start, end),
+			valid: func(reply interface{}, err error) error {
+				count, err := redis.Int64(reply, err)
+				if err != nil {
+					return err
+				}
+				if count < 0 || count > total || count > 8*(end-start+1) {
+					return fmt.Errorf("expect a count from 0 to %d of %d bytes, get %d", total, end-start+1, count)
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// BITFIELD SET, GET and saturated INCRBY of a byte
+	BitmapExecutor.Add("bitfield", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Bitmap(id)
+		field := fmt.Sprintf("#%d", RGen.Rand[id].Int63n(RGen.Param.BitmapSize/8))
+		value := RGen.Rand[id].Intn(256)
+		incr := RGen.Rand[id].Intn(256)
+		expect := value + incr
+		if expect > 255 {
+			expect = 255
+		}
+
+		conn.Send("BITFIELD", key, "SET", "u8", field, value, "GET", "u8", field, "OVERFLOW", "SAT", "INCRBY", "u8", field, incr)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("BITFIELD %s SET u8 %s %d GET u8 %s OVERFLOW SAT INCRBY u8 %s %d", key, field, value, field, field, incr),
+			valid: func(reply interface{}, err error) error {
+				result, err := redis.Ints(reply, err)
+				if err != nil {
+					return err
+				}
+				if len(result) != 3 {
+					return fmt.Errorf("expect 3 values, get %d", len(result))
+				}
+				if result[0] < 0 || result[0] > 255 || result[1] != value || result[2] != expect {
+					return fmt.Errorf("expect [0-255 %d %d], get %v", value, expect, result)
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+}
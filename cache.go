@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ErrMiss is returned by the validators of cache reads on a nil reply in
+// cache mode, it is counted as a miss and not as an error.
+var ErrMiss = errors.New("cache miss")
+
+// CacheExecutor reads keys written by earlier requests, as a cache in front
+// of a database does, the few writes fill the cache.
+var CacheExecutor = &RandomExecutor{Name: "cache"}
+
+func init() {
+	Executors = append(Executors, CacheExecutor)
+
+	// get
+	CacheExecutor.Add("get", 10, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Key(id)
+
+		conn.Send("GET", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("GET %s", key),
+			Read:  KeyExecutor.Name,
+			valid: Model.Read(id, key, "", func(reply interface{}, err error) error {
+				if missed(reply, err) {
+					return ErrMiss
+				}
+				_, err = redis.String(reply, err)
+				return err
+			}),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// set
+	CacheExecutor.Add("set", 1, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Key(id)
+		value := RGen.Value(id)
+
+		conn.Send("SET", key, value)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("SET %s %s", key, value),
+			valid: Model.Write(id, key, "", value, func(reply interface{}, err error) error {
+				result, err := redis.String(reply, err)
+				if err != nil {
+					return err
+				}
+				if result != "OK" {
+					return fmt.Errorf("expect OK, get %s", result)
+				}
+				return nil
+			}),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// hget
+	CacheExecutor.Add("hget", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Hash(id)
+		field := RGen.HashField(id)
+
+		conn.Send("HGET", key, field)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("HGET %s %s", key, field),
+			Read:  HashExecutor.Name,
+			valid: Model.Read(id, key, field, func(reply interface{}, err error) error {
+				if missed(reply, err) {
+					return ErrMiss
+				}
+				_, err = redis.String(reply, err)
+				return err
+			}),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// hset
+	CacheExecutor.Add("hset", 1, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Hash(id)
+		field := RGen.HashField(id)
+		value := RGen.Value(id)
+
+		conn.Send("HSET", key, field, value)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("HSET %s %s %s", key, field, value),
+			valid: Model.Write(id, key, field, value, func(reply interface{}, err error) error {
+				result, err := redis.Int(reply, err)
+				if err != nil {
+					return err
+				}
+				if result != 0 && result != 1 {
+					return fmt.Errorf("expect 0 or 1, get %d", result)
+				}
+				return nil
+			}),
+		})
+		conn.Flush()
+
+		return rs
+	})
+}
+
+// missed tells a nil reply of a cache read in cache mode.
+func missed(reply interface{}, err error) bool {
+	return Conf.Cache && reply == nil && err == nil
+}
+
+// CacheStatus counts the cache reads of one data type.
+type CacheStatus struct {
+	Hit  int64
+	Miss int64
+}
+
+// Ratio returns the hits in percent of the reads.
+func (c *CacheStatus) Ratio() float64 {
+	if c.Hit+c.Miss == 0 {
+		return 0
+	}
+	return 100 * float64(c.Hit) / float64(c.Hit+c.Miss)
+}
+
+// mergeCache adds from into to.
+func mergeCache(to, from map[string]*CacheStatus) {
+	for typ, c := range from {
+		if c.Hit+c.Miss == 0 {
+			continue
+		}
+		t := to[typ]
+		if t == nil {
+			t = &CacheStatus{}
+			to[typ] = t
+		}
+		t.Hit += c.Hit
+		t.Miss += c.Miss
+	}
+}
+
+// HitRatio returns the hits of every data type in percent of the reads.
+func (r *Result) HitRatio() float64 {
+	all := &CacheStatus{}
+	for _, c := range r.Cache {
+		all.Hit += c.Hit
+		all.Miss += c.Miss
+	}
+	return all.Ratio()
+}
+
+// CacheReport formats the hit ratio per data type, one line per type.
+func (r *Result) CacheReport() string {
+	types := make([]string, 0, len(r.Cache))
+	for typ := range r.Cache {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	var b bytes.Buffer
+	for _, typ := range types {
+		c := r.Cache[typ]
+		fmt.Fprintf(&b, "%-16s hit %d\tmiss %d\tratio %.2f%%\n", typ, c.Hit, c.Miss, c.Ratio())
+	}
+	return b.String()
+}
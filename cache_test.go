@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseWorkload(t *testing.T) {
+	e, names, err := ParseWorkload("cache")
+	if err != nil || e != CacheExecutor || len(names) != 1 {
+		t.Fatalf("expect the cache executor, get %v %v %v", e, names, err)
+	}
+	e, names, err = ParseWorkload("cache=3,key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Weights) != 2 || e.Weights[0].Score != 3 || e.Weights[1].Score != 1 || names[1] != "key" {
+		t.Fatalf("unexpected weights %v", e.Weights)
+	}
+	for _, s := range []string{"nope", "cache=0", "cache=x"} {
+		if _, _, err := ParseWorkload(s); err == nil {
+			t.Fatalf("expect error for %q", s)
+		}
+	}
+}
+
+func TestCacheRecord(t *testing.T) {
+	Conf.Cache = true
+	defer func() { Conf.Cache = false }()
+
+	s := newBucketStatus()
+	s.Record(&Request{Opstr: "GET a", Read: "key"})
+	s.Record(&Request{Opstr: "GET b", Read: "key", Err: ErrMiss})
+	s.Record(&Request{Opstr: "HGET c f", Read: "hash", Err: ErrMiss})
+	s.Record(&Request{Opstr: "SET a v"})
+	if s.Err != 0 {
+		t.Fatalf("misses are not errors, get %d errors", s.Err)
+	}
+	r := &Result{Cache: map[string]*CacheStatus{}}
+	mergeCache(r.Cache, s.Cache)
+	if c := r.Cache["key"]; c == nil || c.Hit != 1 || c.Miss != 1 {
+		t.Fatalf("unexpected key reads %+v", c)
+	}
+	if ratio := r.HitRatio(); ratio < 33.3 || ratio > 33.4 {
+		t.Fatalf("expect hit ratio 33.3%%, get %f", ratio)
+	}
+}
+
+func TestBucketStatusSwap(t *testing.T) {
+	w := newWorker(0, nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10000; i++ {
+			w.record(&Request{Opstr: "GET a", Read: "key"})
+		}
+	}()
+	var num int64
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		s := w.GetAndResetBucketStatus()
+		num += s.Num
+		if c := s.Cmds["GET"]; c != nil && c.Num != c.Hist.Total {
+			t.Fatalf("GET counted %d times, %d in its histogram", c.Num, c.Hist.Total)
+		}
+	}
+	num += w.GetAndResetBucketStatus().Num
+	if num != 10000 {
+		t.Fatalf("expect 10000 requests, get %d", num)
+	}
+
+	first := w.GetAndResetBucketStatus()
+	w.GetAndResetBucketStatus()
+	if w.GetAndResetBucketStatus() != first || first.Num != 0 {
+		t.Fatalf("expect the statuses reused and reset")
+	}
+	if c := first.Cmds["GET"]; c != nil && (c.Num != 0 || c.Hist.Total != 0) {
+		t.Fatalf("expect the statuses reused and reset")
+	}
+}
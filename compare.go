@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// CompareOptions are the tolerances of the compare command.
+type CompareOptions struct {
+	// Tolerance is the relative change in percent accepted as noise
+	Tolerance float64
+	// MinDelta is the latency change in us below which changes are noise
+	MinDelta int64
+	// ErrTolerance is the error rate change in percentage points accepted
+	ErrTolerance float64
+	// MinNum ignores commands with fewer requests in the baseline
+	MinNum int64
+}
+
+// Comparison is the change of one metric from the baseline to the candidate.
+type Comparison struct {
+	Scope     string
+	Metric    string
+	Baseline  float64
+	Candidate float64
+	// Change is relative in percent, for err it is in percentage points
+	Change    float64
+	Regressed bool
+	Improved  bool
+}
+
+// compareMetrics lists the compared metrics, higher tells whether a higher
+// value is better.
+var compareMetrics = []struct {
+	name   string
+	higher bool
+	get    func(s *ReportStats) float64
+}{
+	{"qps", true, func(s *ReportStats) float64 { return float64(s.QPS) }},
+	{"err", false, func(s *ReportStats) float64 { return s.Err }},
+	{"mean", false, func(s *ReportStats) float64 { return float64(s.Mean) }},
+	{"p50", false, func(s *ReportStats) float64 { return float64(s.P50) }},
+	{"p99", false, func(s *ReportStats) float64 { return float64(s.P99) }},
+	{"p999", false, func(s *ReportStats) float64 { return float64(s.P999) }},
+}
+
+// CompareStats compares the metrics of one scope.
+func CompareStats(scope string, base, cand *ReportStats, opt *CompareOptions) (cs []*Comparison) {
+	for _, m := range compareMetrics {
+		c := &Comparison{Scope: scope, Metric: m.name, Baseline: m.get(base), Candidate: m.get(cand)}
+		// delta > 0 is worse
+		delta := c.Candidate - c.Baseline
+		if m.higher {
+			delta = -delta
+		}
+		switch m.name {
+		case "err":
+			c.Change = c.Candidate - c.Baseline
+			c.Regressed = delta > opt.ErrTolerance
+			c.Improved = -delta > opt.ErrTolerance
+		default:
+			if c.Baseline != 0 {
+				c.Change = 100 * (c.Candidate - c.Baseline) / c.Baseline
+			}
+			significant := c.Baseline != 0 && 100*abs(delta)/c.Baseline > opt.Tolerance
+			if m.name != "qps" && abs(delta) < float64(opt.MinDelta) {
+				significant = false
+			}
+			c.Regressed = significant && delta > 0
+			c.Improved = significant && delta < 0
+		}
+		cs = append(cs, c)
+	}
+	return cs
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// CompareReports lines up the whole run and every command of both files.
+// missing lists the commands present in only one of them.
+func CompareReports(base, cand *RunReport, opt *CompareOptions) (cs []*Comparison, missing []string) {
+	cs = CompareStats("total", base.Total, cand.Total, opt)
+	names := make([]string, 0, len(base.Cmds))
+	for name := range base.Cmds {
+		names = append(names, name)
+	}
+	for name := range cand.Cmds {
+		if base.Cmds[name] == nil {
+			missing = append(missing, name+" only in candidate")
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b, c := base.Cmds[name], cand.Cmds[name]
+		if c == nil {
+			missing = append(missing, name+" only in baseline")
+			continue
+		}
+		if b.Num < opt.MinNum {
+			continue
+		}
+		cs = append(cs, CompareStats(name, b, c, opt)...)
+	}
+	sort.Strings(missing)
+	return cs, missing
+}
+
+// FormatComparisons renders the regression report.
+func FormatComparisons(cs []*Comparison, missing []string) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%-16s %-6s %14s %14s %10s\n", "scope", "metric", "baseline", "candidate", "change")
+	for _, c := range cs {
+		verdict := ""
+		switch {
+		case c.Regressed:
+			verdict = "REGRESSION"
+		case c.Improved:
+			verdict = "improved"
+		}
+		unit := "%"
+		if c.Metric == "err" {
+			unit = "pp"
+		}
+		fmt.Fprintf(&b, "%-16s %-6s %14.2f %14.2f %+9.2f%s %s\n", c.Scope, c.Metric, c.Baseline, c.Candidate, c.Change, unit, verdict)
+	}
+	for _, m := range missing {
+		fmt.Fprintf(&b, "%s\n", m)
+	}
+	return b.String()
+}
+
+// RunCompare is the compare command:
+//
+//	redis-perf compare [-tolerance 5] [-min-delta 20] baseline.yml candidate.yml
+//
+// it exits 2 when a metric regressed beyond the tolerance, 1 on usage or
+// read errors.
+func RunCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	opt := &CompareOptions{}
+	fs.Float64Var(&opt.Tolerance, "tolerance", 5, "relative change in percent accepted as noise")
+	fs.Int64Var(&opt.MinDelta, "min-delta", 20, "latency changes below this many us are noise")
+	fs.Float64Var(&opt.ErrTolerance, "err-tolerance", 0.01, "error rate change in percentage points accepted as noise")
+	fs.Int64Var(&opt.MinNum, "min-num", 100, "skip commands with fewer requests in the baseline")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: redis-perf compare [flags] baseline.yml candidate.yml")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 1
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+
+	base, err := LoadRunReport(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	cand, err := LoadRunReport(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	cs, missing := CompareReports(base, cand, opt)
+	fmt.Printf("baseline  %s %s %s\ncandidate %s %s %s\n\n", fs.Arg(0), base.Addr, base.Version, fs.Arg(1), cand.Addr, cand.Version)
+	fmt.Print(FormatComparisons(cs, missing))
+	regressed := 0
+	for _, c := range cs {
+		if c.Regressed {
+			regressed++
+		}
+	}
+	if regressed > 0 {
+		fmt.Printf("\n%d metrics regressed beyond %.1f%%\n", regressed, opt.Tolerance)
+		return 2
+	}
+	fmt.Println("\nno regression")
+	return 0
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestCompareReports(t *testing.T) {
+	opt := &CompareOptions{Tolerance: 5, MinDelta: 20, ErrTolerance: 0.01, MinNum: 100}
+	base := &RunReport{
+		Total: &ReportStats{QPS: 10000, Num: 100000, Mean: 200, P50: 150, P99: 1000, P999: 3000},
+		Cmds: map[string]*ReportStats{
+			"GET":  {QPS: 5000, Num: 50000, P99: 900},
+			"HSET": {QPS: 10, Num: 10, P99: 100},
+			"SET":  {QPS: 5000, Num: 50000},
+		},
+	}
+	cand := &RunReport{
+		// qps -2% is noise, mean +10us under min-delta, p99 +20% regressed
+		Total: &ReportStats{QPS: 9800, Num: 98000, Err: 0.005, Mean: 210, P50: 100, P99: 1200, P999: 3000},
+		Cmds: map[string]*ReportStats{
+			"GET":  {QPS: 5000, Num: 50000, Err: 0.5, P99: 900},
+			"HSET": {QPS: 10, Num: 10, P99: 10000},
+			"LPOP": {QPS: 1, Num: 1},
+		},
+	}
+	cs, missing := CompareReports(base, cand, opt)
+
+	regressed, improved := map[string]bool{}, map[string]bool{}
+	for _, c := range cs {
+		if c.Regressed {
+			regressed[c.Scope+"."+c.Metric] = true
+		}
+		if c.Improved {
+			improved[c.Scope+"."+c.Metric] = true
+		}
+		if c.Scope == "HSET" {
+			t.Fatal("HSET is below min-num")
+		}
+	}
+	for _, want := range []string{"total.p99", "GET.err"} {
+		if !regressed[want] {
+			t.Fatalf("expect %s regressed, get %v", want, regressed)
+		}
+	}
+	if len(regressed) != 2 {
+		t.Fatalf("expect 2 regressions, get %v", regressed)
+	}
+	if !improved["total.p50"] || len(improved) != 1 {
+		t.Fatalf("expect total.p50 improved, get %v", improved)
+	}
+	if len(missing) != 2 || missing[0] != "LPOP only in candidate" || missing[1] != "SET only in baseline" {
+		t.Fatalf("bad missing commands %v", missing)
+	}
+}
+
+func TestRunCompareUsage(t *testing.T) {
+	if code := RunCompare([]string{"-nope", "a.yml", "b.yml"}); code != 1 {
+		t.Fatalf("expect 1 on a bad flag, get %d", code)
+	}
+	if code := RunCompare([]string{"a.yml"}); code != 1 {
+		t.Fatalf("expect 1 on a missing file, get %d", code)
+	}
+	if p := abPath("out/run.yml", "b"); p != "out/run.b.yml" {
+		t.Fatalf("expect out/run.b.yml, get %s", p)
+	}
+}
@@ -5,8 +5,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math/rand"
 	"os"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -39,6 +40,78 @@ type Config struct {
 	QPS       int64
 	Loop      int64
 	Debug     bool
+	// Manifest is where the run manifest is written, From replays one
+	Manifest string
+	From     string
+	// Record is where the command stream is recorded, Replay sends one
+	Record string
+	Replay string
+	Speed  float64
+	// Monitor replays a MONITOR log, Remap rewrites its keys
+	Monitor string
+	Remap   string
+	// AB is the second target of an A/B comparison
+	AB       string
+	Duration time.Duration
+	// Mirror is a candidate server receiving every command too
+	Mirror string
+	// Assert are the thresholds checked at the end of the run, -assert and
+	// the assert list of the param file
+	Assert     string
+	Assertions []*Assertion
+	// Out is the result file, see the compare command
+	Out string
+	// Info polls INFO of the targets every interval
+	Info bool
+	// Slowlog collects SLOWLOG of the targets, LatencyMonitor is the
+	// latency-monitor-threshold in ms set meanwhile
+	Slowlog        bool
+	LatencyMonitor int64
+	// Workload names the executors run, Cache counts nil reads as misses
+	Workload string
+	Cache    bool
+	// Queue runs the queue consumers, Pop is their command
+	Queue     bool
+	Pop       string
+	Consumers int
+	// Group runs the stream consumers, one message in NoAck is left
+	// pending, ClaimIdle is the idle time before XCLAIM
+	Group     bool
+	NoAck     int64
+	ClaimIdle time.Duration
+	// PubSub runs Subscribers subscribers per channel, with PSUBSCRIBE or
+	// with SSUBSCRIBE and SPUBLISH when Sharded
+	PubSub      bool
+	Subscribers int
+	PSubscribe  bool
+	Sharded     bool
+	// Script loads the libraries of the functions before the run, see
+	// LoadFunctions
+	Script bool
+	// Batch is the number of keys or fields of the multi-key commands,
+	// Cluster keeps the keys of one command in one hash slot
+	Batch   int
+	Cluster bool
+	// TTLCheck checks that one TTL write in TTLCheck expires, see
+	// ExpiryChecker
+	TTLCheck int64
+	// Counter reads the counters back at the end of the run, see
+	// CounterChecker
+	Counter bool
+	// Model checks every read against the writes of the worker, see
+	// ConsistencyModel
+	Model bool
+	// Audit reads every write back at the end of the run and reports the
+	// missing ones per AuditWindow, see WriteAudit
+	Audit       bool
+	AuditWindow time.Duration
+	// Scan checks the SCAN family against the writes of the workers, set by
+	// the scan workload and ScanJobs, the background jobs fully iterating
+	// with ScanCmds. ScanCount is the COUNT of every call
+	Scan      bool
+	ScanJobs  int
+	ScanCmds  string
+	ScanCount int
 }
 
 // Param ...
@@ -51,92 +124,80 @@ type Param struct {
 	SetSize       int64
 	SortedSetNum  int64
 	SortedSetSize int64
+	ListNum       int64
+	ListSize      int64
+	// QueueNum is the number of queues of the queue workload, shared by
+	// every worker
+	QueueNum int64
+	// StreamNum is the number of streams of the stream workload, StreamSize
+	// the MAXLEN they are trimmed to
+	StreamNum  int64
+	StreamSize int64
+	// GroupNum is the number of streams of the group workload, shared by
+	// every worker like the queues
+	GroupNum int64
+	// ChannelNum is the number of channels of the pubsub workload, shared by
+	// every worker
+	ChannelNum int64
+	// HotNum is the number of hot keys the WATCH transactions contend on,
+	// shared by every worker
+	HotNum int64
+	// CounterNum is the number of counters of the counter workload
+	CounterNum int64
+	// GeoNum is the number of geo keys of the geo workload, GeoSize the
+	// members of each
+	GeoNum  int64
+	GeoSize int64
+	// HLLNum is the number of HyperLogLogs of the hll workload, HLLSize the
+	// distinct elements added to them
+	HLLNum  int64
+	HLLSize int64
+	// BitmapNum is the number of bitmaps of the bitmap workload, BitmapSize
+	// their length in bits
+	BitmapNum  int64
+	BitmapSize int64
+
+	// Prefix is the run namespace, the ${prefix} of the key templates
+	Prefix            string
+	KeyTemplate       *KeyTemplate
+	HashTemplate      *KeyTemplate
+	SetTemplate       *KeyTemplate
+	SortedSetTemplate *KeyTemplate
+	ListTemplate      *KeyTemplate
+	QueueTemplate     *KeyTemplate
+	StreamTemplate    *KeyTemplate
+	GroupTemplate     *KeyTemplate
+	ChannelTemplate   *KeyTemplate
+	HotTemplate       *KeyTemplate
+	CounterTemplate   *KeyTemplate
+	GeoTemplate       *KeyTemplate
+	HLLTemplate       *KeyTemplate
+	BitmapTemplate    *KeyTemplate
+
+	// TTL is the distribution of the expirations of the ttl workload
+	TTL *TTLParam
+	// Score is the distribution of the sorted set scores
+	Score *ScoreParam
+	// Geo is the area and the search radius of the geo workload
+	Geo *GeoParam
+
+	// Scripts are run with EVALSHA and Functions with FCALL by the script
+	// workload, see ScriptParam
+	Scripts   []*ScriptParam `yaml:",omitempty"`
+	Functions []*ScriptParam `yaml:",omitempty"`
+	// Transactions are the MULTI/EXEC blocks of the tx workload, see TxParam
+	Transactions []*TxParam `yaml:",omitempty"`
+
+	// Assert are checked at the end of the run, see Assertion
+	Assert []string
 }
 
-// RandomGen ...
-type RandomGen struct {
-	Param *Param
-	Num   int64
-	Range []*RangeParam
-	Seed  []uint8
-	Rand  []*rand.Rand
-}
-
-// RangeParam ...
-type RangeParam struct {
-	KeyMin        int64
-	KeySize       int64
-	HashMin       int64
-	HashSize      int64
-	SetMin        int64
-	SetSize       int64
-	SortedSetMin  int64
-	SortedSetSize int64
-}
-
-// SortedSet gen random hash key ...
-func (rg *RandomGen) SortedSet(id int) string {
-	r := rg.Range[id]
-	n := rg.Rand[id].Int63n(r.SortedSetSize) + r.SortedSetMin
-	return fmt.Sprintf("sortedset_%012d_%012d_%012d", n, n, n)
-}
-
-// SortedSetField ...
-func (rg *RandomGen) SortedSetField(id int) string {
-	n := rg.Rand[id].Int63n(rg.Param.SortedSetSize)
-	return fmt.Sprintf("sortedset_%012d_%012d_%012d", n, n, n)
-}
-
-// Set gen random hash key ...
-func (rg *RandomGen) Set(id int) string {
-	r := rg.Range[id]
-	n := rg.Rand[id].Int63n(r.SetSize) + r.SetMin
-	return fmt.Sprintf("set_%012d_%012d_%012d", n, n, n)
-}
-
-// SetField ...
-func (rg *RandomGen) SetField(id int) string {
-	n := rg.Rand[id].Int63n(rg.Param.SetSize)
-	return fmt.Sprintf("set_%012d_%012d_%012d", n, n, n)
-}
-
-// Hash gen random hash key ...
-func (rg *RandomGen) Hash(id int) string {
-	r := rg.Range[id]
-	n := rg.Rand[id].Int63n(r.HashSize) + r.HashMin
-	return fmt.Sprintf("hash_%012d_%012d_%012d", n, n, n)
-}
-
-// HashField ...
-func (rg *RandomGen) HashField(id int) string {
-	n := rg.Rand[id].Int63n(rg.Param.HashSize)
-	return fmt.Sprintf("hash_%012d_%012d_%012d", n, n, n)
-}
-
-// Key gen random normal key ...
-func (rg *RandomGen) Key(id int) string {
-	r := rg.Range[id]
-	n := rg.Rand[id].Int63n(r.KeySize) + r.KeyMin
-	return fmt.Sprintf("key_%012d_%012d_%012d", n, n, n)
-}
-
-// Value ...
-func (rg *RandomGen) Value(id int) string {
-	v := make([]uint8, rg.Param.ValueLen)
-	for i := range v {
-		v[i] = rg.Seed[rg.Rand[id].Intn(len(rg.Seed))]
-	}
-	return string(v)
-}
-
-// Score ...
-func (rg *RandomGen) Score(id int) int {
-	return rg.Rand[id].Int()%10 ^ 5
-}
+var (
+	configFile string
+	multiply   int64
+)
 
 func init() {
-	var configFile string
-	var multiply int64
 	flag.StringVar(&configFile, "p", "param.yml", "path to param config file")
 	flag.StringVar(&Conf.Addr, "a", "127.0.0.1:6379", "redis server address")
 	flag.IntVar(&Conf.DebugPort, "d", 7379, "perf debug address")
@@ -145,82 +206,186 @@ func init() {
 	flag.Int64Var(&RGen.Num, "n", 100, "concurrency number")
 	flag.Int64Var(&Conf.Loop, "l", -1, "reconnect every l requests, l <= 0 means long connection")
 	flag.BoolVar(&Conf.Debug, "debug", false, "debug")
+	flag.Int64Var(&RGen.RandSeed, "seed", 0, "random seed, every worker draws its own stream of it, -1 picks one from the clock")
+	flag.StringVar(&Conf.Manifest, "manifest", "", "write the run manifest to this file")
+	flag.StringVar(&Conf.From, "from", "", "replay the command stream of a run manifest")
+	flag.StringVar(&Conf.Record, "record", "", "record every command sent to this file, .gz compresses")
+	flag.StringVar(&Conf.Replay, "replay", "", "replay a recorded command stream instead of generating one")
+	flag.Float64Var(&Conf.Speed, "speed", 1, "replay speed, 2 is twice as fast, 0 is as fast as possible")
+	flag.StringVar(&Conf.Monitor, "monitor", "", "replay a redis-cli MONITOR log, clients are spread over -n connections")
+	flag.StringVar(&Conf.Remap, "remap", "", "rewrite key prefixes of -monitor, from=to[,from=to]")
+	flag.StringVar(&Conf.AB, "ab", "", "compare -a with this address, both driven by the same command stream")
+	flag.DurationVar(&Conf.Duration, "duration", 0, "stop after this long and print the summary, 0 runs until ctrl-c")
+	flag.StringVar(&Conf.Mirror, "mirror", "", "send every command to this candidate server too and diff the replies with -a")
+	flag.BoolVar(&Conf.Info, "info", false, "poll INFO of the target every second and show the server side next to the client side")
+	flag.BoolVar(&Conf.Slowlog, "slowlog", false, "reset SLOWLOG of the target at start and show its entries next to the intervals they happened in")
+	flag.Int64Var(&Conf.LatencyMonitor, "latency-monitor", 0, "enable LATENCY monitoring of the target with this threshold in ms during the run, implies -slowlog")
+	flag.StringVar(&Conf.Workload, "workload", AllExecutor.Name, "executors to run, a name or weighted names: \"cache=3,key=1\"")
+	flag.BoolVar(&Conf.Cache, "cache", false, "cache mode: nil replies of reads are misses instead of errors, implied by the cache workload")
+	flag.StringVar(&Conf.Pop, "pop", "brpop", "command of the queue consumers: brpop, blpop or blmove")
+	flag.IntVar(&Conf.Consumers, "consumers", 0, "queue or stream consumers, 0 is one per queue or stream")
+	flag.Int64Var(&Conf.NoAck, "noack", 0, "stream consumers leave one message in this many unacknowledged, 0 acknowledges all")
+	flag.DurationVar(&Conf.ClaimIdle, "claim-idle", 5*time.Second, "pending stream messages idle this long are claimed with XCLAIM")
+	flag.IntVar(&Conf.Subscribers, "subscribers", 1, "subscriber connections per channel of the pubsub workload")
+	flag.BoolVar(&Conf.PSubscribe, "psubscribe", false, "subscribe with PSUBSCRIBE to a pattern matching each channel")
+	flag.BoolVar(&Conf.Sharded, "sharded", false, "sharded pub/sub: SPUBLISH and SSUBSCRIBE, redis 7.0")
+	flag.IntVar(&Conf.Batch, "batch", 10, "keys or fields per multi-key command of the batch workload")
+	flag.BoolVar(&Conf.Cluster, "cluster", false, "redis cluster: the keys of one multi-key command share a hash tag, see the key templates")
+	flag.Int64Var(&Conf.TTLCheck, "ttl-check", 0, "check that one key written with a TTL in this many is gone after its TTL, 0 is off")
+	flag.BoolVar(&Conf.Model, "model", false, "check every GET and HGET against the last acknowledged write of the worker, over the whole run")
+	flag.BoolVar(&Conf.Audit, "audit", false, "read every key, field and member written back at the end of the run and report the acknowledged writes missing")
+	flag.DurationVar(&Conf.AuditWindow, "audit-window", 10*time.Second, "time window of the audit report")
+	flag.IntVar(&Conf.ScanJobs, "scan-jobs", 0, "background jobs fully iterating the keyspace and the collections next to the workload")
+	flag.StringVar(&Conf.ScanCmds, "scan-cmds", "scan,hscan,sscan,zscan", "commands the scan jobs iterate with, in turn")
+	flag.IntVar(&Conf.ScanCount, "scan-count", 100, "COUNT of the SCAN, HSCAN, SSCAN and ZSCAN calls")
+	flag.StringVar(&Conf.Out, "out", "", "write the results of the run to this file, see redis-perf compare")
+	flag.StringVar(&Conf.Assert, "assert", "", "thresholds checked at the end, exit 2 when violated: \"qps>=50000,err<=0.1,p99<2ms,GET.p999<=1ms\"")
+}
 
+// ParseConfig parses the command line and prepares the random generator.
+func ParseConfig() {
 	flag.Parse()
+
+	var manifest *Manifest
+	if Conf.From != "" {
+		var err error
+		if manifest, err = LoadManifest(Conf.From); err == nil {
+			err = manifest.ApplyFlags()
+		}
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		RGen.RandSeed = manifest.Seed
+	}
+	if RGen.RandSeed == -1 {
+		RGen.RandSeed = time.Now().UnixNano()
+	}
+
+	if Conf.LatencyMonitor > 0 {
+		Conf.Slowlog = true
+	}
+
 	if Conf.QPS <= 0 {
 		log.Println("qps should not less than 0")
 		os.Exit(0)
 	}
 
 	//random generator
-	RGen.Param = LoadParam(configFile).Multiply(multiply)
-	if RGen.Param.KeyNum < RGen.Num || RGen.Param.HashNum < RGen.Num || RGen.Param.SetNum < RGen.Num || RGen.Param.SortedSetNum < RGen.Num {
-		log.Println("concurrency number should not less than KeyNum, HashNum, SetNum and SortedSetNum", RGen.Param.KeyNum, RGen.Param.HashNum, RGen.Param.SetNum, RGen.Param.SortedSetNum)
-		os.Exit(0)
+	if manifest != nil {
+		RGen.Param = manifest.Param.Default()
+	} else {
+		RGen.Param = LoadParam(configFile).Multiply(multiply)
 	}
-
-	keys0 := RGen.Param.KeyNum / RGen.Num
-	keys1 := keys0 + 1
-	keyn0 := keys1*RGen.Num - RGen.Param.KeyNum
-	// keyn1 := RGen.Param.KeyNum - keys0*RGen.Num
-
-	hashs0 := RGen.Param.HashNum / RGen.Num
-	hashs1 := hashs0 + 1
-	hashn0 := hashs1*RGen.Num - RGen.Param.HashNum
-	// hashn1 := RGen.Param.HashNum - hashs0*RGen.Num
-
-	sets0 := RGen.Param.SetNum / RGen.Num
-	sets1 := sets0 + 1
-	setn0 := sets1*RGen.Num - RGen.Param.SetNum
-	// setn1 := RGen.Param.SetNum - sets0*RGen.Num
-
-	sortedsets0 := RGen.Param.SortedSetNum / RGen.Num
-	sortedsets1 := sortedsets0 + 1
-	sortedsetn0 := sortedsets1*RGen.Num - RGen.Param.SortedSetNum
-	// sortedsetn1 := RGen.Param.SortedSetNum - sortedsets0*RGen.Num
-
-	RGen.Range = make([]*RangeParam, RGen.Num)
-	for i := range RGen.Range {
-		r := &RangeParam{}
-		//key
-		if int64(i) < keyn0 {
-			r.KeyMin = int64(i) * keys0
-			r.KeySize = keys0
-		} else {
-			r.KeyMin = keyn0*keys0 + (int64(i)-keyn0)*keys1
-			r.KeySize = keys1
+	if RGen.Param.KeyNum < RGen.Num || RGen.Param.HashNum < RGen.Num || RGen.Param.SetNum < RGen.Num || RGen.Param.SortedSetNum < RGen.Num || RGen.Param.ListNum < RGen.Num || RGen.Param.StreamNum < RGen.Num || RGen.Param.CounterNum < RGen.Num ||
+		RGen.Param.GeoNum < RGen.Num || RGen.Param.HLLNum < RGen.Num || RGen.Param.BitmapNum < RGen.Num {
+		log.Println("concurrency number should not less than KeyNum, HashNum, SetNum, SortedSetNum, ListNum, StreamNum, CounterNum, GeoNum, HLLNum and BitmapNum", RGen.Param.KeyNum, RGen.Param.HashNum, RGen.Param.SetNum, RGen.Param.SortedSetNum, RGen.Param.ListNum, RGen.Param.StreamNum, RGen.Param.CounterNum, RGen.Param.GeoNum, RGen.Param.HLLNum, RGen.Param.BitmapNum)
+		os.Exit(1)
+	}
+	if err := RGen.Param.Compile(); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	assertions, err := ParseAssertions(append(RGen.Param.Assert, Conf.Assert))
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	Conf.Assertions = assertions
+	BuildScripts(RGen.Param)
+	BuildTransactions(RGen.Param)
+	workload, names, err := ParseWorkload(Conf.Workload)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	Workload = workload
+	if Conf.Pop != "brpop" && Conf.Pop != "blpop" && Conf.Pop != "blmove" {
+		log.Println("pop should be brpop, blpop or blmove")
+		os.Exit(1)
+	}
+	if Conf.Batch <= 0 {
+		log.Println("batch should be larger than 0")
+		os.Exit(1)
+	}
+	if Conf.AuditWindow <= 0 {
+		log.Println("audit-window should be larger than 0")
+		os.Exit(1)
+	}
+	if Conf.ScanCount <= 0 {
+		log.Println("scan-count should be larger than 0")
+		os.Exit(1)
+	}
+	for _, cmd := range strings.Split(Conf.ScanCmds, ",") {
+		if !scanCmds[cmd] {
+			log.Printf("scan-cmds: unknown command %s, scan, hscan, sscan or zscan\n", cmd)
+			os.Exit(1)
 		}
-		//hash
-		if int64(i) < hashn0 {
-			r.HashMin = int64(i) * hashs0
-			r.HashSize = hashs0
-		} else {
-			r.HashMin = hashn0*hashs0 + (int64(i)-hashn0)*hashs1
-			r.HashSize = hashs1
+	}
+	Conf.Scan = Conf.ScanJobs > 0
+	if Conf.PSubscribe && Conf.Sharded {
+		log.Println("psubscribe and sharded exclude each other, there are no sharded patterns")
+		os.Exit(1)
+	}
+	for _, name := range names {
+		if name == CacheExecutor.Name {
+			Conf.Cache = true
+		}
+		if name == QueueExecutor.Name {
+			Conf.Queue = true
+		}
+		if name == GroupExecutor.Name {
+			Conf.Group = true
+		}
+		if name == PubSubExecutor.Name {
+			Conf.PubSub = true
 		}
-		//set
-		if int64(i) < setn0 {
-			r.SetMin = int64(i) * sets0
-			r.SetSize = sets0
-		} else {
-			r.SetMin = setn0*sets0 + (int64(i)-setn0)*sets1
-			r.SetSize = sets1
+		if name == CounterExecutor.Name {
+			Conf.Counter = true
 		}
-		//sortedset
-		if int64(i) < sortedsetn0 {
-			r.SortedSetMin = int64(i) * sortedsets0
-			r.SortedSetSize = sortedsets0
-		} else {
-			r.SortedSetMin = sortedsetn0*sortedsets0 + (int64(i)-sortedsetn0)*sortedsets1
-			r.SortedSetSize = sortedsets1
+		if name == ScriptExecutor.Name {
+			Conf.Script = true
 		}
+		if name == ModelExecutor.Name {
+			Conf.Model = true
+		}
+		if name == ScanExecutor.Name {
+			Conf.Scan = true
+		}
+		if name == BatchExecutor.Name && Conf.Cluster {
+			if err := RGen.Param.CheckClusterTags(); err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+		}
+		if name == HLLExecutor.Name && Conf.Cluster {
+			if err := checkClusterTag("hll", RGen.Param.HLLTemplate); err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+		}
+	}
+	for _, name := range names {
+		if (Conf.Model || Conf.Audit || Conf.Scan) && (name == ScriptExecutor.Name || name == TxExecutor.Name) {
+			log.Printf("model: the %s workload writes keys the model does not follow\n", name)
+			os.Exit(1)
+		}
+	}
+	RGen.Init()
 
-		RGen.Range[i] = r
+	if manifest != nil {
+		if err := manifest.Verify(); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
 	}
-	RGen.Rand = make([]*rand.Rand, RGen.Num)
-	for i := range RGen.Rand {
-		RGen.Rand[i] = rand.New(rand.NewSource(int64(i)))
+	if Conf.Manifest != "" {
+		if err := NewManifest().Save(Conf.Manifest); err != nil {
+			log.Println("write manifest error", err)
+			os.Exit(1)
+		}
 	}
+	log.Println("seed", RGen.RandSeed, "version", Version)
 
 	if Conf.Debug {
 		for _, r := range RGen.Range {
@@ -273,15 +438,190 @@ func (param *Param) Default() *Param {
 	if param.SortedSetSize == 0 {
 		param.SortedSetSize = 50
 	}
+	if param.ListNum == 0 {
+		param.ListNum = 5000
+	}
+	if param.ListSize == 0 {
+		param.ListSize = 50
+	}
+	if param.QueueNum == 0 {
+		param.QueueNum = 16
+	}
+	if param.StreamNum == 0 {
+		param.StreamNum = 5000
+	}
+	if param.StreamSize == 0 {
+		param.StreamSize = 1000
+	}
+	if param.GroupNum == 0 {
+		param.GroupNum = 16
+	}
+	if param.ChannelNum == 0 {
+		param.ChannelNum = 16
+	}
+	if param.HotNum == 0 {
+		param.HotNum = 16
+	}
+	if param.CounterNum == 0 {
+		param.CounterNum = 5000
+	}
+	if param.GeoNum == 0 {
+		param.GeoNum = 5000
+	}
+	if param.GeoSize == 0 {
+		param.GeoSize = 50
+	}
+	if param.HLLNum == 0 {
+		param.HLLNum = 5000
+	}
+	if param.HLLSize == 0 {
+		param.HLLSize = 10000
+	}
+	if param.BitmapNum == 0 {
+		param.BitmapNum = 5000
+	}
+	if param.BitmapSize == 0 {
+		param.BitmapSize = 8192
+	}
+	if param.KeyTemplate == nil {
+		param.KeyTemplate = defaultTemplate()
+	}
+	if param.HashTemplate == nil {
+		param.HashTemplate = defaultTemplate()
+	}
+	if param.SetTemplate == nil {
+		param.SetTemplate = defaultTemplate()
+	}
+	if param.SortedSetTemplate == nil {
+		param.SortedSetTemplate = defaultTemplate()
+	}
+	if param.ListTemplate == nil {
+		param.ListTemplate = defaultTemplate()
+	}
+	if param.QueueTemplate == nil {
+		param.QueueTemplate = defaultTemplate()
+	}
+	if param.StreamTemplate == nil {
+		param.StreamTemplate = defaultTemplate()
+	}
+	if param.GroupTemplate == nil {
+		param.GroupTemplate = defaultTemplate()
+	}
+	if param.ChannelTemplate == nil {
+		param.ChannelTemplate = defaultTemplate()
+	}
+	if param.HotTemplate == nil {
+		param.HotTemplate = defaultTemplate()
+	}
+	if param.CounterTemplate == nil {
+		param.CounterTemplate = defaultTemplate()
+	}
+	if param.GeoTemplate == nil {
+		param.GeoTemplate = defaultTemplate()
+	}
+	if param.HLLTemplate == nil {
+		param.HLLTemplate = defaultTemplate()
+	}
+	if param.BitmapTemplate == nil {
+		param.BitmapTemplate = defaultTemplate()
+	}
+	if param.TTL == nil {
+		param.TTL = &TTLParam{}
+	}
+	param.TTL.Default()
+	if param.Score == nil {
+		param.Score = &ScoreParam{}
+	}
+	param.Score.Default()
+	if param.Geo == nil {
+		param.Geo = &GeoParam{}
+	}
+	param.Geo.Default()
+	if param.Scripts == nil && param.Functions == nil {
+		param.Scripts = defaultScripts()
+	}
+	if param.Transactions == nil {
+		param.Transactions = defaultTransactions()
+	}
 	return param
 }
 
+// Compile compiles the key templates of every data type and checks the
+// distributions.
+func (param *Param) Compile() error {
+	templates := []struct {
+		typ string
+		t   *KeyTemplate
+	}{
+		{"key", param.KeyTemplate},
+		{"hash", param.HashTemplate},
+		{"set", param.SetTemplate},
+		{"sortedset", param.SortedSetTemplate},
+		{"list", param.ListTemplate},
+		{"queue", param.QueueTemplate},
+		{"stream", param.StreamTemplate},
+		{"group", param.GroupTemplate},
+		{"channel", param.ChannelTemplate},
+		{"hot", param.HotTemplate},
+		{"counter", param.CounterTemplate},
+		{"geo", param.GeoTemplate},
+		{"hll", param.HLLTemplate},
+		{"bitmap", param.BitmapTemplate},
+	}
+	for _, tt := range templates {
+		if err := tt.t.Compile(param.Prefix, tt.typ); err != nil {
+			return fmt.Errorf("%s template: %v", tt.typ, err)
+		}
+	}
+	if param.QueueTemplate.PerWorker() {
+		return fmt.Errorf("queue template: ${worker} is not allowed, the queues are shared")
+	}
+	if param.GroupTemplate.PerWorker() {
+		return fmt.Errorf("group template: ${worker} is not allowed, the streams are shared")
+	}
+	if param.ChannelTemplate.PerWorker() {
+		return fmt.Errorf("channel template: ${worker} is not allowed, the channels are shared")
+	}
+	if param.HotTemplate.PerWorker() {
+		return fmt.Errorf("hot template: ${worker} is not allowed, the hot keys are shared")
+	}
+	if err := param.TTL.Check(); err != nil {
+		return fmt.Errorf("ttl: %v", err)
+	}
+	if err := param.Score.Check(); err != nil {
+		return fmt.Errorf("score: %v", err)
+	}
+	if err := param.Geo.Check(); err != nil {
+		return fmt.Errorf("geo: %v", err)
+	}
+	if param.BitmapSize < 8 {
+		return fmt.Errorf("bitmap: expect BitmapSize >= 8, get %d", param.BitmapSize)
+	}
+	for _, s := range append(append([]*ScriptParam{}, param.Scripts...), param.Functions...) {
+		if err := s.Check(); err != nil {
+			return fmt.Errorf("script %v", err)
+		}
+	}
+	for _, tx := range param.Transactions {
+		if err := tx.Check(); err != nil {
+			return fmt.Errorf("transaction %v", err)
+		}
+	}
+	return nil
+}
+
 // Multiply ...
 func (param *Param) Multiply(multiply int64) *Param {
 	param.KeyNum *= multiply
 	param.HashNum *= multiply
 	param.SetNum *= multiply
 	param.SortedSetNum *= multiply
+	param.ListNum *= multiply
+	param.StreamNum *= multiply
+	param.CounterNum *= multiply
+	param.GeoNum *= multiply
+	param.HLLNum *= multiply
+	param.BitmapNum *= multiply
 
 	return param
 }
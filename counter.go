@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// CounterExecutor increments the counters of the worker, CounterChecker
+// reads them back at the end of the run.
+var CounterExecutor = &RandomExecutor{Name: "counter"}
+
+func init() {
+	Executors = append(Executors, CounterExecutor)
+
+	// INCR of a counter
+	CounterExecutor.Add("incr", 10, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Counter(id)
+		c := Counters.Sent(id, key, "", 1)
+
+		conn.Send("INCR", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("INCR %s", key),
+			valid: Counters.valid(id, c, 1),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// INCRBY of a counter
+	CounterExecutor.Add("incrby", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Counter(id)
+		delta := RGen.Rand[id].Int63n(1000) + 1
+		c := Counters.Sent(id, key, "", delta)
+
+		conn.Send("INCRBY", key, delta)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("INCRBY %s %d", key, delta),
+			valid: Counters.valid(id, c, delta),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// DECR of a counter
+	CounterExecutor.Add("decr", 3, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Counter(id)
+		c := Counters.Sent(id, key, "", -1)
+
+		conn.Send("DECR", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("DECR %s", key),
+			valid: Counters.valid(id, c, -1),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// HINCRBY of a counter field of a hash, up or down
+	CounterExecutor.Add("hincrby", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Hash(id)
+		field := RGen.CounterField(id)
+		delta := RGen.Rand[id].Int63n(200) - 100
+		c := Counters.Sent(id, key, field, delta)
+
+		conn.Send("HINCRBY", key, field, delta)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("HINCRBY %s %s %d", key, field, delta),
+			valid: Counters.valid(id, c, delta),
+		})
+		conn.Flush()
+
+		return rs
+	})
+}
+
+// Counters is the counter checker of the run, nil unless the counter
+// workload runs.
+var Counters *CounterChecker
+
+// keyField is a key, or a field of a hash.
+type keyField struct {
+	key   string
+	field string
+}
+
+func (k keyField) String() string {
+	if k.field == "" {
+		return k.key
+	}
+	return k.key + " " + k.field
+}
+
+// counter is what a worker knows of one of its counters. Base is the value
+// before the run, derived from the first reply, Acked the sum of the
+// acknowledged increments. Pending are the increments sent and not replied
+// yet, Unsure those whose reply was lost with the connection, they may or
+// may not have been applied; both are sums of absolute values.
+type counter struct {
+	Base    int64
+	Known   bool
+	Acked   int64
+	Pending int64
+	Unsure  int64
+}
+
+// workerCounters are the counters of one worker, written by its writer and
+// its reader.
+type workerCounters struct {
+	mu       sync.Mutex
+	counters map[keyField]*counter
+}
+
+// CounterChecker tracks the increments of every worker to its counters, the
+// workers own their counters so each knows their exact value. Replies of
+// one connection come in order: when an increment is replied every one sent
+// before on the counter is accounted.
+type CounterChecker struct {
+	addr    string
+	workers []*workerCounters
+	// Checked counters held their expected value, Lost counters were below
+	// it and Duplicated above, by LostSum and DuplicatedSum in total.
+	// Unconfirmed were off within their unsure increments
+	Checked       int64
+	Lost          int64
+	LostSum       int64
+	Duplicated    int64
+	DuplicatedSum int64
+	Unconfirmed   int64
+}
+
+// NewCounterChecker tracks the counters written to addr, nil unless the
+// counter workload runs.
+func NewCounterChecker(addr string) *CounterChecker {
+	if !Conf.Counter {
+		return nil
+	}
+	c := &CounterChecker{addr: addr, workers: make([]*workerCounters, len(RGen.Range))}
+	for i := range c.workers {
+		c.workers[i] = &workerCounters{counters: map[keyField]*counter{}}
+	}
+	return c
+}
+
+func absInt(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Sent accounts delta sent by worker id to the counter field of key, field
+// is empty for a string counter.
+func (c *CounterChecker) Sent(id int, key, field string, delta int64) *counter {
+	if c == nil {
+		return nil
+	}
+	w := c.workers[id]
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	k := keyField{key, field}
+	cnt := w.counters[k]
+	if cnt == nil {
+		cnt = &counter{}
+		w.counters[k] = cnt
+	}
+	cnt.Pending += absInt(delta)
+	return cnt
+}
+
+// valid accounts the reply to delta sent to cnt and checks the new value
+// once the base is known and no increment is unsure.
+func (c *CounterChecker) valid(id int, cnt *counter, delta int64) func(reply interface{}, err error) error {
+	return func(reply interface{}, err error) error {
+		value, err := redis.Int64(reply, err)
+		if c == nil {
+			return err
+		}
+		w := c.workers[id]
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		cnt.Pending -= absInt(delta)
+		if err != nil {
+			if _, ok := err.(redis.Error); !ok {
+				// the connection failed, the increment may have been applied
+				cnt.Unsure += absInt(delta)
+			}
+			return err
+		}
+		cnt.Acked += delta
+		if !cnt.Known {
+			cnt.Base, cnt.Known = value-cnt.Acked, true
+			return nil
+		}
+		if expect := cnt.Base + cnt.Acked; cnt.Unsure == 0 && value != expect {
+			return fmt.Errorf("expect %d, get %d", expect, value)
+		}
+		return nil
+	}
+}
+
+// expected returns the counters of worker w with their expected value and
+// the tolerance of their unsure increments, the counters without any reply
+// are left out.
+func (w *workerCounters) expected() (keys []keyField, expect, tolerance []int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for k, cnt := range w.counters {
+		if !cnt.Known {
+			continue
+		}
+		keys = append(keys, k)
+		expect = append(expect, cnt.Base+cnt.Acked)
+		tolerance = append(tolerance, cnt.Unsure+cnt.Pending)
+	}
+	return keys, expect, tolerance
+}
+
+// Verify reads every counter back on its own connection and compares it
+// with the acknowledged increments. It runs once the workers stopped.
+func (c *CounterChecker) Verify() error {
+	conn, err := redis.Dial("tcp", c.addr, redis.DialConnectTimeout(time.Second),
+		redis.DialReadTimeout(5*time.Second), redis.DialWriteTimeout(5*time.Second))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	const batch = 1000
+	for _, w := range c.workers {
+		keys, expect, tolerance := w.expected()
+		for start := 0; start < len(keys); start += batch {
+			end := start + batch
+			if end > len(keys) {
+				end = len(keys)
+			}
+			for _, k := range keys[start:end] {
+				if k.field == "" {
+					conn.Send("GET", k.key)
+				} else {
+					conn.Send("HGET", k.key, k.field)
+				}
+			}
+			if err := conn.Flush(); err != nil {
+				return err
+			}
+			for i := start; i < end; i++ {
+				value, err := redis.Int64(conn.Receive())
+				if err == redis.ErrNil {
+					value, err = 0, nil
+				}
+				if err != nil {
+					return fmt.Errorf("%s: %v", keys[i], err)
+				}
+				c.account(keys[i], expect[i], value, tolerance[i])
+			}
+		}
+	}
+	return nil
+}
+
+// account compares the value of counter k with expect.
+func (c *CounterChecker) account(k keyField, expect, value, tolerance int64) {
+	diff := value - expect
+	switch {
+	case diff == 0:
+		c.Checked++
+		return
+	case absInt(diff) <= tolerance:
+		c.Unconfirmed++
+		return
+	case diff < 0:
+		c.Lost++
+		c.LostSum -= diff
+	default:
+		c.Duplicated++
+		c.DuplicatedSum += diff
+	}
+	if Conf.Debug || c.Lost+c.Duplicated <= mirrorLogLimit {
+		log.Printf("counter check: %s expect %d, get %d, %+d\n", k, expect, value, diff)
+	}
+}
+
+// Report summarizes Verify.
+func (c *CounterChecker) Report() string {
+	return fmt.Sprintf("counter check: ok %d\tlost %d (-%d)\tduplicated %d (+%d)\tunconfirmed %d",
+		c.Checked, c.Lost, c.LostSum, c.Duplicated, c.DuplicatedSum, c.Unconfirmed)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestCounterValid(t *testing.T) {
+	c := &CounterChecker{workers: []*workerCounters{{counters: map[keyField]*counter{}}}}
+	// the counter held 10 before the run, two increments are pipelined
+	a := c.Sent(0, "k", "", 5)
+	b := c.Sent(0, "k", "", -2)
+	if err := c.valid(0, a, 5)(int64(15), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.valid(0, b, -2)(int64(13), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.valid(0, c.Sent(0, "k", "", 1), 1)(int64(15), nil); err == nil {
+		t.Fatal("expect 14 to be checked")
+	}
+	// a rejected increment is not applied, a lost reply may be
+	if err := c.valid(0, c.Sent(0, "k", "", 3), 3)(nil, redis.Error("READONLY")); err == nil {
+		t.Fatal("expect the error")
+	}
+	if err := c.valid(0, c.Sent(0, "k", "", 4), 4)(nil, errors.New("EOF")); err == nil {
+		t.Fatal("expect the error")
+	}
+	if err := c.valid(0, c.Sent(0, "k", "", 1), 1)(int64(100), nil); err != nil {
+		t.Fatalf("expect no check with an unsure increment, get %v", err)
+	}
+
+	keys, expect, tolerance := c.workers[0].expected()
+	if len(keys) != 1 || expect[0] != 10+5-2+1+1 || tolerance[0] != 4 {
+		t.Fatalf("expect k 15 within 4, get %v %v %v", keys, expect, tolerance)
+	}
+}
+
+func TestCounterAccount(t *testing.T) {
+	c := &CounterChecker{}
+	c.account(keyField{key: "a"}, 10, 10, 0)
+	c.account(keyField{key: "b"}, 10, 7, 0)
+	c.account(keyField{key: "c", field: "f"}, 10, 12, 0)
+	c.account(keyField{key: "d"}, 10, 13, 4)
+	if c.Checked != 1 || c.Lost != 1 || c.LostSum != 3 || c.Duplicated != 1 || c.DuplicatedSum != 2 || c.Unconfirmed != 1 {
+		t.Fatalf("unexpected %s", c.Report())
+	}
+}
@@ -2,27 +2,35 @@ package main
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/garyburd/redigo/redis"
 )
 
 var (
-	AllExecutor       = &RandomExecutor{}
-	KeyExecutor       = &RandomExecutor{}
-	HashExecutor      = &RandomExecutor{}
-	SetExecutor       = &RandomExecutor{}
-	SortedSetExecutor = &RandomExecutor{}
+	AllExecutor       = &RandomExecutor{Name: "all"}
+	KeyExecutor       = &RandomExecutor{Name: "key"}
+	HashExecutor      = &RandomExecutor{Name: "hash"}
+	SetExecutor       = &RandomExecutor{Name: "set"}
+	SortedSetExecutor = &RandomExecutor{Name: "sortedset"}
+
+	// Executors lists every executor, for the run manifest
+	Executors = []*RandomExecutor{AllExecutor, KeyExecutor, HashExecutor, SetExecutor, SortedSetExecutor}
+
+	// Workload is the executor run by the workers, see -workload
+	Workload = AllExecutor
 )
 
 func init() {
 	// AllExecutor
-	AllExecutor.Add(5, KeyExecutor.Execute)
-	AllExecutor.Add(2, HashExecutor.Execute)
-	AllExecutor.Add(1, SetExecutor.Execute)
-	AllExecutor.Add(1, SortedSetExecutor.Execute)
+	AllExecutor.Add("key", 5, KeyExecutor.Execute)
+	AllExecutor.Add("hash", 2, HashExecutor.Execute)
+	AllExecutor.Add("set", 1, SetExecutor.Execute)
+	AllExecutor.Add("sortedset", 1, SortedSetExecutor.Execute)
 	// KeyExecutor
 	// set and get
-	KeyExecutor.Add(10, func(conn redis.Conn, id int) (rs []*Request) {
+	KeyExecutor.Add("set_get", 10, func(conn redis.Conn, id int) (rs []*Request) {
 		key := RGen.Key(id)
 		value := RGen.Value(id)
 
@@ -30,7 +38,7 @@ func init() {
 		conn.Send("SET", key, value)
 		rs = append(rs, &Request{
 			Opstr: fmt.Sprintf("SET %s %s", key, value),
-			valid: func(reply interface{}, err error) error {
+			valid: Model.Write(id, key, "", value, func(reply interface{}, err error) error {
 				result, err := redis.String(reply, err)
 				if err != nil {
 					return err
@@ -39,7 +47,7 @@ func init() {
 					return fmt.Errorf("expect OK, get %s", result)
 				}
 				return nil
-			},
+			}),
 		})
 		conn.Flush()
 
@@ -47,7 +55,11 @@ func init() {
 		conn.Send("GET", key)
 		rs = append(rs, &Request{
 			Opstr: fmt.Sprintf("GET %s", key),
-			valid: func(reply interface{}, err error) error {
+			Read:  KeyExecutor.Name,
+			valid: Model.Read(id, key, "", func(reply interface{}, err error) error {
+				if missed(reply, err) {
+					return ErrMiss
+				}
 				result, err := redis.String(reply, err)
 				if err != nil {
 					return err
@@ -56,7 +68,7 @@ func init() {
 					return fmt.Errorf("expect %s, get %s", value, result)
 				}
 				return nil
-			},
+			}),
 		})
 		conn.Flush()
 
@@ -64,7 +76,7 @@ func init() {
 	})
 
 	// set exist del
-	KeyExecutor.Add(3, func(conn redis.Conn, id int) (rs []*Request) {
+	KeyExecutor.Add("set_exists_del", 3, func(conn redis.Conn, id int) (rs []*Request) {
 		key := RGen.Key(id)
 		value := RGen.Value(id)
 
@@ -72,7 +84,7 @@ func init() {
 		conn.Send("SET", key, value)
 		rs = append(rs, &Request{
 			Opstr: fmt.Sprintf("SET %s %s", key, value),
-			valid: func(reply interface{}, err error) error {
+			valid: Model.Write(id, key, "", value, func(reply interface{}, err error) error {
 				result, err := redis.String(reply, err)
 				if err != nil {
 					return err
@@ -81,7 +93,7 @@ func init() {
 					return fmt.Errorf("expect OK, get %s", result)
 				}
 				return nil
-			},
+			}),
 		})
 		conn.Flush()
 
@@ -104,7 +116,7 @@ func init() {
 		conn.Send("DEL", key)
 		rs = append(rs, &Request{
 			Opstr: fmt.Sprintf("DEL %s", key),
-			valid: func(reply interface{}, err error) error {
+			valid: Model.Delete(id, key, "", func(reply interface{}, err error) error {
 				result, err := redis.Int(reply, err)
 				if err != nil {
 					return err
@@ -113,7 +125,7 @@ func init() {
 					return fmt.Errorf("expect 1, get %d", result)
 				}
 				return nil
-			},
+			}),
 		})
 		conn.Flush()
 
@@ -122,7 +134,7 @@ func init() {
 
 	// HashExecutor
 	// hset and hget
-	HashExecutor.Add(10, func(conn redis.Conn, id int) (rs []*Request) {
+	HashExecutor.Add("hset_hget", 10, func(conn redis.Conn, id int) (rs []*Request) {
 		key := RGen.Hash(id)
 		field := RGen.HashField(id)
 		value := RGen.Value(id)
@@ -130,7 +142,7 @@ func init() {
 		conn.Send("HSET", key, field, value)
 		rs = append(rs, &Request{
 			Opstr: fmt.Sprintf("HSET %s %s %s", key, field, value),
-			valid: func(reply interface{}, err error) error {
+			valid: Model.Write(id, key, field, value, func(reply interface{}, err error) error {
 				result, err := redis.Int(reply, err)
 				if err != nil {
 					return err
@@ -139,14 +151,18 @@ func init() {
 					return fmt.Errorf("expect 0 or 1, get %d", result)
 				}
 				return nil
-			},
+			}),
 		})
 		conn.Flush()
 
 		conn.Send("HGET", key, field)
 		rs = append(rs, &Request{
 			Opstr: fmt.Sprintf("HGET %s %s", key, field),
-			valid: func(reply interface{}, err error) error {
+			Read:  HashExecutor.Name,
+			valid: Model.Read(id, key, field, func(reply interface{}, err error) error {
+				if missed(reply, err) {
+					return ErrMiss
+				}
 				result, err := redis.String(reply, err)
 				if err != nil {
 					return err
@@ -155,7 +171,7 @@ func init() {
 					return fmt.Errorf("expect %s, get %s", value, result)
 				}
 				return nil
-			},
+			}),
 		})
 		conn.Flush()
 
@@ -163,7 +179,7 @@ func init() {
 	})
 
 	// hset and hdel
-	HashExecutor.Add(3, func(conn redis.Conn, id int) (rs []*Request) {
+	HashExecutor.Add("hset_hdel", 3, func(conn redis.Conn, id int) (rs []*Request) {
 		key := RGen.Hash(id)
 		field := RGen.HashField(id)
 		value := RGen.Value(id)
@@ -171,7 +187,7 @@ func init() {
 		conn.Send("HSET", key, field, value)
 		rs = append(rs, &Request{
 			Opstr: fmt.Sprintf("HSET %s %s %s", key, field, value),
-			valid: func(reply interface{}, err error) error {
+			valid: Model.Write(id, key, field, value, func(reply interface{}, err error) error {
 				result, err := redis.Int(reply, err)
 				if err != nil {
 					return err
@@ -180,14 +196,14 @@ func init() {
 					return fmt.Errorf("expect 0 or 1, get %d", result)
 				}
 				return nil
-			},
+			}),
 		})
 		conn.Flush()
 
 		conn.Send("HDEL", key, field)
 		rs = append(rs, &Request{
 			Opstr: fmt.Sprintf("HDEL %s %s", key, field),
-			valid: func(reply interface{}, err error) error {
+			valid: Model.Delete(id, key, field, func(reply interface{}, err error) error {
 				result, err := redis.Int(reply, err)
 				if err != nil {
 					return err
@@ -196,7 +212,7 @@ func init() {
 					return fmt.Errorf("expect 1, get %d", result)
 				}
 				return nil
-			},
+			}),
 		})
 		conn.Flush()
 
@@ -204,7 +220,7 @@ func init() {
 	})
 
 	// HLEN and HGETALL
-	HashExecutor.Add(1, func(conn redis.Conn, id int) (rs []*Request) {
+	HashExecutor.Add("hlen_hgetall", 1, func(conn redis.Conn, id int) (rs []*Request) {
 		key := RGen.Hash(id)
 		var length int
 
@@ -243,14 +259,14 @@ func init() {
 
 	// SetExecutor
 	// SADD and SCARD
-	SetExecutor.Add(10, func(conn redis.Conn, id int) (rs []*Request) {
+	SetExecutor.Add("sadd_scard", 10, func(conn redis.Conn, id int) (rs []*Request) {
 		key := RGen.Set(id)
 		field := RGen.SetField(id)
 
 		conn.Send("SADD", key, field)
 		rs = append(rs, &Request{
 			Opstr: fmt.Sprintf("SADD %s %s", key, field),
-			valid: func(reply interface{}, err error) error {
+			valid: Model.Members(id, SetExecutor.Name, key, []string{field}, nil, func(reply interface{}, err error) error {
 				result, err := redis.Int(reply, err)
 				if err != nil {
 					return err
@@ -259,7 +275,7 @@ func init() {
 					return fmt.Errorf("expect 0 or 1, get %d", result)
 				}
 				return nil
-			},
+			}),
 		})
 		conn.Flush()
 
@@ -272,7 +288,7 @@ func init() {
 					return err
 				}
 				if result <= 0 {
-					return fmt.Errorf("expect larger than 0, get %s", result)
+					return fmt.Errorf("expect larger than 0, get %d", result)
 				}
 				return nil
 			},
@@ -283,7 +299,7 @@ func init() {
 	})
 
 	// SCARD and SMEMBERS
-	SetExecutor.Add(1, func(conn redis.Conn, id int) (rs []*Request) {
+	SetExecutor.Add("scard_smembers", 1, func(conn redis.Conn, id int) (rs []*Request) {
 		key := RGen.Set(id)
 		var length int
 
@@ -322,15 +338,15 @@ func init() {
 
 	// SortedSetExecutor
 	// ZADD and ZCARD
-	SortedSetExecutor.Add(10, func(conn redis.Conn, id int) (rs []*Request) {
+	SortedSetExecutor.Add("zadd_zcard", 10, func(conn redis.Conn, id int) (rs []*Request) {
 		key := RGen.SortedSet(id)
 		score := RGen.Score(id)
 		field := RGen.SortedSetField(id)
 
 		conn.Send("ZADD", key, score, field)
 		rs = append(rs, &Request{
-			Opstr: fmt.Sprintf("SADD %s %d %s", key, score, field),
-			valid: func(reply interface{}, err error) error {
+			Opstr: fmt.Sprintf("ZADD %s %s %s", key, formatScore(score), field),
+			valid: Model.Members(id, SortedSetExecutor.Name, key, []string{field}, []string{formatScore(score)}, func(reply interface{}, err error) error {
 				result, err := redis.Int(reply, err)
 				if err != nil {
 					return err
@@ -339,20 +355,20 @@ func init() {
 					return fmt.Errorf("expect 0 or 1, get %d", result)
 				}
 				return nil
-			},
+			}),
 		})
 		conn.Flush()
 
 		conn.Send("ZCARD", key)
 		rs = append(rs, &Request{
-			Opstr: fmt.Sprintf("SCARD %s", key),
+			Opstr: fmt.Sprintf("ZCARD %s", key),
 			valid: func(reply interface{}, err error) error {
 				result, err := redis.Int(reply, err)
 				if err != nil {
 					return err
 				}
 				if result <= 0 {
-					return fmt.Errorf("expect larger than 0, get %s", result)
+					return fmt.Errorf("expect larger than 0, get %d", result)
 				}
 				return nil
 			},
@@ -363,7 +379,7 @@ func init() {
 	})
 
 	// ZCOUNT and ZRANGEBYSCORE
-	SortedSetExecutor.Add(1, func(conn redis.Conn, id int) (rs []*Request) {
+	SortedSetExecutor.Add("zcount_zrangebyscore", 1, func(conn redis.Conn, id int) (rs []*Request) {
 		key := RGen.SortedSet(id)
 		min, max := RGen.Score(id), RGen.Score(id)
 		if min > max {
@@ -373,7 +389,7 @@ func init() {
 
 		conn.Send("ZCOUNT", key, min, max)
 		rs = append(rs, &Request{
-			Opstr: fmt.Sprintf("ZCOUNT %s %d %d", key, min, max),
+			Opstr: fmt.Sprintf("ZCOUNT %s %s %s", key, formatScore(min), formatScore(max)),
 			valid: func(reply interface{}, err error) error {
 				result, err := redis.Int(reply, err)
 				if err != nil {
@@ -387,14 +403,14 @@ func init() {
 
 		conn.Send("ZRANGEBYSCORE", key, min, max)
 		rs = append(rs, &Request{
-			Opstr: fmt.Sprintf("ZRANGEBYSCORE %s %d %d", key, min, max),
+			Opstr: fmt.Sprintf("ZRANGEBYSCORE %s %s %s", key, formatScore(min), formatScore(max)),
 			valid: func(reply interface{}, err error) error {
 				result, err := redis.Strings(reply, err)
 				if err != nil {
 					return err
 				}
 				if len(result) != length {
-					return fmt.Errorf("expect length %d, get %s", length, result)
+					return fmt.Errorf("expect length %d, get %d", length, len(result))
 				}
 				return nil
 			},
@@ -405,9 +421,46 @@ func init() {
 
 }
 
+// ParseWorkload returns the executor of -workload: the name of an executor
+// or weighted names "cache=3,key=1", names are the executors used.
+func ParseWorkload(s string) (e *RandomExecutor, names []string, err error) {
+	byName := map[string]*RandomExecutor{}
+	for _, e := range Executors {
+		byName[e.Name] = e
+	}
+	if e := byName[s]; e != nil {
+		return e, []string{s}, nil
+	}
+	e = &RandomExecutor{Name: "workload"}
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		sub := byName[kv[0]]
+		if sub == nil {
+			return nil, nil, fmt.Errorf("workload %q: unknown executor %s", s, kv[0])
+		}
+		score := int64(1)
+		if len(kv) == 2 {
+			if score, err = strconv.ParseInt(kv[1], 10, 64); err != nil || score <= 0 {
+				return nil, nil, fmt.Errorf("workload %q: bad weight %s", s, kv[1])
+			}
+		}
+		e.Add(sub.Name, score, sub.Execute)
+		names = append(names, sub.Name)
+	}
+	return e, names, nil
+}
+
 // RandomExecutor ...
 type RandomExecutor struct {
-	items []func(conn redis.Conn, id int) (rs []*Request)
+	Name    string
+	Weights []*Weight
+	items   []func(conn redis.Conn, id int) (rs []*Request)
+}
+
+// Weight is the relative frequency of one scenario of an executor.
+type Weight struct {
+	Name  string
+	Score int64
 }
 
 // Execute ...
@@ -416,7 +469,8 @@ func (re *RandomExecutor) Execute(conn redis.Conn, id int) (rs []*Request) {
 }
 
 // Add ...
-func (re *RandomExecutor) Add(score int64, execute func(conn redis.Conn, id int) (rs []*Request)) {
+func (re *RandomExecutor) Add(name string, score int64, execute func(conn redis.Conn, id int) (rs []*Request)) {
+	re.Weights = append(re.Weights, &Weight{Name: name, Score: score})
 	tmp := make([]func(conn redis.Conn, id int) (rs []*Request), score)
 	for i := range tmp {
 		tmp[i] = execute
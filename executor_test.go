@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestExecutorCmds(t *testing.T) {
+	defer func(rg *RandomGen) { RGen = rg }(RGen)
+	RGen = newTestGen()
+	for _, re := range []*RandomExecutor{KeyExecutor, HashExecutor, SetExecutor, SortedSetExecutor} {
+		offset := 0
+		for _, w := range re.Weights {
+			conn := &sendConn{}
+			rs := re.items[offset](conn, 0)
+			offset += int(w.Score)
+			if len(rs) != len(conn.cmds) {
+				t.Fatalf("%s %s: %d requests for %d commands", re.Name, w.Name, len(rs), len(conn.cmds))
+			}
+			for i, r := range rs {
+				if r.Cmd() != conn.cmds[i] {
+					t.Fatalf("%s %s: %s is recorded as %s", re.Name, w.Name, conn.cmds[i], r.Cmd())
+				}
+			}
+		}
+	}
+}
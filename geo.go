@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// GeoParam is the area of the geo members, longitudes from MinLon to MaxLon
+// and latitudes from MinLat to MaxLat, and the Radius in km of the searches.
+type GeoParam struct {
+	MinLon float64
+	MaxLon float64
+	MinLat float64
+	MaxLat float64
+	Radius float64
+}
+
+// geoMaxLat is the latitude limit of the redis geo commands.
+const geoMaxLat = 85.05112878
+
+// Default ...
+func (g *GeoParam) Default() *GeoParam {
+	if g.MinLon == 0 && g.MaxLon == 0 {
+		g.MinLon, g.MaxLon = 116, 117
+	}
+	if g.MinLat == 0 && g.MaxLat == 0 {
+		g.MinLat, g.MaxLat = 39.5, 40.5
+	}
+	if g.Radius == 0 {
+		g.Radius = 5
+	}
+	return g
+}
+
+// Check ...
+func (g *GeoParam) Check() error {
+	if g.MinLon < -180 || g.MaxLon > 180 || g.MinLon > g.MaxLon {
+		return fmt.Errorf("expect -180 <= MinLon <= MaxLon <= 180, get %v %v", g.MinLon, g.MaxLon)
+	}
+	if g.MinLat < -geoMaxLat || g.MaxLat > geoMaxLat || g.MinLat > g.MaxLat {
+		return fmt.Errorf("expect -%v <= MinLat <= MaxLat <= %v, get %v %v", geoMaxLat, geoMaxLat, g.MinLat, g.MaxLat)
+	}
+	if g.Radius <= 0 {
+		return fmt.Errorf("expect Radius > 0, get %v", g.Radius)
+	}
+	return nil
+}
+
+// GeoExecutor adds members to the geo keys of the worker and searches
+// around points of the area, the validators check the distances.
+var GeoExecutor = &RandomExecutor{Name: "geo"}
+
+func init() {
+	Executors = append(Executors, GeoExecutor)
+
+	// GEOADD and GEOPOS of a member
+	GeoExecutor.Add("geoadd_geopos", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Geo(id)
+		member := RGen.GeoMember(id)
+		lon, lat := RGen.GeoPoint(id)
+		slon, slat := formatScore(lon), formatScore(lat)
+
+		conn.Send("GEOADD", key, slon, slat, member)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("GEOADD %s %s %s %s", key, slon, slat, member),
+			valid: func(reply interface{}, err error) error {
+				_, err = redis.Int(reply, err)
+				return err
+			},
+		})
+		conn.Send("GEOPOS", key, member)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("GEOPOS %s %s", key, member),
+			valid: func(reply interface{}, err error) error {
+				result, err := redis.Values(reply, err)
+				if err != nil {
+					return err
+				}
+				if len(result) != 1 {
+					return fmt.Errorf("expect 1 position, get %d", len(result))
+				}
+				plon, plat, err := parsePoint(result[0], nil)
+				if err != nil {
+					return err
+				}
+				// the 52 bit geohash keeps well under 1e-4 degree
+				if math.Abs(plon-lon) > 1e-4 || math.Abs(plat-lat) > 1e-4 {
+					return fmt.Errorf("expect %s %s, get %v %v", slon, slat, plon, plat)
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// GEOSEARCH FROMLONLAT BYRADIUS of a point of the area
+	GeoExecutor.Add("geosearch", 10, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Geo(id)
+		lon, lat := RGen.GeoPoint(id)
+		slon, slat := formatScore(lon), formatScore(lat)
+		radius := RGen.Param.Geo.Radius
+
+		conn.Send("GEOSEARCH", key, "FROMLONLAT", slon, slat, "BYRADIUS", radius, "km", "ASC", "COUNT", 20, "WITHCOORD", "WITHDIST")
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("GEOSEARCH %s FROMLONLAT %s %s BYRADIUS %v km ASC COUNT 20 WITHCOORD WITHDIST", key, slon, slat, radius),
+			valid: func(reply interface{}, err error) error {
+				entries, err := parseGeoSearch(reply, err)
+				if err != nil {
+					return err
+				}
+				if len(entries) > 20 {
+					return fmt.Errorf("expect at most 20 members, get %d", len(entries))
+				}
+				return checkGeoSearch(entries, lon, lat, radius)
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// GEOADD of a member and GEOSEARCH FROMMEMBER around it
+	GeoExecutor.Add("geosearch_member", 2, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Geo(id)
+		member := RGen.GeoMember(id)
+		lon, lat := RGen.GeoPoint(id)
+		slon, slat := formatScore(lon), formatScore(lat)
+		radius := RGen.Param.Geo.Radius
+
+		conn.Send("GEOADD", key, slon, slat, member)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("GEOADD %s %s %s %s", key, slon, slat, member),
+			valid: func(reply interface{}, err error) error {
+				_, err = redis.Int(reply, err)
+				return err
+			},
+		})
+		conn.Send("GEOSEARCH", key, "FROMMEMBER", member, "BYRADIUS", radius, "km", "ASC", "COUNT", 10, "WITHCOORD", "WITHDIST")
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("GEOSEARCH %s FROMMEMBER %s BYRADIUS %v km ASC COUNT 10 WITHCOORD WITHDIST", key, member, radius),
+			valid: func(reply interface{}, err error) error {
+				entries, err := parseGeoSearch(reply, err)
+				if err != nil {
+					return err
+				}
+				if len(entries) == 0 || entries[0].Dist != 0 {
+					return fmt.Errorf("expect %s first at distance 0", member)
+				}
+				return checkGeoSearch(entries, entries[0].Lon, entries[0].Lat, radius)
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// GEOADD of two members and GEODIST between them
+	GeoExecutor.Add("geodist", 3, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Geo(id)
+		m1, m2 := RGen.GeoMember(id), RGen.GeoMember(id)
+		lon1, lat1 := RGen.GeoPoint(id)
+		lon2, lat2 := RGen.GeoPoint(id)
+		if m1 == m2 {
+			lon2, lat2 = lon1, lat1
+		}
+		args := []interface{}{key, formatScore(lon1), formatScore(lat1), m1, formatScore(lon2), formatScore(lat2), m2}
+
+		conn.Send("GEOADD", args...)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("GEOADD %s", joinArgs(args)),
+			valid: func(reply interface{}, err error) error {
+				_, err = redis.Int(reply, err)
+				return err
+			},
+		})
+		conn.Send("GEODIST", key, m1, m2, "km")
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("GEODIST %s %s %s km", key, m1, m2),
+			valid: func(reply interface{}, err error) error {
+				dist, err := redis.Float64(reply, err)
+				if err != nil {
+					return err
+				}
+				expect := geoDist(lon1, lat1, lon2, lat2)
+				if math.Abs(dist-expect) > expect*1e-3+1e-3 {
+					return fmt.Errorf("expect %v km, get %v", expect, dist)
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+}
+
+// earthRadius is the earth radius in km of the redis geo commands.
+const earthRadius = 6372.797560856
+
+// geoDist returns the distance in km between two points, as redis does.
+func geoDist(lon1, lat1, lon2, lat2 float64) float64 {
+	rad := math.Pi / 180
+	u := math.Sin((lat2 - lat1) * rad / 2)
+	v := math.Sin((lon2 - lon1) * rad / 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1*rad)*math.Cos(lat2*rad)*v*v))
+}
+
+// geoEntry is a member of a GEOSEARCH WITHCOORD WITHDIST reply.
+type geoEntry struct {
+	Member   string
+	Dist     float64
+	Lon, Lat float64
+}
+
+// parsePoint parses a longitude and latitude pair.
+func parsePoint(reply interface{}, err error) (lon, lat float64, _ error) {
+	point, err := redis.Strings(reply, err)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(point) != 2 {
+		return 0, 0, fmt.Errorf("expect a longitude and a latitude, get %d values", len(point))
+	}
+	if lon, err = strconv.ParseFloat(point[0], 64); err != nil {
+		return 0, 0, fmt.Errorf("bad longitude %s", point[0])
+	}
+	if lat, err = strconv.ParseFloat(point[1], 64); err != nil {
+		return 0, 0, fmt.Errorf("bad latitude %s", point[1])
+	}
+	return lon, lat, nil
+}
+
+// parseGeoSearch parses a GEOSEARCH WITHCOORD WITHDIST reply.
+func parseGeoSearch(reply interface{}, err error) ([]geoEntry, error) {
+	result, err := redis.Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]geoEntry, len(result))
+	for i, r := range result {
+		values, err := redis.Values(r, nil)
+		if err != nil || len(values) != 3 {
+			return nil, fmt.Errorf("expect member, distance and position, get %v", r)
+		}
+		e := &entries[i]
+		if e.Member, err = redis.String(values[0], nil); err != nil {
+			return nil, err
+		}
+		if e.Dist, err = redis.Float64(values[1], nil); err != nil {
+			return nil, fmt.Errorf("%s: bad distance %v", e.Member, values[1])
+		}
+		if e.Lon, e.Lat, err = parsePoint(values[2], nil); err != nil {
+			return nil, fmt.Errorf("%s: %v", e.Member, err)
+		}
+	}
+	return entries, nil
+}
+
+// checkGeoSearch checks that the entries are within radius km of the center
+// by ascending distance, and that the distances match the positions.
+func checkGeoSearch(entries []geoEntry, lon, lat, radius float64) error {
+	for i, e := range entries {
+		// redis rounds the distances to 4 decimals
+		if e.Dist > radius+1e-4 {
+			return fmt.Errorf("%s: distance %v km out of the radius %v km", e.Member, e.Dist, radius)
+		}
+		if d := geoDist(lon, lat, e.Lon, e.Lat); math.Abs(d-e.Dist) > 1e-3 {
+			return fmt.Errorf("%s: distance %v km, expect %v km from its position", e.Member, e.Dist, d)
+		}
+		if i > 0 && e.Dist < entries[i-1].Dist {
+			return fmt.Errorf("out of order: %s %v before %s %v", entries[i-1].Member, entries[i-1].Dist, e.Member, e.Dist)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGeoDist(t *testing.T) {
+	// Palermo to Catania, GEODIST of the redis documentation
+	if d := geoDist(13.361389, 38.115556, 15.087269, 37.502669); math.Abs(d-166.2742) > 1e-3 {
+		t.Fatalf("expect 166.2742 km, get %v", d)
+	}
+}
+
+func TestCheckGeoSearch(t *testing.T) {
+	lon, lat := 116.5, 40.0
+	near := geoEntry{Member: "a", Lon: 116.51, Lat: 40.0}
+	far := geoEntry{Member: "b", Lon: 116.55, Lat: 40.02}
+	near.Dist = math.Round(geoDist(lon, lat, near.Lon, near.Lat)*1e4) / 1e4
+	far.Dist = math.Round(geoDist(lon, lat, far.Lon, far.Lat)*1e4) / 1e4
+
+	if err := checkGeoSearch([]geoEntry{near, far}, lon, lat, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkGeoSearch([]geoEntry{far, near}, lon, lat, 5); err == nil {
+		t.Fatal("expect out of order")
+	}
+	if err := checkGeoSearch([]geoEntry{near, far}, lon, lat, 2); err == nil {
+		t.Fatal("expect out of the radius")
+	}
+	wrong := near
+	wrong.Dist += 0.5
+	if err := checkGeoSearch([]geoEntry{wrong}, lon, lat, 5); err == nil {
+		t.Fatal("expect a distance mismatch")
+	}
+}
+
+func TestGeoCheck(t *testing.T) {
+	for _, g := range []GeoParam{
+		{MinLon: -181, MaxLon: 0},
+		{MinLon: 10, MaxLon: 5},
+		{MinLat: -86, MaxLat: 0},
+		{Radius: -1},
+	} {
+		if err := (&g).Default().Check(); err == nil {
+			t.Fatalf("%+v: expect an error", g)
+		}
+	}
+	if err := (&GeoParam{}).Default().Check(); err != nil {
+		t.Fatal(err)
+	}
+}
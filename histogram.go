@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math/bits"
+)
+
+const (
+	// histSubBits sub-buckets per power of two, about 3% precision
+	histSubBits = 5
+	histSub     = 1 << histSubBits
+	histBuckets = (64 - histSubBits) * histSub
+)
+
+// Histogram counts latencies in microseconds on log-linear buckets:
+// values below 64 are exact, above every power of two is split in 32.
+type Histogram struct {
+	Counts [histBuckets]int64
+	Total  int64
+	Sum    int64
+	Max    int64
+}
+
+// NewHistogram ...
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+func histBucket(v int64) int {
+	if v < 0 {
+		v = 0
+	}
+	if v < 2*histSub {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - histSubBits - 1
+	return (shift+1)*histSub + int(v>>uint(shift)) - histSub
+}
+
+// histValue is the highest value of bucket i.
+func histValue(i int) int64 {
+	if i < 2*histSub {
+		return int64(i)
+	}
+	shift := uint(i/histSub - 1)
+	low := int64(i%histSub+histSub) << shift
+	return low + (1 << shift) - 1
+}
+
+// Record ...
+func (h *Histogram) Record(v int64) {
+	h.Counts[histBucket(v)]++
+	h.Total++
+	h.Sum += v
+	if v > h.Max {
+		h.Max = v
+	}
+}
+
+// Reset zeroes h.
+func (h *Histogram) Reset() {
+	if h.Total == 0 {
+		return
+	}
+	*h = Histogram{}
+}
+
+// Merge adds o into h.
+func (h *Histogram) Merge(o *Histogram) {
+	if o == nil {
+		return
+	}
+	for i, c := range o.Counts {
+		h.Counts[i] += c
+	}
+	h.Total += o.Total
+	h.Sum += o.Sum
+	if o.Max > h.Max {
+		h.Max = o.Max
+	}
+}
+
+// Mean ...
+func (h *Histogram) Mean() int64 {
+	if h.Total == 0 {
+		return 0
+	}
+	return h.Sum / h.Total
+}
+
+// Percentile returns the latency below which p percent of the values are,
+// p in [0, 100].
+func (h *Histogram) Percentile(p float64) int64 {
+	if h.Total == 0 {
+		return 0
+	}
+	rank := int64(p / 100 * float64(h.Total))
+	if rank >= h.Total {
+		rank = h.Total - 1
+	}
+	var seen int64
+	for i, c := range h.Counts {
+		seen += c
+		if seen > rank {
+			v := histValue(i)
+			if v > h.Max {
+				v = h.Max
+			}
+			return v
+		}
+	}
+	return h.Max
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram()
+	for v := int64(1); v <= 10000; v++ {
+		h.Record(v)
+	}
+	for _, c := range []struct {
+		p    float64
+		want int64
+	}{{50, 5000}, {99, 9900}, {99.9, 9990}, {100, 10000}} {
+		got := h.Percentile(c.p)
+		if got < c.want || float64(got) > float64(c.want)*1.04 {
+			t.Fatalf("p%v: expect about %d, get %d", c.p, c.want, got)
+		}
+	}
+	if h.Mean() != 5000 {
+		t.Fatalf("expect mean 5000, get %d", h.Mean())
+	}
+
+	for v := int64(0); v < 1<<20; v = v*3/2 + 1 {
+		if i := histBucket(v); histValue(i) < v || (i > 0 && histValue(i-1) >= v) {
+			t.Fatalf("%d falls in bucket %d [%d, %d]", v, i, histValue(i-1), histValue(i))
+		}
+	}
+
+	o := NewHistogram()
+	o.Record(1 << 30)
+	h.Merge(o)
+	if h.Total != 10001 || h.Percentile(100) != 1<<30 {
+		t.Fatalf("bad merge: total %d max %d", h.Total, h.Percentile(100))
+	}
+}
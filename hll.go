@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// HLLExecutor adds elements to the HyperLogLogs of the worker, counts and
+// merges them, the validators check the counts within the error of the
+// estimate.
+var HLLExecutor = &RandomExecutor{Name: "hll"}
+
+func init() {
+	Executors = append(Executors, HLLExecutor)
+
+	// PFADD of -batch elements and PFCOUNT
+	HLLExecutor.Add("pfadd_pfcount", 10, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.HLL(id)
+		elements := RGen.HLLElements(id, Conf.Batch)
+		args := make([]interface{}, 0, len(elements)+1)
+		args = append(args, key)
+		for _, e := range elements {
+			args = append(args, e)
+		}
+
+		conn.Send("PFADD", args...)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("PFADD %s", joinArgs(args)),
+			valid: func(reply interface{}, err error) error {
+				_, err = redis.Int(reply, err)
+				return err
+			},
+		})
+		conn.Send("PFCOUNT", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("PFCOUNT %s", key),
+			valid: func(reply interface{}, err error) error {
+				count, err := redis.Int64(reply, err)
+				if err != nil {
+					return err
+				}
+				// at least the elements just added, at most every element
+				return checkHLL(count, int64(len(elements)), RGen.Param.HLLSize)
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// DEL, PFADD of up to 1000 elements and PFCOUNT of exactly them
+	HLLExecutor.Add("pfcount_fresh", 2, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.HLL(id)
+		elements := RGen.HLLElements(id, RGen.Rand[id].Intn(1000)+1)
+		args := make([]interface{}, 0, len(elements)+1)
+		args = append(args, key)
+		for _, e := range elements {
+			args = append(args, e)
+		}
+		n := int64(len(elements))
+
+		conn.Send("DEL", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("DEL %s", key),
+			valid: validInt,
+		})
+		conn.Send("PFADD", args...)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("PFADD %s", joinArgs(args)),
+			valid: func(reply interface{}, err error) error {
+				result, err := redis.Int(reply, err)
+				if err != nil {
+					return err
+				}
+				if result != 1 {
+					return fmt.Errorf("expect 1, get %d", result)
+				}
+				return nil
+			},
+		})
+		conn.Send("PFCOUNT", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("PFCOUNT %s", key),
+			valid: func(reply interface{}, err error) error {
+				count, err := redis.Int64(reply, err)
+				if err != nil {
+					return err
+				}
+				return checkHLL(count, n, n)
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// PFCOUNT of two HyperLogLogs and PFMERGE of them into a third
+	HLLExecutor.Add("pfmerge", 3, func(conn redis.Conn, id int) (rs []*Request) {
+		keys := RGen.HLLs(id, 3)
+		var counts [2]int64
+
+		for i, key := range keys[1:] {
+			i := i
+			conn.Send("PFCOUNT", key)
+			rs = append(rs, &Request{
+				Opstr: fmt.Sprintf("PFCOUNT %s", key),
+				valid: func(reply interface{}, err error) error {
+					counts[i], err = redis.Int64(reply, err)
+					return err
+				},
+			})
+		}
+		conn.Send("PFMERGE", keys[0], keys[1], keys[2])
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("PFMERGE %s %s %s", keys[0], keys[1], keys[2]),
+			valid: validOK,
+		})
+		conn.Send("PFCOUNT", keys[0])
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("PFCOUNT %s", keys[0]),
+			valid: func(reply interface{}, err error) error {
+				count, err := redis.Int64(reply, err)
+				if err != nil {
+					return err
+				}
+				// the union holds at least the elements of each source
+				min := counts[0]
+				if counts[1] > min {
+					min = counts[1]
+				}
+				return checkHLL(count, min, RGen.Param.HLLSize)
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+}
+
+// hllStdErr is the standard error of the estimate of a redis HyperLogLog,
+// 1.04 / sqrt(16384).
+const hllStdErr = 0.0081
+
+// checkHLL checks that count estimates between min and max distinct
+// elements, within 3 standard errors and one element.
+func checkHLL(count, min, max int64) error {
+	lo := float64(min) * (1 - 3*hllStdErr)
+	hi := float64(max) * (1 + 3*hllStdErr)
+	if float64(count) < lo-1 || float64(count) > hi+1 {
+		return fmt.Errorf("expect a count from %d to %d, get %d", min, max, count)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestCheckHLL(t *testing.T) {
+	cases := []struct {
+		count, min, max int64
+		ok              bool
+	}{
+		{10, 10, 10, true},
+		{11, 10, 10, true},
+		{12, 10, 10, false},
+		{10150, 10000, 10000, true},
+		{9700, 10000, 10000, false},
+		{500, 100, 10000, true},
+		{10500, 100, 10000, false},
+	}
+	for _, c := range cases {
+		if err := checkHLL(c.count, c.min, c.max); (err == nil) != c.ok {
+			t.Fatalf("%d of %d to %d: expect ok %v, get %v", c.count, c.min, c.max, c.ok, err)
+		}
+	}
+}
+
+func TestHLLElements(t *testing.T) {
+	rg := &RandomGen{Param: (&Param{HLLSize: 5}).Default(), Num: 1, Seed: []uint8("abc")}
+	if err := rg.Param.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	rg.Init()
+	seen := map[string]bool{}
+	for _, e := range rg.HLLElements(0, 10) {
+		seen[e] = true
+	}
+	if len(seen) != 5 {
+		t.Fatalf("expect the 5 elements once, get %d", len(seen))
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// key positions of a command, see KeyPositions
+const (
+	keysFirst        = iota // the first argument
+	keysAll                 // every argument
+	keysFirstTwo            // the first two arguments
+	keysPairs               // every other argument: MSET k v k v
+	keysButLast             // all but the timeout: BLPOP k k 0
+	keysNumkeys             // EVAL script numkeys k k, BLMPOP 0 numkeys k k
+	keysStore               // ZUNIONSTORE dst numkeys k k
+	keysNumkeysFirst        // ZUNION numkeys k k
+	keysAfterFirst          // all but the first: BITOP AND dst k k
+	keysStreams             // half the arguments after STREAMS: XREAD STREAMS k k id id
+	keysSubcommand          // the argument after the subcommand: OBJECT ENCODING k
+)
+
+// keyCommands lists the commands whose keys are not only the first
+// argument, or that have no key at all (-1).
+var keyCommands = map[string]int{
+	"DEL": keysAll, "UNLINK": keysAll, "EXISTS": keysAll, "TOUCH": keysAll,
+	"MGET": keysAll, "WATCH": keysAll, "PFCOUNT": keysAll, "PFMERGE": keysAll,
+	"SINTER": keysAll, "SUNION": keysAll, "SDIFF": keysAll,
+	"SINTERSTORE": keysAll, "SUNIONSTORE": keysAll, "SDIFFSTORE": keysAll,
+	"RENAME": keysFirstTwo, "RENAMENX": keysFirstTwo, "RPOPLPUSH": keysFirstTwo,
+	"LMOVE": keysFirstTwo, "BLMOVE": keysFirstTwo, "SMOVE": keysFirstTwo,
+	"COPY": keysFirstTwo, "BRPOPLPUSH": keysFirstTwo, "LCS": keysFirstTwo,
+	"ZRANGESTORE": keysFirstTwo, "GEOSEARCHSTORE": keysFirstTwo,
+	"MSET": keysPairs, "MSETNX": keysPairs,
+	"BLPOP": keysButLast, "BRPOP": keysButLast, "BZPOPMIN": keysButLast, "BZPOPMAX": keysButLast,
+	"EVAL": keysNumkeys, "EVALSHA": keysNumkeys, "EVAL_RO": keysNumkeys, "EVALSHA_RO": keysNumkeys,
+	"FCALL": keysNumkeys, "FCALL_RO": keysNumkeys, "BLMPOP": keysNumkeys, "BZMPOP": keysNumkeys,
+	"ZUNIONSTORE": keysStore, "ZINTERSTORE": keysStore, "ZDIFFSTORE": keysStore,
+	"ZUNION": keysNumkeysFirst, "ZINTER": keysNumkeysFirst, "ZDIFF": keysNumkeysFirst,
+	"ZINTERCARD": keysNumkeysFirst, "SINTERCARD": keysNumkeysFirst,
+	"LMPOP": keysNumkeysFirst, "ZMPOP": keysNumkeysFirst,
+	"BITOP": keysAfterFirst,
+	"XREAD": keysStreams, "XREADGROUP": keysStreams,
+	"OBJECT": keysSubcommand, "MEMORY": keysSubcommand, "XINFO": keysSubcommand, "XGROUP": keysSubcommand,
+
+	"PING": -1, "ECHO": -1, "SELECT": -1, "AUTH": -1, "INFO": -1, "MULTI": -1,
+	"EXEC": -1, "DISCARD": -1, "UNWATCH": -1, "SCAN": -1, "KEYS": -1,
+	"DBSIZE": -1, "FLUSHDB": -1, "FLUSHALL": -1, "CONFIG": -1, "CLIENT": -1,
+	"CLUSTER": -1, "COMMAND": -1, "SCRIPT": -1, "FUNCTION": -1, "SLOWLOG": -1,
+	"LATENCY": -1, "TIME": -1, "QUIT": -1, "HELLO": -1,
+	"PUBLISH": -1, "SPUBLISH": -1, "SUBSCRIBE": -1, "PSUBSCRIBE": -1,
+	"RANDOMKEY": -1, "SWAPDB": -1, "WAIT": -1,
+}
+
+// keySubcommands lists the subcommands taking a key right after them, the
+// other subcommands (OBJECT HELP, MEMORY STATS) have no key.
+var keySubcommands = map[string]bool{
+	"OBJECT ENCODING": true, "OBJECT FREQ": true, "OBJECT IDLETIME": true, "OBJECT REFCOUNT": true,
+	"MEMORY USAGE": true,
+	"XINFO STREAM": true, "XINFO GROUPS": true, "XINFO CONSUMERS": true,
+	"XGROUP CREATE": true, "XGROUP DESTROY": true, "XGROUP SETID": true,
+	"XGROUP CREATECONSUMER": true, "XGROUP DELCONSUMER": true,
+}
+
+// KeyPositions returns the indexes of the keys in args, args[0] is the
+// command. Unknown commands are assumed to take their key first.
+func KeyPositions(args []string) (pos []int) {
+	if len(args) < 2 {
+		return nil
+	}
+	cmd := strings.ToUpper(args[0])
+	kind, ok := keyCommands[cmd]
+	if !ok {
+		kind = keysFirst
+	}
+	switch kind {
+	case keysFirst:
+		pos = append(pos, 1)
+	case keysAll:
+		for i := 1; i < len(args); i++ {
+			pos = append(pos, i)
+		}
+	case keysFirstTwo:
+		for i := 1; i < len(args) && i <= 2; i++ {
+			pos = append(pos, i)
+		}
+	case keysPairs:
+		for i := 1; i < len(args); i += 2 {
+			pos = append(pos, i)
+		}
+	case keysButLast:
+		for i := 1; i < len(args)-1; i++ {
+			pos = append(pos, i)
+		}
+	case keysNumkeys:
+		pos = numkeys(pos, args, 2)
+	case keysStore:
+		pos = numkeys(append(pos, 1), args, 2)
+	case keysNumkeysFirst:
+		pos = numkeys(pos, args, 1)
+	case keysAfterFirst:
+		for i := 2; i < len(args); i++ {
+			pos = append(pos, i)
+		}
+	case keysStreams:
+		for i := 1; i < len(args); i++ {
+			if strings.ToUpper(args[i]) == "STREAMS" {
+				n := (len(args) - i - 1) / 2
+				for j := i + 1; j <= i+n; j++ {
+					pos = append(pos, j)
+				}
+				break
+			}
+		}
+	case keysSubcommand:
+		if len(args) > 2 && keySubcommands[cmd+" "+strings.ToUpper(args[1])] {
+			pos = append(pos, 2)
+		}
+	}
+	return pos
+}
+
+// numkeys appends the positions of the keys counted by args[i].
+func numkeys(pos []int, args []string, i int) []int {
+	if len(args) <= i {
+		return pos
+	}
+	n, err := strconv.Atoi(args[i])
+	if err != nil {
+		return pos
+	}
+	for j := i + 1; j < len(args) && j <= i+n; j++ {
+		pos = append(pos, j)
+	}
+	return pos
+}
+
+// KeyRemap rewrites key prefixes, the first matching rule wins.
+type KeyRemap struct {
+	rules [][2]string
+}
+
+// ParseKeyRemap parses "from=to,from=to", an empty from matches every key
+// so "=perf:" prefixes them all.
+func ParseKeyRemap(s string) (*KeyRemap, error) {
+	if s == "" {
+		return nil, nil
+	}
+	km := &KeyRemap{}
+	for _, rule := range strings.Split(s, ",") {
+		eq := strings.IndexByte(rule, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("remap rule %q is not from=to", rule)
+		}
+		km.rules = append(km.rules, [2]string{rule[:eq], rule[eq+1:]})
+	}
+	return km, nil
+}
+
+// Apply rewrites the keys of args in place.
+func (km *KeyRemap) Apply(args []string) {
+	for _, i := range KeyPositions(args) {
+		for _, rule := range km.rules {
+			if strings.HasPrefix(args[i], rule[0]) {
+				args[i] = rule[1] + args[i][len(rule[0]):]
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// LeaderboardExecutor ranks the members of the sorted sets of the worker as
+// a leaderboard does, the validators check the ordering of the replies.
+var LeaderboardExecutor = &RandomExecutor{Name: "leaderboard"}
+
+func init() {
+	Executors = append(Executors, LeaderboardExecutor)
+
+	// ZINCRBY, ZCARD, ZRANK and ZREVRANK of the member
+	LeaderboardExecutor.Add("zincrby_zrank", 10, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.SortedSet(id)
+		member := RGen.SortedSetField(id)
+		incr := RGen.Rand[id].Intn(100) + 1
+		var card, rank int64
+
+		conn.Send("ZINCRBY", key, incr, member)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("ZINCRBY %s %d %s", key, incr, member),
+			valid: Model.Scored(id, key, member, func(reply interface{}, err error) error {
+				_, err = redis.Float64(reply, err)
+				return err
+			}),
+		})
+		conn.Send("ZCARD", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("ZCARD %s", key),
+			valid: func(reply interface{}, err error) error {
+				card, err = redis.Int64(reply, err)
+				return err
+			},
+		})
+		conn.Flush()
+
+		conn.Send("ZRANK", key, member)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("ZRANK %s %s", key, member),
+			valid: func(reply interface{}, err error) error {
+				rank, err = redis.Int64(reply, err)
+				return err
+			},
+		})
+		conn.Send("ZREVRANK", key, member)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("ZREVRANK %s %s", key, member),
+			valid: func(reply interface{}, err error) error {
+				revrank, err := redis.Int64(reply, err)
+				if err != nil {
+					return err
+				}
+				if rank+revrank != card-1 {
+					return fmt.Errorf("expect rank %d + revrank %d = card %d - 1", rank, revrank, card)
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// ZREVRANGE of the top 10 WITHSCORES
+	LeaderboardExecutor.Add("zrevrange_withscores", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.SortedSet(id)
+
+		conn.Send("ZREVRANGE", key, 0, 9, "WITHSCORES")
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("ZREVRANGE %s 0 9 WITHSCORES", key),
+			valid: func(reply interface{}, err error) error {
+				entries, err := parseScores(reply, err)
+				if err != nil {
+					return err
+				}
+				if len(entries) > 10 {
+					return fmt.Errorf("expect at most 10 members, get %d", len(entries))
+				}
+				return checkOrder(entries, true)
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// ZRANGE BYSCORE LIMIT of a score range
+	LeaderboardExecutor.Add("zrange_byscore_limit", 3, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.SortedSet(id)
+		min, max := RGen.Score(id), RGen.Score(id)
+		if min > max {
+			min, max = max, min
+		}
+		smin, smax := formatScore(min), formatScore(max)
+
+		conn.Send("ZRANGE", key, smin, smax, "BYSCORE", "LIMIT", 0, 10, "WITHSCORES")
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("ZRANGE %s %s %s BYSCORE LIMIT 0 10 WITHSCORES", key, smin, smax),
+			valid: func(reply interface{}, err error) error {
+				entries, err := parseScores(reply, err)
+				if err != nil {
+					return err
+				}
+				if len(entries) > 10 {
+					return fmt.Errorf("expect at most 10 members, get %d", len(entries))
+				}
+				for _, e := range entries {
+					if e.Score < min || e.Score > max {
+						return fmt.Errorf("%s: score %v out of [%v, %v]", e.Member, e.Score, min, max)
+					}
+				}
+				return checkOrder(entries, false)
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// ZADD and ZREM of a member
+	LeaderboardExecutor.Add("zadd_zrem", 2, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.SortedSet(id)
+		score := formatScore(RGen.Score(id))
+		member := RGen.SortedSetField(id)
+
+		conn.Send("ZADD", key, score, member)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("ZADD %s %s %s", key, score, member),
+			valid: Model.Members(id, SortedSetExecutor.Name, key, []string{member}, []string{score}, func(reply interface{}, err error) error {
+				_, err = redis.Int(reply, err)
+				return err
+			}),
+		})
+		conn.Flush()
+
+		conn.Send("ZREM", key, member)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("ZREM %s %s", key, member),
+			valid: Model.Remove(id, SortedSetExecutor.Name, key, member, func(reply interface{}, err error) error {
+				result, err := redis.Int(reply, err)
+				if err != nil {
+					return err
+				}
+				if result != 1 {
+					return fmt.Errorf("expect 1, get %d", result)
+				}
+				return nil
+			}),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// ZPOPMIN of the two lowest and ZRANGE of the new lowest
+	LeaderboardExecutor.Add("zpopmin", 1, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.SortedSet(id)
+		var popped []scoreEntry
+
+		conn.Send("ZPOPMIN", key, 2)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("ZPOPMIN %s 2", key),
+			valid: Model.Popped(id, key, func(reply interface{}, err error) error {
+				popped, err = parseScores(reply, err)
+				if err != nil {
+					return err
+				}
+				return checkOrder(popped, false)
+			}),
+		})
+		conn.Flush()
+
+		conn.Send("ZRANGE", key, 0, 0, "WITHSCORES")
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("ZRANGE %s 0 0 WITHSCORES", key),
+			valid: func(reply interface{}, err error) error {
+				entries, err := parseScores(reply, err)
+				if err != nil {
+					return err
+				}
+				if len(popped) > 0 && len(entries) > 0 {
+					return checkOrder([]scoreEntry{popped[len(popped)-1], entries[0]}, false)
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+}
+
+// scoreEntry is a member of a WITHSCORES reply.
+type scoreEntry struct {
+	Member string
+	Score  float64
+}
+
+// less orders the entries as redis does, by score and then by member.
+func (e scoreEntry) less(o scoreEntry) bool {
+	return e.Score < o.Score || e.Score == o.Score && e.Member < o.Member
+}
+
+// parseScores parses a WITHSCORES reply.
+func parseScores(reply interface{}, err error) ([]scoreEntry, error) {
+	result, err := redis.Strings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	if len(result)%2 != 0 {
+		return nil, fmt.Errorf("expect member score pairs, get %d values", len(result))
+	}
+	entries := make([]scoreEntry, len(result)/2)
+	for i := range entries {
+		score, err := strconv.ParseFloat(result[2*i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad score %s", result[2*i+1])
+		}
+		entries[i] = scoreEntry{Member: result[2*i], Score: score}
+	}
+	return entries, nil
+}
+
+// checkOrder checks that entries ascend, or descend when rev.
+func checkOrder(entries []scoreEntry, rev bool) error {
+	for i := 1; i < len(entries); i++ {
+		a, b := entries[i-1], entries[i]
+		if rev {
+			a, b = b, a
+		}
+		if !a.less(b) {
+			return fmt.Errorf("out of order: %s %v before %s %v", entries[i-1].Member, entries[i-1].Score, entries[i].Member, entries[i].Score)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ListExecutor pushes to, pops from and trims the lists of the worker.
+var ListExecutor = &RandomExecutor{Name: "list"}
+
+func init() {
+	Executors = append(Executors, ListExecutor)
+
+	// LPUSH and LLEN
+	ListExecutor.Add("lpush_llen", 10, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.List(id)
+		value := RGen.Value(id)
+
+		conn.Send("LPUSH", key, value)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("LPUSH %s %s", key, value),
+			valid: validPositive,
+		})
+		conn.Flush()
+
+		conn.Send("LLEN", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("LLEN %s", key),
+			valid: validPositive,
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// RPUSH and LRANGE of the tail
+	ListExecutor.Add("rpush_lrange", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.List(id)
+		value := RGen.Value(id)
+
+		conn.Send("RPUSH", key, value)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("RPUSH %s %s", key, value),
+			valid: validPositive,
+		})
+		conn.Flush()
+
+		conn.Send("LRANGE", key, -1, -1)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("LRANGE %s -1 -1", key),
+			valid: func(reply interface{}, err error) error {
+				result, err := redis.Strings(reply, err)
+				if err != nil {
+					return err
+				}
+				if len(result) != 1 || result[0] != value {
+					return fmt.Errorf("expect [%s], get %v", value, result)
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// LPUSH and LPOP
+	ListExecutor.Add("lpush_lpop", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.List(id)
+		value := RGen.Value(id)
+
+		conn.Send("LPUSH", key, value)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("LPUSH %s %s", key, value),
+			valid: validPositive,
+		})
+		conn.Flush()
+
+		conn.Send("LPOP", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("LPOP %s", key),
+			valid: validValue(value),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// RPUSH and RPOP
+	ListExecutor.Add("rpush_rpop", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.List(id)
+		value := RGen.Value(id)
+
+		conn.Send("RPUSH", key, value)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("RPUSH %s %s", key, value),
+			valid: validPositive,
+		})
+		conn.Flush()
+
+		conn.Send("RPOP", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("RPOP %s", key),
+			valid: validValue(value),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// LTRIM to ListSize and LLEN
+	ListExecutor.Add("ltrim_llen", 3, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.List(id)
+		size := RGen.Param.ListSize
+
+		conn.Send("LTRIM", key, 0, size-1)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("LTRIM %s 0 %d", key, size-1),
+			valid: validOK,
+		})
+		conn.Flush()
+
+		conn.Send("LLEN", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("LLEN %s", key),
+			valid: func(reply interface{}, err error) error {
+				result, err := redis.Int64(reply, err)
+				if err != nil {
+					return err
+				}
+				if result > size {
+					return fmt.Errorf("expect at most %d, get %d", size, result)
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// LLEN and LRANGE of the whole list
+	ListExecutor.Add("llen_lrange", 1, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.List(id)
+		var length int
+
+		conn.Send("LLEN", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("LLEN %s", key),
+			valid: func(reply interface{}, err error) error {
+				result, err := redis.Int(reply, err)
+				if err != nil {
+					return err
+				}
+				length = result
+				return nil
+			},
+		})
+		conn.Flush()
+
+		conn.Send("LRANGE", key, 0, -1)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("LRANGE %s 0 -1", key),
+			valid: func(reply interface{}, err error) error {
+				result, err := redis.Strings(reply, err)
+				if err != nil {
+					return err
+				}
+				if len(result) != length {
+					return fmt.Errorf("expect length %d, get %d", length, len(result))
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+}
+
+func validPositive(reply interface{}, err error) error {
+	result, err := redis.Int64(reply, err)
+	if err != nil {
+		return err
+	}
+	if result <= 0 {
+		return fmt.Errorf("expect larger than 0, get %d", result)
+	}
+	return nil
+}
+
+// validValue expects the bulk reply value.
+func validValue(value string) func(reply interface{}, err error) error {
+	return func(reply interface{}, err error) error {
+		result, err := redis.String(reply, err)
+		if err != nil {
+			return err
+		}
+		if result != value {
+			return fmt.Errorf("expect %s, get %s", value, result)
+		}
+		return nil
+	}
+}
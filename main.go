@@ -7,30 +7,280 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
+var (
+	exitMu    sync.Mutex
+	exitHooks []func()
+)
+
+// AtExit registers f to run before the process exits, in reverse order.
+func AtExit(f func()) {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	exitHooks = append(exitHooks, f)
+}
+
+// Exit runs the exit hooks and exits.
+func Exit(code int) {
+	exitMu.Lock()
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
+	}
+	os.Exit(code)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		os.Exit(RunCompare(os.Args[2:]))
+	}
+	ParseConfig()
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	signal.Notify(c, syscall.SIGTERM)
 	go func() {
 		<-c
-		log.Println("ctrl-c or SIGTERM found, exit")
-		os.Exit(0)
+		log.Println("ctrl-c or SIGTERM found, stop")
+		StopRun()
+		<-c
+		Exit(0)
 	}()
+	if Conf.Duration > 0 {
+		time.AfterFunc(Conf.Duration, StopRun)
+	}
 
 	go func() {
 		fmt.Println(http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", Conf.DebugPort), nil))
 	}()
 
+	if Conf.Mirror != "" {
+		m := NewMirror(Conf.Mirror, RGen.Num)
+		AddConnHook(m.Hook)
+		AtExit(func() {
+			m.Drain(drainTimeout)
+			log.Printf("mirror %s against %s\n%s\n", Conf.Mirror, Conf.Addr, m.Report())
+		})
+	}
+
 	addr := Conf.Addr
 	qps := Conf.QPS
 	num := RGen.Num
 	loop := Conf.Loop
 
-	result := NewPerfGen(addr, qps, num, loop)
-	for r := range result {
-		log.Printf("expect %d\tqps %d\tdelay %dus\terr %d\n", qps, r.QPS, r.Delay, r.Err)
+	if Conf.Replay != "" {
+		rr, err := OpenRecord(Conf.Replay)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		replay(rr, rr.Conns)
+	}
+	if Conf.Monitor != "" {
+		remap, err := ParseKeyRemap(Conf.Remap)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		f, err := os.Open(Conf.Monitor)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		mr := NewMonitorReader(f, int(num), remap)
+		AtExit(func() {
+			log.Printf("monitor log: %d lines, %d skipped\n", mr.Lines, mr.Skipped)
+		})
+		replay(mr, int(num))
+	}
+
+	if Conf.AB != "" {
+		for _, target := range []string{addr, Conf.AB} {
+			if err := LoadFunctions(target); err != nil {
+				log.Println(err)
+				Exit(1)
+			}
+		}
+		startRecord(2 * int(num))
+		a, b := NewSummary(), NewSummary()
+		pa, pb := NewInfoPoller(addr, time.Second), NewInfoPoller(Conf.AB, time.Second)
+		sa, sb := NewSlowlogCollector(addr), NewSlowlogCollector(Conf.AB)
+		qa, qb := NewQueueConsumers(addr), NewQueueConsumers(Conf.AB)
+		ga, gb := NewStreamConsumers(addr), NewStreamConsumers(Conf.AB)
+		ba, bb := NewPubSubSubscribers(addr), NewPubSubSubscribers(Conf.AB)
+		ja, jb := NewScanJobs(addr), NewScanJobs(Conf.AB)
+		for r := range NewABGen(addr, Conf.AB, qps, num, loop) {
+			pa.Attach(r[0])
+			pb.Attach(r[1])
+			qa.Attach(r[0])
+			qb.Attach(r[1])
+			ga.Attach(r[0])
+			gb.Attach(r[1])
+			ba.Attach(r[0])
+			bb.Attach(r[1])
+			ja.Attach(r[0])
+			jb.Attach(r[1])
+			log.Println(ABLine(r[0], r[1]))
+			a.Add(r[0])
+			b.Add(r[1])
+		}
+		ra, rb := a.Result(), b.Result()
+		log.Println("total", ABLine(ra, rb))
+		if s := sa.Report(a); s != "" {
+			log.Printf("A %s slow entries\n%s", addr, s)
+		}
+		if s := sb.Report(b); s != "" {
+			log.Printf("B %s slow entries\n%s", Conf.AB, s)
+		}
+		if Conf.Out != "" {
+			saveRun(abPath(Conf.Out, "a"), addr, ra, a.Series)
+			saveRun(abPath(Conf.Out, "b"), Conf.AB, rb, b.Series)
+		}
+		var violations []string
+		for _, v := range CheckAssertions(Conf.Assertions, ra) {
+			violations = append(violations, "A "+v)
+		}
+		for _, v := range CheckAssertions(Conf.Assertions, rb) {
+			violations = append(violations, "B "+v)
+		}
+		finish(violations)
+	}
+
+	if err := LoadFunctions(addr); err != nil {
+		log.Println(err)
+		Exit(1)
+	}
+	startRecord(int(num))
+	summary := NewSummary()
+	poller := NewInfoPoller(addr, time.Second)
+	slow := NewSlowlogCollector(addr)
+	Expiry = NewExpiryChecker(addr)
+	Counters = NewCounterChecker(addr)
+	Model = NewConsistencyModel()
+	consumers := NewQueueConsumers(addr)
+	streams := NewStreamConsumers(addr)
+	subscribers := NewPubSubSubscribers(addr)
+	scans := NewScanJobs(addr)
+	for r := range NewPerfGen(addr, qps, num, loop, 0) {
+		poller.Attach(r)
+		consumers.Attach(r)
+		streams.Attach(r)
+		subscribers.Attach(r)
+		scans.Attach(r)
+		log.Printf("expect %d\t%s\n", qps, r)
+		summary.Add(r)
+	}
+	if consumers != nil {
+		if backlog, err := consumers.Backlog(); err == nil {
+			log.Println("queue backlog", backlog)
+		}
+	}
+	report(summary, slow)
+}
+
+// saveRun writes the results of the run against addr to path.
+func saveRun(path, addr string, r *Result, series []*ReportStats) {
+	rep := NewRunReport(r, series)
+	rep.Addr = addr
+	if err := rep.Save(path); err != nil {
+		log.Println("write results error", err)
+	}
+}
+
+// replay sends src to the target and exits once it is done.
+func replay(src RecordSource, conns int) {
+	startRecord(conns)
+	summary := NewSummary()
+	poller := NewInfoPoller(Conf.Addr, time.Second)
+	slow := NewSlowlogCollector(Conf.Addr)
+	for r := range NewReplay(Conf.Addr, src, conns, Conf.Speed) {
+		poller.Attach(r)
+		log.Println(r)
+		summary.Add(r)
+	}
+	report(summary, slow)
+}
+
+// report prints the whole run and the slow entries of the server, checks
+// the assertions and exits.
+func report(summary *Summary, slow *SlowlogCollector) {
+	r := summary.Result()
+	log.Printf("total %s\n%s%s%s", r, r.CmdReport(), r.CacheReport(), r.ConflictReport())
+	if s := slow.Report(summary); s != "" {
+		log.Printf("%s slow entries\n%s", Conf.Addr, s)
+	}
+	if Conf.Out != "" {
+		saveRun(Conf.Out, Conf.Addr, r, summary.Series)
+	}
+	violations := CheckAssertions(Conf.Assertions, r)
+	if Expiry != nil {
+		log.Println(Expiry.Report())
+		if Expiry.Alive > 0 {
+			violations = append(violations, fmt.Sprintf("expiry check: %d keys alive after their ttl", Expiry.Alive))
+		}
+	}
+	if Model != nil && Conf.Model {
+		log.Println(Model.Report())
+		if n := Model.Violations(); n > 0 {
+			violations = append(violations, fmt.Sprintf("model check: %d reads or deletes broke the model", n))
+		}
+	}
+	if Model != nil && Conf.Audit {
+		audit, err := Model.Audit(Conf.Addr, Conf.AuditWindow)
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("audit: %v", err))
+		}
+		log.Print(audit.Report())
+		if n := audit.Total.Missing + audit.Total.Wrong; n > 0 {
+			violations = append(violations, fmt.Sprintf("audit: %d acknowledged writes missing or wrong", n))
+		}
+	}
+	if r.Scan != nil && r.Scan.Missing > 0 {
+		violations = append(violations, fmt.Sprintf("scan check: %d elements missing from full iterations", r.Scan.Missing))
+	}
+	if Counters != nil {
+		if err := Counters.Verify(); err != nil {
+			violations = append(violations, fmt.Sprintf("counter check: %v", err))
+		}
+		log.Println(Counters.Report())
+		if Counters.Lost+Counters.Duplicated > 0 {
+			violations = append(violations, fmt.Sprintf("counter check: %d counters with lost updates, %d with duplicated ones",
+				Counters.Lost, Counters.Duplicated))
+		}
+	}
+	finish(violations)
+}
+
+// finish exits 2 when an assertion or a correctness check is violated.
+func finish(violations []string) {
+	if len(Conf.Assertions) > 0 && len(violations) == 0 {
+		log.Printf("all %d assertions hold\n", len(Conf.Assertions))
+	}
+	if len(violations) > 0 {
+		log.Printf("%d checks violated:\n\t%s\n", len(violations), strings.Join(violations, "\n\t"))
+		Exit(2)
+	}
+	Exit(0)
+}
+
+// startRecord records the command stream when -record is set.
+func startRecord(conns int) {
+	if Conf.Record == "" {
+		return
+	}
+	rw, err := NewRecordWriter(Conf.Record, conns)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
 	}
+	AddConnHook(rw.Hook)
+	AtExit(func() {
+		if err := rw.Close(); err != nil {
+			log.Println("record", err)
+		}
+	})
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Version is set at build time, see Makefile.
+var Version = "dev"
+
+// manifestFlags are not replayed, they only name input and output files.
+var manifestFlags = map[string]bool{"manifest": true, "from": true, "record": true, "replay": true, "monitor": true, "out": true}
+
+// Manifest records everything the command stream of a run depends on.
+// Loading it with -from replays exactly the same stream.
+type Manifest struct {
+	Version   string
+	Time      time.Time
+	Seed      int64
+	Flags     map[string]string
+	Param     *Param
+	Range     []*RangeParam
+	Executors map[string][]*Weight
+}
+
+// NewManifest captures the resolved configuration of this run.
+func NewManifest() *Manifest {
+	m := &Manifest{
+		Version:   Version,
+		Time:      time.Now(),
+		Seed:      RGen.RandSeed,
+		Flags:     map[string]string{},
+		Param:     RGen.Param,
+		Range:     RGen.Range,
+		Executors: map[string][]*Weight{},
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if !manifestFlags[f.Name] {
+			m.Flags[f.Name] = f.Value.String()
+		}
+	})
+	for _, e := range Executors {
+		m.Executors[e.Name] = e.Weights
+	}
+	return m
+}
+
+// Save writes the manifest as yaml.
+func (m *Manifest) Save(path string) error {
+	content, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// LoadManifest ...
+func LoadManifest(path string) (*Manifest, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := yaml.Unmarshal(content, m); err != nil {
+		return nil, err
+	}
+	if m.Param == nil {
+		return nil, fmt.Errorf("manifest %s has no param", path)
+	}
+	return m, nil
+}
+
+// ApplyFlags restores the recorded flags, flags given on the command line
+// win so a manifest can be replayed against another address.
+func (m *Manifest) ApplyFlags() error {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for name, value := range m.Flags {
+		if set[name] || manifestFlags[name] || flag.Lookup(name) == nil {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("manifest flag %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Verify checks that this binary derives the same stream as the recorded
+// run: same partitions and same executor weights.
+func (m *Manifest) Verify() error {
+	if m.Version != Version {
+		return fmt.Errorf("manifest written by version %s, this is %s", m.Version, Version)
+	}
+	if !reflect.DeepEqual(m.Range, RGen.Range) {
+		return fmt.Errorf("key ranges differ from the manifest, check -n")
+	}
+	for _, e := range Executors {
+		if !reflect.DeepEqual(m.Executors[e.Name], e.Weights) {
+			return fmt.Errorf("weights of executor %s differ from the manifest", e.Name)
+		}
+	}
+	return nil
+}
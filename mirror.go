@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// mirrorLogLimit is the number of divergences logged per command, every one
+// is logged with -debug.
+const mirrorLogLimit = 10
+
+// unordered are the commands whose array reply has no defined order, pairs
+// tells whether the elements come as field value pairs.
+var unordered = map[string]bool{
+	"SMEMBERS": false, "SINTER": false, "SUNION": false, "SDIFF": false,
+	"KEYS": false, "HKEYS": false, "HVALS": false, "HGETALL": true,
+}
+
+// nondeterministic are the commands whose reply legitimately differs
+// between two servers, only the reply type is compared.
+var nondeterministic = map[string]bool{
+	"RANDOMKEY": true, "SRANDMEMBER": true, "SPOP": true, "HRANDFIELD": true,
+	"ZRANDMEMBER": true, "TIME": true, "INFO": true, "SCAN": true, "SSCAN": true,
+	"HSCAN": true, "ZSCAN": true, "XADD": true, "CLIENT": true, "SLOWLOG": true,
+	"LATENCY": true, "MEMORY": true, "OBJECT": true, "DEBUG": true,
+}
+
+// MirrorStat counts the replies compared for one command.
+type MirrorStat struct {
+	Total    int64
+	Diverged int64
+}
+
+// Mirror sends every command to a candidate server too and compares the
+// raw replies of both servers.
+type Mirror struct {
+	perf  *Perf
+	mu    sync.Mutex
+	stats map[string]*MirrorStat
+	// inflight counts the commands sent and not compared yet
+	inflight int64
+}
+
+// NewMirror ...
+func NewMirror(addr string, conns int64) *Mirror {
+	return &Mirror{
+		perf:  &Perf{addr: addr, connTotalNum: conns},
+		stats: map[string]*MirrorStat{},
+	}
+}
+
+// Hook pairs conn with a connection to the candidate, see ConnHook.
+func (m *Mirror) Hook(conn redis.Conn, id int) redis.Conn {
+	c := &mirrorConn{
+		Conn:    conn,
+		cand:    m.perf.GetConn(),
+		m:       m,
+		pending: make(chan []interface{}, 100000),
+		refs:    make(chan mirrorReply, 100000),
+		closed:  make(chan struct{}),
+	}
+	go c.loopCompare()
+	return c
+}
+
+// Drain waits up to timeout for the replies of the candidate still to be
+// compared, the summary then covers every command sent.
+func (m *Mirror) Drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&m.inflight) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (m *Mirror) compare(cmd []interface{}, ref, cand interface{}, refErr, candErr error) {
+	name := strings.ToUpper(fmt.Sprint(cmd[0]))
+	same := sameReply(name, ref, cand, refErr, candErr)
+
+	m.mu.Lock()
+	stat := m.stats[name]
+	if stat == nil {
+		stat = &MirrorStat{}
+		m.stats[name] = stat
+	}
+	stat.Total++
+	if !same {
+		stat.Diverged++
+	}
+	logIt := !same && (Conf.Debug || stat.Diverged <= mirrorLogLimit)
+	m.mu.Unlock()
+
+	if logIt {
+		log.Printf("mirror diverged: %s\n\treference: %s\n\tcandidate: %s\n",
+			formatArgs(cmd), formatReply(ref, refErr), formatReply(cand, candErr))
+	}
+}
+
+// Report returns the per command summary, sorted by command.
+func (m *Mirror) Report() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.stats))
+	for name := range m.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	var total, diverged int64
+	for _, name := range names {
+		s := m.stats[name]
+		total += s.Total
+		diverged += s.Diverged
+		fmt.Fprintf(&b, "%-16s total %d\tdiverged %d\n", name, s.Total, s.Diverged)
+	}
+	fmt.Fprintf(&b, "%-16s total %d\tdiverged %d", "ALL", total, diverged)
+	return b.String()
+}
+
+// mirrorConn sends to the reference and the candidate, Receive returns the
+// reply of the reference as soon as it is read. The replies of the candidate
+// are read and compared by loopCompare, so the latencies measured are those
+// of the reference alone.
+type mirrorConn struct {
+	redis.Conn
+	cand      redis.Conn
+	m         *Mirror
+	pending   chan []interface{}
+	refs      chan mirrorReply
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// mirrorReply is a reply of the reference waiting for its comparison.
+type mirrorReply struct {
+	reply interface{}
+	err   error
+}
+
+func (c *mirrorConn) Send(cmd string, args ...interface{}) error {
+	atomic.AddInt64(&c.m.inflight, 1)
+	c.pending <- append([]interface{}{cmd}, args...)
+	c.cand.Send(cmd, args...)
+	return c.Conn.Send(cmd, args...)
+}
+
+func (c *mirrorConn) Flush() error {
+	c.cand.Flush()
+	return c.Conn.Flush()
+}
+
+func (c *mirrorConn) Receive() (interface{}, error) {
+	reply, err := c.Conn.Receive()
+	c.refs <- mirrorReply{reply, err}
+	return reply, err
+}
+
+// Do sends cmd and returns its reply, like the Do of redigo it expects the
+// replies of the commands sent before to be received already.
+func (c *mirrorConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "" {
+		return nil, c.Flush()
+	}
+	if err := c.Send(cmd, args...); err != nil {
+		return nil, err
+	}
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	return c.Receive()
+}
+
+// loopCompare reads the candidate reply of every command sent and compares
+// it with the reply of the reference, the candidate is closed once the
+// connection is closed and the pending commands are compared. The commands
+// whose reference reply is lost with the connection are dropped.
+func (c *mirrorConn) loopCompare() {
+	defer c.cand.Close()
+	for cmd := range c.pending {
+		ref, ok := c.ref()
+		if _, redisErr := ref.err.(redis.Error); !ok || ref.err != nil && !redisErr {
+			atomic.AddInt64(&c.m.inflight, -1)
+			continue
+		}
+		candReply, candErr := c.cand.Receive()
+		c.m.compare(cmd, ref.reply, candReply, ref.err, candErr)
+		atomic.AddInt64(&c.m.inflight, -1)
+	}
+}
+
+// ref returns the reply of the reference to the next pending command, false
+// when the connection was closed before it was received.
+func (c *mirrorConn) ref() (mirrorReply, bool) {
+	select {
+	case ref := <-c.refs:
+		return ref, true
+	case <-c.closed:
+	}
+	select {
+	case ref := <-c.refs:
+		return ref, true
+	default:
+		return mirrorReply{}, false
+	}
+}
+
+func (c *mirrorConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		close(c.pending)
+	})
+	return c.Conn.Close()
+}
+
+func (c *mirrorConn) Err() error {
+	if err := c.Conn.Err(); err != nil {
+		return err
+	}
+	return c.cand.Err()
+}
+
+// sameReply compares two replies structurally, errors are equal when they
+// have the same prefix (ERR, WRONGTYPE...) as engines word them differently.
+func sameReply(cmd string, a, b interface{}, errA, errB error) bool {
+	_, redisErrA := errA.(redis.Error)
+	_, redisErrB := errB.(redis.Error)
+	if errA != nil || errB != nil {
+		if !redisErrA || !redisErrB {
+			return false
+		}
+		return errPrefix(errA) == errPrefix(errB)
+	}
+	if nondeterministic[cmd] {
+		return fmt.Sprintf("%T", a) == fmt.Sprintf("%T", b)
+	}
+	if pairs, ok := unordered[cmd]; ok {
+		a, b = sortReply(a, pairs), sortReply(b, pairs)
+	}
+	return equalReply(a, b)
+}
+
+func errPrefix(err error) string {
+	s := err.Error()
+	if i := strings.IndexByte(s, ' '); i > 0 {
+		return s[:i]
+	}
+	return s
+}
+
+func equalReply(a, b interface{}) bool {
+	switch a := a.(type) {
+	case nil:
+		return b == nil
+	case int64:
+		bi, ok := b.(int64)
+		return ok && a == bi
+	case string:
+		bs, ok := b.(string)
+		return ok && a == bs
+	case []byte:
+		bb, ok := b.([]byte)
+		return ok && bytes.Equal(a, bb)
+	case []interface{}:
+		bl, ok := b.([]interface{})
+		if !ok || len(a) != len(bl) {
+			return false
+		}
+		for i := range a {
+			if !equalReply(a[i], bl[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// sortReply sorts an array reply, pairs keep field and value together.
+func sortReply(reply interface{}, pairs bool) interface{} {
+	l, ok := reply.([]interface{})
+	if !ok {
+		return reply
+	}
+	step := 1
+	if pairs {
+		step = 2
+	}
+	groups := make([][]interface{}, 0, len(l)/step)
+	for i := 0; i+step <= len(l); i += step {
+		groups = append(groups, l[i:i+step])
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return formatReply(groups[i], nil) < formatReply(groups[j], nil)
+	})
+	sorted := make([]interface{}, 0, len(l))
+	for _, g := range groups {
+		sorted = append(sorted, g...)
+	}
+	return sorted
+}
+
+// formatReply renders a reply for the logs, bulk strings are quoted.
+func formatReply(reply interface{}, err error) string {
+	if err != nil {
+		return "(error) " + err.Error()
+	}
+	switch reply := reply.(type) {
+	case nil:
+		return "(nil)"
+	case int64:
+		return fmt.Sprintf("(integer) %d", reply)
+	case string:
+		return reply
+	case []byte:
+		return fmt.Sprintf("%q", reply)
+	case []interface{}:
+		parts := make([]string, len(reply))
+		for i, r := range reply {
+			parts[i] = formatReply(r, nil)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return fmt.Sprint(reply)
+}
+
+func formatArgs(cmd []interface{}) string {
+	parts := make([]string, len(cmd))
+	for i, arg := range cmd {
+		parts[i] = string(appendArg(nil, arg))
+	}
+	return strings.Join(parts, " ")
+}
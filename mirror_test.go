@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestSameReply(t *testing.T) {
+	b := func(s string) interface{} { return []byte(s) }
+	cases := []struct {
+		cmd        string
+		a, b       interface{}
+		errA, errB error
+		same       bool
+	}{
+		{"GET", b("x"), b("x"), nil, nil, true},
+		{"GET", b("x"), nil, nil, nil, false},
+		{"GET", b("1"), int64(1), nil, nil, false},
+		{"SMEMBERS", []interface{}{b("a"), b("b")}, []interface{}{b("b"), b("a")}, nil, nil, true},
+		{"LRANGE", []interface{}{b("a"), b("b")}, []interface{}{b("b"), b("a")}, nil, nil, false},
+		{"HGETALL", []interface{}{b("f1"), b("1"), b("f2"), b("2")}, []interface{}{b("f2"), b("2"), b("f1"), b("1")}, nil, nil, true},
+		{"HGETALL", []interface{}{b("f1"), b("1"), b("f2"), b("2")}, []interface{}{b("f2"), b("1"), b("f1"), b("2")}, nil, nil, false},
+		{"SRANDMEMBER", b("a"), b("b"), nil, nil, true},
+		{"SET", nil, nil, redis.Error("WRONGTYPE Operation"), redis.Error("WRONGTYPE other words"), true},
+		{"SET", nil, nil, redis.Error("ERR x"), redis.Error("WRONGTYPE x"), false},
+		{"SET", "OK", nil, nil, errors.New("EOF"), false},
+	}
+	for i, c := range cases {
+		if got := sameReply(c.cmd, c.a, c.b, c.errA, c.errB); got != c.same {
+			t.Fatalf("case %d %s: expect %v, get %v", i, c.cmd, c.same, got)
+		}
+	}
+}
+
+// replyConn replies to every command sent with its first argument.
+type replyConn struct {
+	redis.Conn
+	replies chan interface{}
+	block   chan struct{}
+}
+
+func (c *replyConn) Send(cmd string, args ...interface{}) error {
+	c.replies <- args[0]
+	return nil
+}
+
+func (c *replyConn) Flush() error { return nil }
+func (c *replyConn) Close() error { return nil }
+
+func (c *replyConn) Receive() (interface{}, error) {
+	if c.block != nil {
+		<-c.block
+	}
+	return <-c.replies, nil
+}
+
+func TestMirrorConn(t *testing.T) {
+	m := &Mirror{stats: map[string]*MirrorStat{}}
+	block := make(chan struct{})
+	c := &mirrorConn{
+		Conn:    &replyConn{replies: make(chan interface{}, 10)},
+		cand:    &replyConn{replies: make(chan interface{}, 10), block: block},
+		m:       m,
+		pending: make(chan []interface{}, 10),
+		refs:    make(chan mirrorReply, 10),
+		closed:  make(chan struct{}),
+	}
+	go c.loopCompare()
+
+	// the reference reply does not wait for the blocked candidate
+	if reply, err := c.Do("GET", "a"); reply != "a" || err != nil {
+		t.Fatalf("expect a, get %v %v", reply, err)
+	}
+	close(block)
+	c.Close()
+	m.Drain(time.Second)
+	if s := m.stats["GET"]; s == nil || s.Total != 1 || s.Diverged != 0 {
+		t.Fatalf("expect 1 GET compared, get %+v", s)
+	}
+
+	// the reference fails: the commands sent are dropped, not compared
+	c = &mirrorConn{
+		Conn:    &replyConn{replies: make(chan interface{}, 10)},
+		cand:    &replyConn{replies: make(chan interface{}, 10)},
+		m:       m,
+		pending: make(chan []interface{}, 10),
+		refs:    make(chan mirrorReply, 10),
+		closed:  make(chan struct{}),
+	}
+	go c.loopCompare()
+	c.Send("GET", "b")
+	c.Send("GET", "c")
+	c.refs <- mirrorReply{nil, io.EOF}
+	c.Close()
+	start := time.Now()
+	m.Drain(time.Second)
+	if atomic.LoadInt64(&m.inflight) != 0 || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("expect the pending commands dropped, %d in flight", atomic.LoadInt64(&m.inflight))
+	}
+	if s := m.stats["GET"]; s.Total != 1 {
+		t.Fatalf("expect the failed commands not compared, get %+v", s)
+	}
+}
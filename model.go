@@ -0,0 +1,556 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Model is the consistency model of the run, nil unless -model, -audit or a
+// scan check is set.
+var Model *ConsistencyModel
+
+// ModelExecutor writes, deletes and reads back the keys and hash fields of
+// the worker one command at a time, the reads pick keys written earlier in
+// the run so the model checks them across the run. It implies -model.
+var ModelExecutor = &RandomExecutor{Name: "model"}
+
+func init() {
+	Executors = append(Executors, ModelExecutor)
+
+	// SET of a new or a recent key
+	ModelExecutor.Add("set", 3, func(conn redis.Conn, id int) (rs []*Request) {
+		key := modelKey(id, false).key
+		value := RGen.Value(id)
+
+		conn.Send("SET", key, value)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("SET %s %s", key, value),
+			valid: Model.Write(id, key, "", value, validOK),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// GET of a recent key
+	ModelExecutor.Add("get", 6, func(conn redis.Conn, id int) (rs []*Request) {
+		key := modelKey(id, false).key
+
+		conn.Send("GET", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("GET %s", key),
+			valid: Model.Read(id, key, "", validString),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// DEL of a recent key
+	ModelExecutor.Add("del", 1, func(conn redis.Conn, id int) (rs []*Request) {
+		key := modelKey(id, false).key
+
+		conn.Send("DEL", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("DEL %s", key),
+			valid: Model.Delete(id, key, "", validInt),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// HSET of a new or a recent field
+	ModelExecutor.Add("hset", 2, func(conn redis.Conn, id int) (rs []*Request) {
+		k := modelKey(id, true)
+		value := RGen.Value(id)
+
+		conn.Send("HSET", k.key, k.field, value)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("HSET %s %s %s", k.key, k.field, value),
+			valid: Model.Write(id, k.key, k.field, value, validInt),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// HGET of a recent field
+	ModelExecutor.Add("hget", 4, func(conn redis.Conn, id int) (rs []*Request) {
+		k := modelKey(id, true)
+
+		conn.Send("HGET", k.key, k.field)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("HGET %s %s", k.key, k.field),
+			valid: Model.Read(id, k.key, k.field, validString),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// HDEL of a recent field
+	ModelExecutor.Add("hdel", 1, func(conn redis.Conn, id int) (rs []*Request) {
+		k := modelKey(id, true)
+
+		conn.Send("HDEL", k.key, k.field)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("HDEL %s %s", k.key, k.field),
+			valid: Model.Delete(id, k.key, k.field, validInt),
+		})
+		conn.Flush()
+
+		return rs
+	})
+}
+
+// modelKey returns a key, or with field a hash field, of the worker: one
+// written recently, a new one in one of four.
+func modelKey(id int, field bool) keyField {
+	if RGen.Rand[id].Intn(4) > 0 && Model != nil {
+		if k, ok := Model.Recent(id, field); ok {
+			return k
+		}
+	}
+	if field {
+		return keyField{RGen.Hash(id), RGen.HashField(id)}
+	}
+	return keyField{key: RGen.Key(id)}
+}
+
+// validString accepts a bulk string or nil.
+func validString(reply interface{}, err error) error {
+	_, err = redis.String(reply, err)
+	if err == redis.ErrNil {
+		return nil
+	}
+	return err
+}
+
+func validInt(reply interface{}, err error) error {
+	_, err = redis.Int64(reply, err)
+	return err
+}
+
+// modelEntry is the last acknowledged write of a key, a hash field or a
+// set or sorted set member, Kind is the data type, Value the score of a
+// member. At is when its reply was read, Seq orders the writes of a worker.
+type modelEntry struct {
+	Kind    string
+	Value   string
+	Deleted bool
+	At      time.Time
+	Seq     int64
+}
+
+// kindOf returns the data type of a key or of a hash field.
+func kindOf(field string) string {
+	if field == "" {
+		return KeyExecutor.Name
+	}
+	return HashExecutor.Name
+}
+
+// modelRecent is the number of recently written keys, and of fields, the
+// model workload picks from.
+const modelRecent = 1024
+
+// workerModel are the keys and fields written by one worker, fields index
+// the entries by key, recent holds the keys and the hash fields last
+// written. The entries of a key up to forgot[key] are unknown.
+type workerModel struct {
+	mu      sync.Mutex
+	entries map[keyField]*modelEntry
+	fields  map[string]map[string]bool
+	// kinds lists the keys ever written per kind, in order so the scan
+	// checks walk it in chunks, see knownKeys
+	kinds   map[string][]string
+	indexed map[keyField]bool
+	seq     int64
+	forgot  map[string]int64
+	recent  [2][]keyField
+	next    [2]int
+}
+
+// put records e as the last write of k.
+func (w *workerModel) put(k keyField, e *modelEntry) {
+	if w.get(k) == nil && (e.Kind == KeyExecutor.Name || e.Kind == HashExecutor.Name) {
+		w.remember(k)
+	}
+	w.seq++
+	e.Seq = w.seq
+	w.entries[k] = e
+	if w.fields == nil {
+		w.fields = map[string]map[string]bool{}
+	}
+	fields := w.fields[k.key]
+	if fields == nil {
+		fields = map[string]bool{}
+		w.fields[k.key] = fields
+	}
+	fields[k.field] = true
+	if w.indexed == nil {
+		w.indexed, w.kinds = map[keyField]bool{}, map[string][]string{}
+	}
+	if ik := (keyField{e.Kind, k.key}); !w.indexed[ik] {
+		w.indexed[ik] = true
+		w.kinds[e.Kind] = append(w.kinds[e.Kind], k.key)
+	}
+}
+
+// drop makes k unknown.
+func (w *workerModel) drop(k keyField) {
+	delete(w.entries, k)
+	if fields := w.fields[k.key]; fields != nil {
+		delete(fields, k.field)
+		if len(fields) == 0 {
+			delete(w.fields, k.key)
+		}
+	}
+}
+
+// get returns the last write of k, nil when unknown.
+func (w *workerModel) get(k keyField) *modelEntry {
+	e := w.entries[k]
+	if e == nil || e.Seq <= w.forgot[k.key] {
+		return nil
+	}
+	return e
+}
+
+// remember adds k to the recent keys or fields.
+func (w *workerModel) remember(k keyField) {
+	i := 0
+	if k.field != "" {
+		i = 1
+	}
+	if len(w.recent[i]) < modelRecent {
+		w.recent[i] = append(w.recent[i], k)
+		return
+	}
+	w.recent[i][w.next[i]] = k
+	w.next[i] = (w.next[i] + 1) % modelRecent
+}
+
+// ConsistencyModel keeps the last acknowledged write of every key, hash
+// field, set and sorted set member each worker wrote, the workers own their
+// keys so a read must return it. The model is updated by the validators, in
+// the order of the replies: a read sees every write sent before it on the
+// worker. A write whose reply
+// was lost is unknown until the next acknowledged write, as is a key after
+// a violation, so one lost key is reported once.
+type ConsistencyModel struct {
+	workers []*workerModel
+
+	mu sync.Mutex
+	// Checked are the reads and deletes checked against the model. Stale
+	// reads returned another value than the last write, Lost ones no value
+	// or deleted nothing, Resurrected ones a value of a deleted key
+	Checked     int64
+	Stale       int64
+	Lost        int64
+	Resurrected int64
+}
+
+// NewConsistencyModel models the writes of the workers, nil unless -model,
+// -audit or a scan check is set.
+func NewConsistencyModel() *ConsistencyModel {
+	if !Conf.Model && !Conf.Audit && !Conf.Scan {
+		return nil
+	}
+	m := &ConsistencyModel{workers: make([]*workerModel, len(RGen.Range))}
+	for i := range m.workers {
+		m.workers[i] = &workerModel{entries: map[keyField]*modelEntry{}, fields: map[string]map[string]bool{}, forgot: map[string]int64{}}
+	}
+	return m
+}
+
+// rejected tells a reply the server sent, the command was not applied. Other
+// errors lose the reply and the command may have been applied.
+func rejected(err error) bool {
+	_, ok := err.(redis.Error)
+	return ok
+}
+
+// Write wraps valid of a write of value to the field of key, field is empty
+// for a string key.
+func (m *ConsistencyModel) Write(id int, key, field, value string, valid func(reply interface{}, err error) error) func(reply interface{}, err error) error {
+	if m == nil {
+		return valid
+	}
+	return m.WriteMany(id, []keyField{{key, field}}, []string{value}, valid)
+}
+
+// WriteMany wraps valid of a write of values to keys.
+func (m *ConsistencyModel) WriteMany(id int, keys []keyField, values []string, valid func(reply interface{}, err error) error) func(reply interface{}, err error) error {
+	if m == nil {
+		return valid
+	}
+	return func(reply interface{}, err error) error {
+		result := valid(reply, err)
+		if rejected(err) {
+			return result
+		}
+		w := m.workers[id]
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		now := time.Now()
+		for i, k := range keys {
+			if result != nil {
+				w.drop(k)
+				continue
+			}
+			w.put(k, &modelEntry{Kind: kindOf(k.field), Value: values[i], At: now})
+		}
+		return result
+	}
+}
+
+// Delete wraps valid of a delete of the field of key, DEL or HDEL. A reply
+// of 0 for a key the model holds is a lost write.
+func (m *ConsistencyModel) Delete(id int, key, field string, valid func(reply interface{}, err error) error) func(reply interface{}, err error) error {
+	return m.Remove(id, kindOf(field), key, field, valid)
+}
+
+// Remove wraps valid of a delete of member of key of the data type kind,
+// like Delete.
+func (m *ConsistencyModel) Remove(id int, kind, key, member string, valid func(reply interface{}, err error) error) func(reply interface{}, err error) error {
+	if m == nil {
+		return valid
+	}
+	k := keyField{key, member}
+	return func(reply interface{}, err error) error {
+		result := valid(reply, err)
+		if rejected(err) {
+			return result
+		}
+		w := m.workers[id]
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		n, err := redis.Int(reply, err)
+		if err != nil {
+			w.drop(k)
+			return result
+		}
+		now := time.Now()
+		if e := w.get(k); e != nil && !e.Deleted {
+			if n > 0 {
+				m.mu.Lock()
+				m.Checked++
+				m.mu.Unlock()
+			} else if violation := m.violate("lost write", k, e, now, "deleted nothing"); result == nil {
+				result = violation
+			}
+		}
+		w.put(k, &modelEntry{Kind: kind, Deleted: true, At: now})
+		return result
+	}
+}
+
+// Forget wraps valid of a write of key the model does not follow, with a
+// TTL or replacing a whole set, every entry of key is unknown once it is
+// replied.
+func (m *ConsistencyModel) Forget(id int, key string, valid func(reply interface{}, err error) error) func(reply interface{}, err error) error {
+	if m == nil {
+		return valid
+	}
+	return func(reply interface{}, err error) error {
+		result := valid(reply, err)
+		w := m.workers[id]
+		w.mu.Lock()
+		w.forgot[key] = w.seq
+		w.mu.Unlock()
+		return result
+	}
+}
+
+// Members wraps valid of SADD or ZADD, kind, of members to key, values are
+// the scores of a sorted set.
+func (m *ConsistencyModel) Members(id int, kind, key string, members, values []string, valid func(reply interface{}, err error) error) func(reply interface{}, err error) error {
+	if m == nil {
+		return valid
+	}
+	return func(reply interface{}, err error) error {
+		result := valid(reply, err)
+		if rejected(err) {
+			return result
+		}
+		w := m.workers[id]
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		now := time.Now()
+		for i, member := range members {
+			k := keyField{key, member}
+			if result != nil {
+				w.drop(k)
+				continue
+			}
+			e := &modelEntry{Kind: kind, At: now}
+			if values != nil {
+				e.Value = values[i]
+			}
+			w.put(k, e)
+		}
+		return result
+	}
+}
+
+// Scored wraps valid of ZINCRBY of member of key, the model takes the
+// score of the reply.
+func (m *ConsistencyModel) Scored(id int, key, member string, valid func(reply interface{}, err error) error) func(reply interface{}, err error) error {
+	if m == nil {
+		return valid
+	}
+	return func(reply interface{}, err error) error {
+		result := valid(reply, err)
+		if rejected(err) {
+			return result
+		}
+		score, err := redis.Float64(reply, err)
+		w := m.workers[id]
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		k := keyField{key, member}
+		if result != nil || err != nil {
+			w.drop(k)
+			return result
+		}
+		w.put(k, &modelEntry{Kind: SortedSetExecutor.Name, Value: formatScore(score), At: time.Now()})
+		return result
+	}
+}
+
+// Popped wraps valid of ZPOPMIN of key, the members of the reply are gone.
+// A lost reply makes the whole key unknown.
+func (m *ConsistencyModel) Popped(id int, key string, valid func(reply interface{}, err error) error) func(reply interface{}, err error) error {
+	if m == nil {
+		return valid
+	}
+	return func(reply interface{}, err error) error {
+		result := valid(reply, err)
+		if rejected(err) {
+			return result
+		}
+		entries, err := parseScores(reply, err)
+		w := m.workers[id]
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if err != nil {
+			w.forgot[key] = w.seq
+			return result
+		}
+		now := time.Now()
+		for _, e := range entries {
+			w.put(keyField{key, e.Member}, &modelEntry{Kind: SortedSetExecutor.Name, Deleted: true, At: now})
+		}
+		return result
+	}
+}
+
+// Read wraps valid of a read of the field of key, GET or HGET, and checks
+// the reply against the model. In cache mode a nil reply is a miss.
+func (m *ConsistencyModel) Read(id int, key, field string, valid func(reply interface{}, err error) error) func(reply interface{}, err error) error {
+	if m == nil || !Conf.Model {
+		return valid
+	}
+	k := keyField{key, field}
+	return func(reply interface{}, err error) error {
+		result := valid(reply, err)
+		if err != nil {
+			return result
+		}
+		got, err := redis.String(reply, nil)
+		if err != nil && reply != nil {
+			return result
+		}
+		w := m.workers[id]
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		e := w.get(k)
+		if e == nil {
+			return result
+		}
+		now := time.Now()
+		var violation error
+		switch {
+		case e.Deleted && reply != nil:
+			violation = m.violate("resurrected delete", k, e, now, "read a value")
+		case !e.Deleted && reply == nil && !Conf.Cache:
+			violation = m.violate("lost write", k, e, now, "read nil")
+		case !e.Deleted && reply != nil && got != e.Value:
+			violation = m.violate("stale read", k, e, now, "read another value")
+		default:
+			m.mu.Lock()
+			m.Checked++
+			m.mu.Unlock()
+			return result
+		}
+		w.drop(k)
+		if result == nil {
+			result = violation
+		}
+		return result
+	}
+}
+
+// Recent returns a key, or with field a hash field, worker id wrote
+// recently, false when there is none yet.
+func (m *ConsistencyModel) Recent(id int, field bool) (keyField, bool) {
+	i := 0
+	if field {
+		i = 1
+	}
+	w := m.workers[id]
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.recent[i]) == 0 {
+		return keyField{}, false
+	}
+	return w.recent[i][RGen.Rand[id].Intn(len(w.recent[i]))], true
+}
+
+// violate accounts a violation of e found at now, the caller holds the lock
+// of the worker.
+func (m *ConsistencyModel) violate(kind string, k keyField, e *modelEntry, now time.Time, detail string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Checked++
+	switch kind {
+	case "stale read":
+		m.Stale++
+	case "lost write":
+		m.Lost++
+	case "resurrected delete":
+		m.Resurrected++
+	}
+	last := "written"
+	if e.Deleted {
+		last = "deleted"
+	}
+	err := fmt.Errorf("%s: %s %s at %s, %s at %s", kind, k, detail, now.Format(modelTime), last, e.At.Format(modelTime))
+	if Conf.Debug || m.Stale+m.Lost+m.Resurrected <= mirrorLogLimit {
+		log.Println("model", err)
+	}
+	return err
+}
+
+const modelTime = "15:04:05.000"
+
+// Report summarizes the checks.
+func (m *ConsistencyModel) Report() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return fmt.Sprintf("model check: checked %d\tstale %d\tlost %d\tresurrected %d",
+		m.Checked, m.Stale, m.Lost, m.Resurrected)
+}
+
+// Violations returns the number of checks that failed.
+func (m *ConsistencyModel) Violations() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Stale + m.Lost + m.Resurrected
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestConsistencyModel(t *testing.T) {
+	Conf.Model = true
+	defer func() { Conf.Model = false }()
+	m := &ConsistencyModel{workers: []*workerModel{{entries: map[keyField]*modelEntry{}}}}
+	write := func(key, value string, err error) {
+		reply := interface{}(nil)
+		if err == nil {
+			reply = "OK"
+		}
+		m.Write(0, key, "", value, validOK)(reply, err)
+	}
+	read := func(key string, reply interface{}) error {
+		return m.Read(0, key, "", validString)(reply, nil)
+	}
+
+	write("a", "1", nil)
+	if err := read("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	// a rejected write leaves the model, a lost reply makes the key unknown
+	write("a", "2", redis.Error("READONLY"))
+	if err := read("a", []byte("2")); err == nil || m.Stale != 1 {
+		t.Fatalf("expect a stale read, get %v", err)
+	}
+	write("b", "1", nil)
+	write("b", "2", errors.New("EOF"))
+	if err := read("b", []byte("1")); err != nil {
+		t.Fatalf("expect b unknown, get %v", err)
+	}
+
+	write("c", "1", nil)
+	if err := read("c", nil); err == nil || m.Lost != 1 {
+		t.Fatalf("expect a lost write, get %v", err)
+	}
+	// one lost key is reported once
+	if err := read("c", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	write("d", "1", nil)
+	if err := m.Delete(0, "d", "", validInt)(int64(1), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := read("d", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := read("d", []byte("1")); err == nil || m.Resurrected != 1 {
+		t.Fatalf("expect a resurrected delete, get %v", err)
+	}
+
+	write("e", "1", nil)
+	if err := m.Delete(0, "e", "", validInt)(int64(0), nil); err == nil || m.Lost != 2 {
+		t.Fatalf("expect a lost write, get %v", err)
+	}
+	if m.Checked != 7 || m.Violations() != 4 {
+		t.Fatalf("unexpected %s", m.Report())
+	}
+}
+
+func TestModelRecent(t *testing.T) {
+	w := &workerModel{}
+	for i := 0; i < modelRecent+10; i++ {
+		w.remember(keyField{key: "k"})
+	}
+	w.remember(keyField{key: "h", field: "f"})
+	if len(w.recent[0]) != modelRecent || w.next[0] != 10 || len(w.recent[1]) != 1 {
+		t.Fatalf("expect a full ring of keys and one field, get %d %d %d", len(w.recent[0]), w.next[0], len(w.recent[1]))
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MonitorLine is one command of a MONITOR log:
+//
+//	1339518083.107412 [0 127.0.0.1:60866] "set" "foo" "bar\x00"
+type MonitorLine struct {
+	Time   time.Time
+	DB     int
+	Client string
+	Args   []string
+}
+
+// ParseMonitorLine parses a line of MONITOR output.
+func ParseMonitorLine(line string) (*MonitorLine, error) {
+	sp := strings.IndexByte(line, ' ')
+	if sp < 0 {
+		return nil, fmt.Errorf("no timestamp")
+	}
+	ts, err := strconv.ParseFloat(line[:sp], 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad timestamp %q", line[:sp])
+	}
+	sec := int64(ts)
+	m := &MonitorLine{Time: time.Unix(sec, int64((ts-float64(sec))*1e6)*1e3)}
+
+	rest := line[sp+1:]
+	if len(rest) == 0 || rest[0] != '[' {
+		return nil, fmt.Errorf("no client")
+	}
+	end := strings.IndexByte(rest, ']')
+	if end < 0 {
+		return nil, fmt.Errorf("unclosed client")
+	}
+	client := rest[1:end]
+	sp = strings.IndexByte(client, ' ')
+	if sp < 0 {
+		return nil, fmt.Errorf("bad client %q", client)
+	}
+	if m.DB, err = strconv.Atoi(client[:sp]); err != nil {
+		return nil, fmt.Errorf("bad db %q", client[:sp])
+	}
+	m.Client = client[sp+1:]
+
+	if m.Args, err = splitQuoted(rest[end+1:]); err != nil {
+		return nil, err
+	}
+	if len(m.Args) == 0 {
+		return nil, fmt.Errorf("no command")
+	}
+	return m, nil
+}
+
+// splitQuoted splits the "arg" "arg" list written by sdscatrepr.
+func splitQuoted(s string) (args []string, err error) {
+	for i := 0; i < len(s); {
+		if s[i] == ' ' {
+			i++
+			continue
+		}
+		if s[i] != '"' {
+			return nil, fmt.Errorf("unquoted argument at %d", i)
+		}
+		var b []byte
+		for i++; ; i++ {
+			if i >= len(s) {
+				return nil, fmt.Errorf("unclosed quote")
+			}
+			c := s[i]
+			if c == '"' {
+				i++
+				break
+			}
+			if c != '\\' {
+				b = append(b, c)
+				continue
+			}
+			if i++; i >= len(s) {
+				return nil, fmt.Errorf("unclosed quote")
+			}
+			switch s[i] {
+			case 'n':
+				b = append(b, '\n')
+			case 'r':
+				b = append(b, '\r')
+			case 't':
+				b = append(b, '\t')
+			case 'a':
+				b = append(b, '\a')
+			case 'b':
+				b = append(b, '\b')
+			case 'x':
+				if i+2 >= len(s) {
+					return nil, fmt.Errorf("short \\x escape")
+				}
+				v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+				if err != nil {
+					return nil, fmt.Errorf("bad \\x escape")
+				}
+				b = append(b, byte(v))
+				i += 2
+			default:
+				b = append(b, s[i])
+			}
+		}
+		args = append(args, string(b))
+	}
+	return args, nil
+}
+
+// MonitorReader turns a MONITOR log into a RecordSource. Every client keeps
+// its own connection modulo conns, so the commands of a client stay ordered,
+// a SELECT is inserted whenever a connection has to switch database.
+// Commands run by scripts ("lua" client) are skipped, replaying the script
+// runs them again.
+type MonitorReader struct {
+	r       *bufio.Reader
+	conns   int
+	remap   *KeyRemap
+	start   time.Time
+	clients map[string]int
+	db      []int
+	pending *Record
+
+	// Lines and Skipped count the lines read and the lines ignored
+	Lines   int64
+	Skipped int64
+}
+
+// NewMonitorReader ...
+func NewMonitorReader(r io.Reader, conns int, remap *KeyRemap) *MonitorReader {
+	mr := &MonitorReader{
+		r:       bufio.NewReaderSize(r, 1<<20),
+		conns:   conns,
+		remap:   remap,
+		clients: map[string]int{},
+		db:      make([]int, conns),
+	}
+	return mr
+}
+
+// Next ...
+func (mr *MonitorReader) Next() (*Record, error) {
+	if rec := mr.pending; rec != nil {
+		mr.pending = nil
+		return rec, nil
+	}
+	for {
+		line, err := mr.r.ReadString('\n')
+		if line == "" && err != nil {
+			return nil, err
+		}
+		mr.Lines++
+		line = strings.TrimRight(line, "\r\n")
+		m, perr := ParseMonitorLine(line)
+		if perr != nil || m.Client == "lua" {
+			// the OK of redis-cli and garbage
+			mr.Skipped++
+			continue
+		}
+		if mr.start.IsZero() {
+			mr.start = m.Time
+		}
+
+		conn, ok := mr.clients[m.Client]
+		if !ok {
+			conn = len(mr.clients) % mr.conns
+			mr.clients[m.Client] = conn
+		}
+		rec := &Record{Time: m.Time.Sub(mr.start), Conn: conn, Args: m.Args}
+		if mr.remap != nil {
+			mr.remap.Apply(rec.Args)
+		}
+		if strings.EqualFold(m.Args[0], "SELECT") {
+			if db, err := strconv.Atoi(m.Args[len(m.Args)-1]); err == nil {
+				mr.db[conn] = db
+			}
+			return rec, nil
+		}
+		if mr.db[conn] != m.DB {
+			mr.db[conn] = m.DB
+			mr.pending = rec
+			return &Record{Time: rec.Time, Conn: conn, Args: []string{"SELECT", strconv.Itoa(m.DB)}}, nil
+		}
+		return rec, nil
+	}
+}
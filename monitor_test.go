@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMonitorLine(t *testing.T) {
+	m, err := ParseMonitorLine(`1339518083.107412 [3 127.0.0.1:60866] "set" "foo bar" "a\"b\\c\x00\n"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.DB != 3 || m.Client != "127.0.0.1:60866" {
+		t.Fatalf("bad db or client: %+v", m)
+	}
+	if m.Time.UnixNano()/1000 != 1339518083107412 {
+		t.Fatalf("bad time %v", m.Time)
+	}
+	if want := []string{"set", "foo bar", "a\"b\\c\x00\n"}; !reflect.DeepEqual(m.Args, want) {
+		t.Fatalf("expect %q, get %q", want, m.Args)
+	}
+
+	for _, line := range []string{"OK", `1.0 [0 x] "unclosed`, `1.0 [0 x] bare`, `x [0 x] "a"`} {
+		if _, err := ParseMonitorLine(line); err == nil {
+			t.Fatalf("%s: expect error", line)
+		}
+	}
+}
+
+func TestMonitorReader(t *testing.T) {
+	log := `OK
+100.000000 [0 10.0.0.1:1] "get" "user:1"
+100.500000 [0 lua] "get" "user:2"
+101.000000 [2 10.0.0.2:1] "mset" "user:3" "v" "other" "v"
+102.250000 [0 10.0.0.1:1] "del" "user:1" "user:4"
+`
+	remap, err := ParseKeyRemap("user:=perf:user:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := NewMonitorReader(strings.NewReader(log), 4, remap)
+	want := []*Record{
+		{Time: 0, Conn: 0, Args: []string{"get", "perf:user:1"}},
+		{Time: time.Second, Conn: 1, Args: []string{"SELECT", "2"}},
+		{Time: time.Second, Conn: 1, Args: []string{"mset", "perf:user:3", "v", "other", "v"}},
+		{Time: 2250 * time.Millisecond, Conn: 0, Args: []string{"del", "perf:user:1", "perf:user:4"}},
+	}
+	for _, w := range want {
+		rec, err := mr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rec, w) {
+			t.Fatalf("expect %+v, get %+v", w, rec)
+		}
+	}
+	if _, err := mr.Next(); err != io.EOF {
+		t.Fatalf("expect EOF, get %v", err)
+	}
+	if mr.Skipped != 2 {
+		t.Fatalf("expect 2 skipped lines, get %d", mr.Skipped)
+	}
+}
+
+func TestKeyPositions(t *testing.T) {
+	cases := map[string][]int{
+		"GET k":                         {1},
+		"PING":                          nil,
+		"INFO stats":                    nil,
+		"MGET a b c":                    {1, 2, 3},
+		"BLPOP a b 0":                   {1, 2},
+		"EVALSHA sha 2 a b arg":         {3, 4},
+		"ZUNIONSTORE d 2 a b WEIGHTS":   {1, 3, 4},
+		"EVAL script 1 a arg":           {3},
+		"FCALL f 0 arg":                 nil,
+		"ZUNION 2 a b WITHSCORES":       {2, 3},
+		"SINTERCARD 2 a b LIMIT 1":      {2, 3},
+		"LMPOP 2 a b LEFT COUNT 2":      {2, 3},
+		"ZMPOP 1 a MIN":                 {2},
+		"BLMPOP 0 2 a b LEFT":           {3, 4},
+		"BITOP AND d a b":               {2, 3, 4},
+		"XREAD COUNT 2 STREAMS a b 0 0": {4, 5},
+		"XREADGROUP GROUP g c BLOCK 0 STREAMS a >": {7},
+		"OBJECT ENCODING k":                        {2},
+		"OBJECT HELP":                              nil,
+		"MEMORY USAGE k SAMPLES 0":                 {2},
+		"MEMORY STATS":                             nil,
+		"XINFO STREAM s FULL":                      {2},
+		"XGROUP CREATE s g $ MKSTREAM":             {2},
+		"XGROUP HELP":                              nil,
+	}
+	for cmd, want := range cases {
+		if got := KeyPositions(strings.Fields(cmd)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expect %v, get %v", cmd, want, got)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"log"
-	"os"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
-	"unsafe"
 
 	"sync/atomic"
 
@@ -17,6 +19,30 @@ type Result struct {
 	Num   int64
 	Delay int64
 	Err   int64
+	// CPU is the cpu usage of redis-perf itself in percent of one core
+	CPU int64
+	// Gen is the mean cost in ns of generating one request, the time spent
+	// sending it is excluded
+	Gen int64
+	// latency percentiles in us
+	P50  int64
+	P99  int64
+	P999 int64
+	Hist *Histogram
+	// Cmds breaks the requests down by command
+	Cmds map[string]*CmdStatus
+	// Server is what the target did meanwhile, see InfoPoller
+	Server *ServerStats
+	// Cache counts the cache reads per data type in cache mode
+	Cache map[string]*CacheStatus
+	// Queue are the items popped by the queue consumers
+	Queue *QueueStatus
+	// Stream are the messages read by the stream consumers
+	Stream *StreamStatus
+	// PubSub are the messages received by the subscribers
+	PubSub *PubSubStatus
+	// Scan are the calls and the iterations of the scan jobs
+	Scan *ScanStatus
 }
 
 // BucketStatus ...
@@ -24,6 +50,187 @@ type BucketStatus struct {
 	Num   int64
 	Delay int64
 	Err   int64
+	Hist  *Histogram
+	Cmds  map[string]*CmdStatus
+	Cache map[string]*CacheStatus
+}
+
+// CmdStatus counts the requests of one command, Conflict the aborted
+// EXECs.
+type CmdStatus struct {
+	Num      int64
+	Err      int64
+	Conflict int64
+	Hist     *Histogram
+}
+
+func newBucketStatus() *BucketStatus {
+	return &BucketStatus{Hist: NewHistogram(), Cmds: map[string]*CmdStatus{}, Cache: map[string]*CacheStatus{}}
+}
+
+// reset zeroes s for the next interval, the entries of the commands and
+// the data types are kept with their histograms.
+func (s *BucketStatus) reset() {
+	s.Num, s.Delay, s.Err = 0, 0, 0
+	s.Hist.Reset()
+	for _, c := range s.Cmds {
+		c.Num, c.Err, c.Conflict = 0, 0, 0
+		c.Hist.Reset()
+	}
+	for _, c := range s.Cache {
+		*c = CacheStatus{}
+	}
+}
+
+// Record accounts the reply of r.
+func (s *BucketStatus) Record(r *Request) {
+	rt := r.ResponseTime()
+	s.Num++
+	s.Delay += rt
+	s.Hist.Record(rt)
+	name := r.Cmd()
+	c := s.Cmds[name]
+	if c == nil {
+		c = &CmdStatus{Hist: NewHistogram()}
+		s.Cmds[name] = c
+	}
+	c.Num++
+	c.Hist.Record(rt)
+	if r.Read != "" && Conf.Cache {
+		cache := s.Cache[r.Read]
+		if cache == nil {
+			cache = &CacheStatus{}
+			s.Cache[r.Read] = cache
+		}
+		if r.Err == ErrMiss {
+			cache.Miss++
+		} else if r.Err == nil {
+			cache.Hit++
+		}
+	}
+	if r.Err == ErrConflict {
+		c.Conflict++
+	}
+	if failed(r.Err) {
+		s.Err++
+		c.Err++
+	}
+}
+
+// failed tells the errors counted as failures, cache misses and
+// transaction conflicts are not.
+func failed(err error) bool {
+	return err != nil && err != ErrMiss && err != ErrConflict
+}
+
+// Merge adds o into c.
+func (c *CmdStatus) Merge(o *CmdStatus) {
+	c.Num += o.Num
+	c.Err += o.Err
+	c.Conflict += o.Conflict
+	c.Hist.Merge(o.Hist)
+}
+
+// mergeCmds adds the non empty entries of from into to.
+func mergeCmds(to, from map[string]*CmdStatus) {
+	for name, c := range from {
+		if c.Num == 0 {
+			continue
+		}
+		t := to[name]
+		if t == nil {
+			t = &CmdStatus{Hist: NewHistogram()}
+			to[name] = t
+		}
+		t.Merge(c)
+	}
+}
+
+var (
+	// Stop is closed when the run should end, see StopRun
+	Stop     = make(chan struct{})
+	stopOnce sync.Once
+)
+
+// StopRun ends the run: the generators send their last interval and close
+// their result channel.
+func StopRun() {
+	stopOnce.Do(func() {
+		close(Stop)
+	})
+}
+
+// backoff paces a background loop after its errors, the wait doubles from
+// backoffMin up to backoffMax. A server error repeated backoffGiveUp times in
+// a row, like an unknown command on an older server, ends the loop.
+type backoff struct {
+	name string
+	wait time.Duration
+	errs int
+}
+
+const (
+	backoffMin    = 100 * time.Millisecond
+	backoffMax    = 5 * time.Second
+	backoffGiveUp = 5
+)
+
+// transientErrors are the server errors that pass once the server is ready.
+var transientErrors = []string{"LOADING", "BUSY", "MASTERDOWN", "TRYAGAIN", "CLUSTERDOWN"}
+
+// ok resets b after a call that worked.
+func (b *backoff) ok() {
+	b.wait, b.errs = 0, 0
+}
+
+// retry waits after err, false when the loop should give up or the run is
+// over.
+func (b *backoff) retry(err error) bool {
+	if e, ok := err.(redis.Error); ok && !transient(e) {
+		b.errs++
+		if b.errs >= backoffGiveUp {
+			log.Printf("%s: giving up after %d errors in a row: %v\n", b.name, b.errs, err)
+			return false
+		}
+	}
+	b.wait *= 2
+	if b.wait < backoffMin {
+		b.wait = backoffMin
+	}
+	if b.wait > backoffMax {
+		b.wait = backoffMax
+	}
+	select {
+	case <-Stop:
+		return false
+	case <-time.After(b.wait):
+		return true
+	}
+}
+
+func transient(err redis.Error) bool {
+	for _, prefix := range transientErrors {
+		if strings.HasPrefix(string(err), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConnHook when set wraps every connection handed to the executors,
+// id is the worker owning the connection.
+var ConnHook func(conn redis.Conn, id int) redis.Conn
+
+// AddConnHook wraps the connections with hook on top of the existing hooks.
+func AddConnHook(hook func(conn redis.Conn, id int) redis.Conn) {
+	prev := ConnHook
+	if prev == nil {
+		ConnHook = hook
+		return
+	}
+	ConnHook = func(conn redis.Conn, id int) redis.Conn {
+		return hook(prev(conn, id), id)
+	}
 }
 
 // Perf ...
@@ -38,8 +245,12 @@ type Perf struct {
 // GetConn ...
 func (p *Perf) GetConn() redis.Conn {
 	reconnect := false
+	options := []redis.DialOption{redis.DialConnectTimeout(time.Second)}
+	if Conf.Slowlog {
+		options = append(options, redis.DialNetDial(trackDial))
+	}
 	for {
-		conn, err := redis.Dial("tcp", p.addr, redis.DialConnectTimeout(time.Second))
+		conn, err := redis.Dial("tcp", p.addr, options...)
 		if err != nil {
 			if reconnect == false {
 				reconnect = true
@@ -47,7 +258,7 @@ func (p *Perf) GetConn() redis.Conn {
 			}
 			if atomic.LoadInt64(&p.needReconnect) == p.connTotalNum {
 				log.Println(err)
-				os.Exit(1)
+				Exit(1)
 			}
 			time.Sleep(time.Millisecond * 10)
 			continue
@@ -61,33 +272,89 @@ func (p *Perf) GetConn() redis.Conn {
 
 // TokenBucketWorker ...
 type TokenBucketWorker struct {
-	id           int
-	token        chan int64
-	bucketStatus unsafe.Pointer
-	perf         *Perf
+	id    int
+	token chan int64
+	// mu guards status, recorded by the reader and swapped with spare
+	// every interval by GenResult
+	mu      sync.Mutex
+	status  *BucketStatus
+	spare   *BucketStatus
+	perf    *Perf
+	genCost int64
+	// done is closed once the reader has drained its tasks
+	done chan struct{}
+	// side is the connection of the scenarios waiting for their replies,
+	// see SideConn
+	side *timedConn
+}
+
+// workerIDs maps the ids to the running workers, see SideConn.
+var workerIDs sync.Map
+
+// SideConn returns the side connection of worker id, for the scenarios
+// that must wait for a reply before sending the next command. The pipelined
+// connection cannot be read by a scenario, its replies belong to the reader.
+// It is used from the writer of the worker only, its requests are Done.
+func SideConn(id int) redis.Conn {
+	v, _ := workerIDs.Load(id)
+	w := v.(*TokenBucketWorker)
+	if w.side == nil {
+		w.side = &timedConn{}
+	}
+	if w.side.Conn == nil || w.side.Conn.Err() != nil {
+		if w.side.Conn != nil {
+			w.side.Conn.Close()
+		}
+		w.side.Conn = w.dial()
+	}
+	return w.side
 }
 
 // NewTokenBucketWorker ...
 func NewTokenBucketWorker(id int, perf *Perf) (w *TokenBucketWorker) {
-	w = &TokenBucketWorker{
-		id:           id,
-		token:        make(chan int64, 100),
-		perf:         perf,
-		bucketStatus: unsafe.Pointer(&BucketStatus{}),
-	}
+	w = newWorker(id, perf)
 	tasks := w.LoopWriter()
 	w.LoopReader(tasks)
 	return w
 }
 
-// GetAndResetBucketStatus ...
+func newWorker(id int, perf *Perf) *TokenBucketWorker {
+	return &TokenBucketWorker{
+		id:     id,
+		token:  make(chan int64, 100),
+		perf:   perf,
+		status: newBucketStatus(),
+		spare:  newBucketStatus(),
+		done:   make(chan struct{}),
+	}
+}
+
+// dial connects a connection of this worker.
+func (w *TokenBucketWorker) dial() redis.Conn {
+	conn := w.perf.GetConn()
+	if ConnHook != nil {
+		conn = ConnHook(conn, w.id)
+	}
+	return conn
+}
+
+// GetAndResetBucketStatus returns the status of the last interval, it is
+// valid until the next call, the statuses of the worker are reused.
+// It is called by GenResult only.
 func (w *TokenBucketWorker) GetAndResetBucketStatus() (status *BucketStatus) {
-	return (*BucketStatus)(atomic.SwapPointer(&w.bucketStatus, unsafe.Pointer(&BucketStatus{})))
+	w.spare.reset()
+	w.mu.Lock()
+	status, w.status = w.status, w.spare
+	w.mu.Unlock()
+	w.spare = status
+	return status
 }
 
-// GetBucketStatus ...
-func (w *TokenBucketWorker) GetBucketStatus() (status *BucketStatus) {
-	return (*BucketStatus)(atomic.LoadPointer(&w.bucketStatus))
+// record accounts r in the status of the current interval.
+func (w *TokenBucketWorker) record(r *Request) {
+	w.mu.Lock()
+	w.status.Record(r)
+	w.mu.Unlock()
 }
 
 // LoopWriter ...
@@ -96,22 +363,47 @@ func (w *TokenBucketWorker) LoopWriter() (tasks chan *Request) {
 	go func() {
 		var conn redis.Conn
 		var integral int64
+		timed := &timedConn{}
 		loop := w.perf.loop
 		id := w.id
 
-		for n := range w.token {
-			integral += n
+		// tasks is closed on Stop, the reader drains the replies in flight
+		defer close(tasks)
+		for {
+			select {
+			case n := <-w.token:
+				integral += n
+			case <-Stop:
+				return
+			}
 			for integral > 0 {
 				if conn == nil || conn.Err() != nil {
-					conn = w.perf.GetConn()
+					// the hooks release the resources of a failed connection
+					// on Close, see Mirror
+					if conn != nil {
+						conn.Close()
+					}
+					conn = w.dial()
 					loop = w.perf.loop
 				}
 
-				rs := AllExecutor.Execute(conn, id)
+				timed.Conn, timed.spent = conn, 0
+				if w.side != nil {
+					w.side.spent = 0
+				}
+				start := time.Now()
+				rs := Workload.Execute(timed, id)
+				spent := timed.spent
+				if w.side != nil {
+					spent += w.side.spent
+				}
+				atomic.AddInt64(&w.genCost, int64(time.Since(start)-spent))
 				integral -= int64(len(rs))
 				for _, r := range rs {
 					r.Conn = conn
-					r.RecordStart()
+					if !r.Done {
+						r.RecordStart()
+					}
 				}
 
 				if w.perf.loop > 0 {
@@ -132,32 +424,66 @@ func (w *TokenBucketWorker) LoopWriter() (tasks chan *Request) {
 	return tasks
 }
 
+// timedConn sums the time spent in the calls doing I/O, the generator cost
+// of a request excludes it.
+type timedConn struct {
+	redis.Conn
+	spent time.Duration
+}
+
+func (c *timedConn) Send(cmd string, args ...interface{}) error {
+	start := time.Now()
+	err := c.Conn.Send(cmd, args...)
+	c.spent += time.Since(start)
+	return err
+}
+
+func (c *timedConn) Flush() error {
+	start := time.Now()
+	err := c.Conn.Flush()
+	c.spent += time.Since(start)
+	return err
+}
+
+func (c *timedConn) Receive() (interface{}, error) {
+	start := time.Now()
+	reply, err := c.Conn.Receive()
+	c.spent += time.Since(start)
+	return reply, err
+}
+
+func (c *timedConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	reply, err := c.Conn.Do(cmd, args...)
+	c.spent += time.Since(start)
+	return reply, err
+}
+
 // LoopReader ...
 func (w *TokenBucketWorker) LoopReader(tasks chan *Request) {
 	go func() {
+		defer close(w.done)
 		for r := range tasks {
-			reply, err := r.Conn.Receive()
-			r.Err = r.valid(reply, err)
-			r.RecordStop()
+			if !r.Done {
+				reply, err := r.Conn.Receive()
+				r.Err = r.valid(reply, err)
+				r.RecordStop()
+			}
 			if r.Last {
 				r.Conn.Close()
 			}
 
-			status := w.GetBucketStatus()
-			status.Num++
-			status.Delay += r.ResponseTime()
-			if r.Err != nil {
-				if Conf.Debug {
-					log.Println(r)
-				}
-				status.Err++
+			w.record(r)
+			if failed(r.Err) && Conf.Debug {
+				log.Println(r)
 			}
 		}
 	}()
 }
 
-// NewPerfGen ...
-func NewPerfGen(addr string, qps, num, loop int64) (result chan *Result) {
+// NewPerfGen starts num workers with the ids base to base+num-1, see
+// RandomGen.Replicate to drive several targets with the same stream.
+func NewPerfGen(addr string, qps, num, loop int64, base int) (result chan *Result) {
 	perf := &Perf{
 		addr:         addr,
 		loop:         loop,
@@ -167,11 +493,37 @@ func NewPerfGen(addr string, qps, num, loop int64) (result chan *Result) {
 	result = make(chan *Result, 100)
 	workers := make([]*TokenBucketWorker, num)
 	for index := range workers {
-		workers[index] = NewTokenBucketWorker(index, perf)
+		workers[index] = NewTokenBucketWorker(base+index, perf)
+		workerIDs.Store(base+index, workers[index])
 	}
 
 	go BucketGenToken(workers, perf)
-	return GenResult(workers)
+	return GenResult(workers, drained(workers))
+}
+
+// drainTimeout bounds the wait for the replies in flight at the end of the
+// run, a stuck connection must not hang it.
+const drainTimeout = 2 * time.Second
+
+// drained is closed once the readers of workers have read the replies in
+// flight after Stop, or drainTimeout after Stop.
+func drained(workers []*TokenBucketWorker) chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		<-Stop
+		timeout := time.After(drainTimeout)
+		for _, w := range workers {
+			select {
+			case <-w.done:
+			case <-timeout:
+				log.Println("replies still in flight after", drainTimeout)
+				close(stop)
+				return
+			}
+		}
+		close(stop)
+	}()
+	return stop
 }
 
 // BucketGenToken ...
@@ -208,36 +560,117 @@ func BucketGenToken(workers []*TokenBucketWorker, perf *Perf) {
 
 }
 
-// GenResult ...
-func GenResult(workers []*TokenBucketWorker) (result chan *Result) {
+// GenResult sums the workers every second. Once stop is closed the last
+// partial interval is sent and result is closed, a nil stop runs forever.
+func GenResult(workers []*TokenBucketWorker, stop <-chan struct{}) (result chan *Result) {
 	result = make(chan *Result, 100)
 
 	t := time.NewTicker(time.Second)
 	go func() {
-		for range t.C {
+		cpu := NewCPUMeter()
+		for last := false; !last; {
+			select {
+			case <-t.C:
+			case <-stop:
+				last = true
+				t.Stop()
+			}
 
+			var genCost int64
 			sl := make([]*BucketStatus, len(workers))
 			for index, worker := range workers {
 				sl[index] = worker.GetAndResetBucketStatus()
+				genCost += atomic.SwapInt64(&worker.genCost, 0)
 			}
 
-			r := &Result{}
+			r := &Result{Hist: NewHistogram(), Cmds: map[string]*CmdStatus{}, Cache: map[string]*CacheStatus{}}
 			for _, s := range sl {
 				r.Delay += s.Delay
 				r.Err += s.Err
 				r.Num += s.Num
+				r.Hist.Merge(s.Hist)
+				mergeCmds(r.Cmds, s.Cmds)
+				mergeCache(r.Cache, s.Cache)
 			}
+			r.P50 = r.Hist.Percentile(50)
+			r.P99 = r.Hist.Percentile(99)
+			r.P999 = r.Hist.Percentile(99.9)
 
 			r.QPS = r.Num
 			if r.Num > 0 {
 				r.Delay = r.Delay / r.Num
+				r.Gen = genCost / r.Num
 			}
+			r.CPU = cpu.Percent()
 
 			select {
 			case result <- r:
 			}
 		}
+		close(result)
 	}()
 
 	return result
 }
+
+// String ...
+func (r *Result) String() string {
+	if r.Server != nil {
+		return r.clientString() + "\t| " + r.Server.String()
+	}
+	return r.clientString()
+}
+
+func (r *Result) clientString() string {
+	s := fmt.Sprintf("qps %d\tdelay %dus\tp99 %dus\tp999 %dus\terr %d\tcpu %d%%\tgen %dns", r.QPS, r.Delay, r.P99, r.P999, r.Err, r.CPU, r.Gen)
+	if len(r.Cache) > 0 {
+		s += fmt.Sprintf("\thit %.1f%%", r.HitRatio())
+	}
+	if r.Queue != nil {
+		s += "\t" + r.Queue.String()
+	}
+	if r.Stream != nil {
+		s += "\t" + r.Stream.String()
+	}
+	if r.PubSub != nil {
+		s += "\t" + r.PubSub.String()
+	}
+	if r.Scan != nil {
+		s += "\t" + r.Scan.String()
+	}
+	if watched, aborted := r.Conflicts(); watched > 0 {
+		s += fmt.Sprintf("\tconflict %d %.2f%%", aborted, r.ConflictRate())
+	}
+	return s
+}
+
+// CPUMeter measures the cpu usage of this process between two calls.
+type CPUMeter struct {
+	wall time.Time
+	used time.Duration
+}
+
+// NewCPUMeter ...
+func NewCPUMeter() *CPUMeter {
+	return &CPUMeter{wall: time.Now(), used: processCPUTime()}
+}
+
+// Percent returns the cpu usage since the last call, 100 means one core.
+func (m *CPUMeter) Percent() int64 {
+	now, used := time.Now(), processCPUTime()
+	elapsed := now.Sub(m.wall)
+	percent := int64(0)
+	if elapsed > 0 {
+		percent = int64(100 * (used - m.used) / elapsed)
+	}
+	m.wall, m.used = now, used
+	return percent
+}
+
+func processCPUTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
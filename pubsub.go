@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// PubSubExecutor publishes numbered messages stamped with the send time,
+// PubSubSubscribers receive them on their own connections.
+var PubSubExecutor = &RandomExecutor{Name: "pubsub"}
+
+// pubSeqs holds the sequence numbers of every worker per channel, the
+// []int64 of worker id is used by worker id only.
+var pubSeqs sync.Map
+
+func init() {
+	Executors = append(Executors, PubSubExecutor)
+
+	// publish, SPUBLISH when sharded
+	PubSubExecutor.Add("publish", 1, func(conn redis.Conn, id int) (rs []*Request) {
+		channel, n := RGen.Channel(id)
+		seq := nextPubSeq(id, n)
+		msg := pubsubMessage(time.Now(), id, seq, RGen.Value(id))
+		cmd := "PUBLISH"
+		if Conf.Sharded {
+			cmd = "SPUBLISH"
+		}
+
+		conn.Send(cmd, channel, msg)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("%s %s %s", cmd, channel, msg),
+			valid: func(reply interface{}, err error) error {
+				_, err = redis.Int64(reply, err)
+				return err
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+}
+
+// nextPubSeq returns the next sequence number of worker id on channel n.
+func nextPubSeq(id int, n int64) int64 {
+	v, ok := pubSeqs.Load(id)
+	if !ok {
+		v = make([]int64, RGen.Param.ChannelNum)
+		pubSeqs.Store(id, v)
+	}
+	seqs := v.([]int64)
+	seqs[n]++
+	return seqs[n]
+}
+
+// pubsubMessage prefixes value with the send time in ns, the publishing
+// worker and its sequence number on the channel.
+func pubsubMessage(now time.Time, id int, seq int64, value string) string {
+	b := make([]byte, 0, 40+len(value))
+	b = strconv.AppendInt(b, now.UnixNano(), 10)
+	b = append(b, ':')
+	b = strconv.AppendInt(b, int64(id), 10)
+	b = append(b, ':')
+	b = strconv.AppendInt(b, seq, 10)
+	b = append(b, ':')
+	return string(append(b, value...))
+}
+
+// parsePubSubMessage returns the send time, the worker and the sequence
+// number of a message.
+func parsePubSubMessage(msg []byte) (ns int64, worker string, seq int64, err error) {
+	parts := strings.SplitN(string(msg), ":", 4)
+	if len(parts) != 4 {
+		return 0, "", 0, fmt.Errorf("message without header")
+	}
+	if ns, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return 0, "", 0, fmt.Errorf("message without send time")
+	}
+	if seq, err = strconv.ParseInt(parts[2], 10, 64); err != nil {
+		return 0, "", 0, fmt.Errorf("message without sequence number")
+	}
+	return ns, parts[1], seq, nil
+}
+
+// globEscape quotes the glob characters of a channel, so the pattern
+// matches exactly that channel.
+func globEscape(channel string) string {
+	var b strings.Builder
+	for i := 0; i < len(channel); i++ {
+		switch c := channel[i]; c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// PubSubStatus counts the messages received during an interval, Hist is the
+// time from publish to receive in us. Lost are the gaps in the sequence
+// numbers of a publisher seen by a subscriber.
+type PubSubStatus struct {
+	Num       int64
+	Err       int64
+	Lost      int64
+	Published int64
+	Hist      *Histogram
+}
+
+func newPubSubStatus() *PubSubStatus {
+	return &PubSubStatus{Hist: NewHistogram()}
+}
+
+// Merge adds o into s.
+func (s *PubSubStatus) Merge(o *PubSubStatus) {
+	if o == nil {
+		return
+	}
+	s.Num += o.Num
+	s.Err += o.Err
+	s.Lost += o.Lost
+	s.Published += o.Published
+	s.Hist.Merge(o.Hist)
+}
+
+// FanOut returns the messages received per message published.
+func (s *PubSubStatus) FanOut() float64 {
+	if s.Published == 0 {
+		return 0
+	}
+	return float64(s.Num) / float64(s.Published)
+}
+
+// String ...
+func (s *PubSubStatus) String() string {
+	return fmt.Sprintf("received %d\tpubsub p50 %dus\tp99 %dus\tp999 %dus\tlost %d\tfan-out %.2f",
+		s.Num, s.Hist.Percentile(50), s.Hist.Percentile(99), s.Hist.Percentile(99.9), s.Lost, s.FanOut())
+}
+
+// PubSubSubscribers are -subscribers connections each subscribed to every
+// channel, with SUBSCRIBE, PSUBSCRIBE or SSUBSCRIBE.
+type PubSubSubscribers struct {
+	addr     string
+	channels []string
+	mu       sync.Mutex
+	status   *PubSubStatus
+}
+
+// NewPubSubSubscribers subscribes -subscribers connections to the channels
+// of addr, nil unless the pubsub workload runs.
+func NewPubSubSubscribers(addr string) *PubSubSubscribers {
+	if !Conf.PubSub {
+		return nil
+	}
+	s := &PubSubSubscribers{addr: addr, status: newPubSubStatus()}
+	for n := int64(0); n < RGen.Param.ChannelNum; n++ {
+		s.channels = append(s.channels, RGen.Param.ChannelTemplate.Render(0, n))
+	}
+	ready := make(chan struct{}, Conf.Subscribers)
+	for i := 0; i < Conf.Subscribers; i++ {
+		go s.subscribe(ready)
+	}
+	// the messages published before the subscriptions would be lost
+	for i := 0; i < Conf.Subscribers; i++ {
+		select {
+		case <-ready:
+		case <-time.After(5 * time.Second):
+			log.Println("pubsub subscribers not ready")
+			return s
+		}
+	}
+	return s
+}
+
+func (s *PubSubSubscribers) subscribe(ready chan<- struct{}) {
+	// last is the last sequence number received per channel and worker
+	last := map[string]int64{}
+	b := &backoff{name: "pubsub subscriber " + s.addr}
+	// ready is signaled once, on the first subscription or on giving up
+	notified := false
+	notify := func() {
+		b.ok()
+		if !notified {
+			notified = true
+			ready <- struct{}{}
+		}
+	}
+	defer func() {
+		if !notified {
+			ready <- struct{}{}
+		}
+	}()
+	for {
+		select {
+		case <-Stop:
+			return
+		default:
+		}
+		conn, err := redis.Dial("tcp", s.addr, redis.DialConnectTimeout(time.Second))
+		if err != nil {
+			if !b.retry(err) {
+				return
+			}
+			continue
+		}
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-Stop:
+			case <-done:
+			}
+			conn.Close()
+		}()
+		err = s.receive(conn, last, notify)
+		close(done)
+		if Conf.Debug {
+			log.Println("pubsub subscriber", err)
+		}
+		if !b.retry(err) {
+			return
+		}
+	}
+}
+
+// receive subscribes conn and records its messages until it fails, notify
+// is called once every channel is subscribed.
+func (s *PubSubSubscribers) receive(conn redis.Conn, last map[string]int64, notify func()) error {
+	channels := make([]interface{}, len(s.channels))
+	for i, channel := range s.channels {
+		channels[i] = channel
+		if Conf.PSubscribe {
+			channels[i] = globEscape(channel)
+		}
+	}
+
+	if Conf.Sharded {
+		// PubSubConn knows no sharded notifications
+		for _, channel := range channels {
+			conn.Send("SSUBSCRIBE", channel)
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+		subscribed := 0
+		for {
+			reply, err := redis.Values(conn.Receive())
+			if err != nil {
+				return err
+			}
+			if len(reply) != 3 {
+				return fmt.Errorf("unexpected notification %v", reply)
+			}
+			kind, _ := redis.String(reply[0], nil)
+			switch kind {
+			case "ssubscribe":
+				if subscribed++; subscribed == len(channels) {
+					notify()
+				}
+			case "smessage":
+				channel, _ := redis.String(reply[1], nil)
+				data, _ := redis.Bytes(reply[2], nil)
+				s.record(channel, data, last)
+			}
+		}
+	}
+
+	psc := redis.PubSubConn{Conn: conn}
+	var err error
+	if Conf.PSubscribe {
+		err = psc.PSubscribe(channels...)
+	} else {
+		err = psc.Subscribe(channels...)
+	}
+	if err != nil {
+		return err
+	}
+	for {
+		switch n := psc.Receive().(type) {
+		case redis.Message:
+			s.record(n.Channel, n.Data, last)
+		case redis.PMessage:
+			s.record(n.Channel, n.Data, last)
+		case redis.Subscription:
+			if n.Count == len(channels) {
+				notify()
+			}
+		case error:
+			return n
+		}
+	}
+}
+
+// record accounts a message received on channel.
+func (s *PubSubSubscribers) record(channel string, data []byte, last map[string]int64) {
+	now := time.Now()
+	ns, worker, seq, err := parsePubSubMessage(data)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.status.Err++
+		if Conf.Debug {
+			log.Println("pubsub", err)
+		}
+		return
+	}
+	key := channel + ":" + worker
+	prev, ok := last[key]
+	last[key] = seq
+	switch {
+	case ok && seq <= prev:
+		s.status.Err++
+		if Conf.Debug {
+			log.Printf("pubsub %s: message %d of worker %s after %d", channel, seq, worker, prev)
+		}
+		return
+	case ok:
+		s.status.Lost += seq - prev - 1
+	default:
+		// messages before the first one were published before subscribing
+	}
+	s.status.Num++
+	s.status.Hist.Record((now.UnixNano() - ns) / 1000)
+}
+
+// Attach sets r.PubSub to the messages received since the previous call and
+// counts the messages published in r, a nil subscriber attaches nothing.
+func (s *PubSubSubscribers) Attach(r *Result) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cmd := range []string{"PUBLISH", "SPUBLISH"} {
+		if c := r.Cmds[cmd]; c != nil {
+			s.status.Published += c.Num
+		}
+	}
+	r.PubSub = s.status
+	s.status = newPubSubStatus()
+}
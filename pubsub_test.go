@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPubSubMessage(t *testing.T) {
+	sent := time.Now()
+	msg := pubsubMessage(sent, 3, 42, "payload:with:colons")
+	ns, worker, seq, err := parsePubSubMessage([]byte(msg))
+	if err != nil || ns != sent.UnixNano() || worker != "3" || seq != 42 {
+		t.Fatalf("unexpected %d %s %d %v", ns, worker, seq, err)
+	}
+	if _, _, _, err := parsePubSubMessage([]byte("garbage")); err == nil {
+		t.Fatal("expect error on a message without header")
+	}
+	if p := globEscape("c[1]*?"); p != `c\[1\]\*\?` {
+		t.Fatalf("unexpected pattern %s", p)
+	}
+}
+
+func TestPubSubLost(t *testing.T) {
+	s := &PubSubSubscribers{status: newPubSubStatus()}
+	last := map[string]int64{}
+	now := time.Now()
+	for _, seq := range []int64{5, 6, 9, 9, 10} {
+		s.record("c", []byte(pubsubMessage(now, 0, seq, "v")), last)
+	}
+	s.record("c", []byte(pubsubMessage(now, 1, 1, "v")), last)
+	r := &Result{Cmds: map[string]*CmdStatus{"PUBLISH": {Num: 4}}}
+	s.Attach(r)
+	if p := r.PubSub; p.Num != 5 || p.Lost != 2 || p.Err != 1 || p.FanOut() != 1.25 {
+		t.Fatalf("unexpected %+v", p)
+	}
+}
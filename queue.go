@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// processingSuffix names the list BLMOVE moves the items to until they are
+// acknowledged, the reliable queue pattern.
+const processingSuffix = ":processing"
+
+// processingList returns the processing list of queue, in the slot of the
+// queue for -cluster: the queue name becomes the hash tag when it has none.
+// A name with a } but no hash tag cannot be one, its slot is left as is.
+func processingList(queue string) string {
+	if strings.IndexByte(queue, '}') >= 0 {
+		return queue + processingSuffix
+	}
+	return "{" + queue + "}" + processingSuffix
+}
+
+// queueBlockTimeout is the BLMOVE timeout in seconds of a consumer of several
+// queues, the items pushed meanwhile to its other queues wait at most that.
+const queueBlockTimeout = 0.1
+
+// QueueExecutor pushes timestamped items to the queues, QueueConsumers pop
+// them on their own connections.
+var QueueExecutor = &RandomExecutor{Name: "queue"}
+
+func init() {
+	Executors = append(Executors, QueueExecutor)
+
+	// push, to the left unless the consumers pop from the left
+	QueueExecutor.Add("push", 1, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Queue(id)
+		item := queueItem(time.Now(), RGen.Value(id))
+		cmd := "LPUSH"
+		if Conf.Pop == "blpop" {
+			cmd = "RPUSH"
+		}
+
+		conn.Send(cmd, key, item)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("%s %s %s", cmd, key, item),
+			valid: validPositive,
+		})
+		conn.Flush()
+
+		return rs
+	})
+}
+
+// queueItem prefixes value with the push time in ns.
+func queueItem(now time.Time, value string) string {
+	b := make([]byte, 0, 20+len(value))
+	b = strconv.AppendInt(b, now.UnixNano(), 10)
+	b = append(b, ':')
+	return string(append(b, value...))
+}
+
+// queueLatency returns the time in us since item was pushed.
+func queueLatency(now time.Time, item string) (int64, error) {
+	i := strings.IndexByte(item, ':')
+	if i < 0 {
+		return 0, fmt.Errorf("item without push time")
+	}
+	ns, err := strconv.ParseInt(item[:i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("item without push time")
+	}
+	return (now.UnixNano() - ns) / 1000, nil
+}
+
+// QueueStatus counts the items popped during an interval, Hist is the time
+// from push to pop in us.
+type QueueStatus struct {
+	Num  int64
+	Err  int64
+	Hist *Histogram
+}
+
+func newQueueStatus() *QueueStatus {
+	return &QueueStatus{Hist: NewHistogram()}
+}
+
+// Merge adds o into s.
+func (s *QueueStatus) Merge(o *QueueStatus) {
+	if o == nil {
+		return
+	}
+	s.Num += o.Num
+	s.Err += o.Err
+	s.Hist.Merge(o.Hist)
+}
+
+// String ...
+func (s *QueueStatus) String() string {
+	return fmt.Sprintf("popped %d\tqueue p50 %dus\tp99 %dus\tp999 %dus",
+		s.Num, s.Hist.Percentile(50), s.Hist.Percentile(99), s.Hist.Percentile(99.9))
+}
+
+// QueueConsumers pop the queues with -pop on blocking connections, each
+// consumer serves every Consumers-th queue.
+type QueueConsumers struct {
+	addr   string
+	queues []string
+	mu     sync.Mutex
+	status *QueueStatus
+}
+
+// NewQueueConsumers starts -consumers consumers of the queues of addr, nil
+// unless the queue workload runs.
+func NewQueueConsumers(addr string) *QueueConsumers {
+	if !Conf.Queue {
+		return nil
+	}
+	q := &QueueConsumers{addr: addr, status: newQueueStatus()}
+	for n := int64(0); n < RGen.Param.QueueNum; n++ {
+		q.queues = append(q.queues, RGen.Param.QueueTemplate.Render(0, n))
+	}
+	consumers := Conf.Consumers
+	if consumers <= 0 || consumers > len(q.queues) {
+		consumers = len(q.queues)
+	}
+	for i := 0; i < consumers; i++ {
+		var queues []string
+		for j := i; j < len(q.queues); j += consumers {
+			queues = append(queues, q.queues[j])
+		}
+		go q.consume(queues)
+	}
+	return q
+}
+
+func (q *QueueConsumers) consume(queues []string) {
+	var conn redis.Conn
+	args := make([]interface{}, 0, len(queues)+1)
+	for _, queue := range queues {
+		args = append(args, queue)
+	}
+	args = append(args, 1)
+	b := &backoff{name: "queue consumer " + q.addr}
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for next := 0; ; next++ {
+		select {
+		case <-Stop:
+			return
+		default:
+		}
+		if conn == nil || conn.Err() != nil {
+			var err error
+			if conn, err = redis.Dial("tcp", q.addr, redis.DialConnectTimeout(time.Second)); err != nil {
+				conn = nil
+				if !b.retry(err) {
+					return
+				}
+				continue
+			}
+		}
+
+		var item string
+		var err error
+		if Conf.Pop == "blmove" {
+			item, err = q.move(conn, queues, next)
+		} else {
+			var reply []string
+			reply, err = redis.Strings(conn.Do(strings.ToUpper(Conf.Pop), args...))
+			if err == nil && len(reply) == 2 {
+				item = reply[1]
+			}
+		}
+		if err == redis.ErrNil {
+			b.ok()
+			continue
+		}
+		if err != nil {
+			q.record(item, err)
+			if !b.retry(err) {
+				return
+			}
+			continue
+		}
+		b.ok()
+		q.record(item, err)
+	}
+}
+
+// move pops an item with LMOVE from the first non empty queue starting at
+// queues[next], and blocks on queues[next] with BLMOVE only when they are all
+// empty: a consumer of several queues must not wait on one of them while the
+// others fill. The item is acknowledged with LREM.
+func (q *QueueConsumers) move(conn redis.Conn, queues []string, next int) (item string, err error) {
+	src := queues[next%len(queues)]
+	err = redis.ErrNil
+	for i := 0; i < len(queues) && err == redis.ErrNil; i++ {
+		src = queues[(next+i)%len(queues)]
+		item, err = redis.String(conn.Do("LMOVE", src, processingList(src), "RIGHT", "LEFT"))
+	}
+	if err == redis.ErrNil {
+		src = queues[next%len(queues)]
+		timeout := 1.0
+		if len(queues) > 1 {
+			timeout = queueBlockTimeout
+		}
+		item, err = redis.String(conn.Do("BLMOVE", src, processingList(src), "RIGHT", "LEFT", timeout))
+	}
+	if err == nil {
+		_, err = conn.Do("LREM", processingList(src), 1, item)
+	}
+	return item, err
+}
+
+func (q *QueueConsumers) record(item string, err error) {
+	now := time.Now()
+	var latency int64
+	if err == nil {
+		latency, err = queueLatency(now, item)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil {
+		q.status.Err++
+		if Conf.Debug {
+			log.Println("queue", err)
+		}
+		return
+	}
+	q.status.Num++
+	q.status.Hist.Record(latency)
+}
+
+// Attach sets r.Queue to the items popped since the previous call, a nil
+// consumer attaches nothing.
+func (q *QueueConsumers) Attach(r *Result) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r.Queue = q.status
+	q.status = newQueueStatus()
+}
+
+// Backlog returns the items left in the queues.
+func (q *QueueConsumers) Backlog() (int64, error) {
+	conn, err := redis.Dial("tcp", q.addr, redis.DialConnectTimeout(time.Second),
+		redis.DialReadTimeout(time.Second), redis.DialWriteTimeout(time.Second))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	for _, queue := range q.queues {
+		conn.Send("LLEN", queue)
+	}
+	conn.Flush()
+	var backlog int64
+	for range q.queues {
+		n, err := redis.Int64(conn.Receive())
+		if err != nil {
+			return 0, err
+		}
+		backlog += n
+	}
+	return backlog, nil
+}
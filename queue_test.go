@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueItem(t *testing.T) {
+	pushed := time.Now()
+	item := queueItem(pushed, "payload:with:colons")
+	if !strings.HasSuffix(item, ":payload:with:colons") {
+		t.Fatalf("unexpected item %s", item)
+	}
+	latency, err := queueLatency(pushed.Add(1500*time.Microsecond), item)
+	if err != nil || latency != 1500 {
+		t.Fatalf("expect 1500us, get %d %v", latency, err)
+	}
+	if _, err := queueLatency(pushed, "garbage"); err == nil {
+		t.Fatal("expect error on an item without push time")
+	}
+}
+
+func TestListPartition(t *testing.T) {
+	rg := newTestGen()
+	seen := map[string]int{}
+	for id := 0; id < int(rg.Num); id++ {
+		for i := 0; i < 1000; i++ {
+			key := rg.List(id)
+			if owner, ok := seen[key]; ok && owner != id {
+				t.Fatalf("list %s used by workers %d and %d", key, owner, id)
+			}
+			seen[key] = id
+		}
+	}
+	rg.Param.QueueTemplate = &KeyTemplate{Format: "q{${worker}}_${n}"}
+	if err := rg.Param.Compile(); err == nil {
+		t.Fatal("expect error on a per worker queue template")
+	}
+}
+
+func TestProcessingList(t *testing.T) {
+	for queue, want := range map[string]string{
+		"queue:1":       "{queue:1}:processing",
+		"queue:{1}":     "queue:{1}:processing",
+		"{queue}:1":     "{queue}:1:processing",
+		"queue:{1:tag":  "{queue:{1:tag}:processing",
+		"{app}:queue:2": "{app}:queue:2:processing",
+	} {
+		if got := processingList(queue); got != want {
+			t.Fatalf("%s: expect %s, get %s", queue, want, got)
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"unsafe"
+)
+
+const (
+	// payloadPoolSize is the number of random bytes pre-generated for values,
+	// a value is a window of ValueLen bytes at a random offset of the pool.
+	payloadPoolSize = 1 << 20
+	// arenaSize is the size of the slab key names are carved from.
+	arenaSize = 64 << 10
+)
+
+// RandomGen ...
+type RandomGen struct {
+	Param *Param
+	Num   int64
+	Range []*RangeParam
+	Seed  []uint8
+	Rand  []*rand.Rand
+	// RandSeed seeds the generators, see streamSeed
+	RandSeed int64
+
+	payload string
+	arena   []*keyArena
+	zipf    []*rand.Zipf
+	members map[string]*KeyTemplate
+}
+
+// RangeParam ...
+type RangeParam struct {
+	KeyMin        int64
+	KeySize       int64
+	HashMin       int64
+	HashSize      int64
+	SetMin        int64
+	SetSize       int64
+	SortedSetMin  int64
+	SortedSetSize int64
+	ListMin       int64
+	ListSize      int64
+	StreamMin     int64
+	StreamSize    int64
+	CounterMin    int64
+	CounterSize   int64
+	GeoMin        int64
+	GeoSize       int64
+	HLLMin        int64
+	HLLSize       int64
+	BitmapMin     int64
+	BitmapSize    int64
+}
+
+// keyArena formats key names into a per worker scratch buffer and interns
+// them into a shared slab, so a key costs no allocation in the steady state.
+// Bytes already handed out are never written again.
+type keyArena struct {
+	scratch []byte
+	slab    []byte
+}
+
+// intern copies b into the slab and returns it as a string.
+func (a *keyArena) intern(b []byte) string {
+	if cap(a.slab)-len(a.slab) < len(b) {
+		a.slab = make([]byte, 0, arenaSize)
+	}
+	start := len(a.slab)
+	a.slab = append(a.slab, b...)
+	s := a.slab[start:len(a.slab):len(a.slab)]
+	return *(*string)(unsafe.Pointer(&s))
+}
+
+// appendPad appends n as a decimal number left padded with zeros to width.
+func appendPad(b []byte, n int64, width int) []byte {
+	var tmp [20]byte
+	d := strconv.AppendInt(tmp[:0], n, 10)
+	for i := len(d); i < width; i++ {
+		b = append(b, '0')
+	}
+	return append(b, d...)
+}
+
+// format renders the template without fmt.
+func (rg *RandomGen) format(id int, t *KeyTemplate, n int64) string {
+	a := rg.arena[id]
+	a.scratch = t.Append(a.scratch[:0], id%int(rg.Num), n)
+	return a.intern(a.scratch)
+}
+
+// Init partitions the key space among the workers and prepares the random
+// sources and payload pool.
+func (rg *RandomGen) Init() {
+	keys0 := rg.Param.KeyNum / rg.Num
+	keys1 := keys0 + 1
+	keyn0 := keys1*rg.Num - rg.Param.KeyNum
+	// keyn1 := rg.Param.KeyNum - keys0*rg.Num
+
+	hashs0 := rg.Param.HashNum / rg.Num
+	hashs1 := hashs0 + 1
+	hashn0 := hashs1*rg.Num - rg.Param.HashNum
+	// hashn1 := rg.Param.HashNum - hashs0*rg.Num
+
+	sets0 := rg.Param.SetNum / rg.Num
+	sets1 := sets0 + 1
+	setn0 := sets1*rg.Num - rg.Param.SetNum
+	// setn1 := rg.Param.SetNum - sets0*rg.Num
+
+	sortedsets0 := rg.Param.SortedSetNum / rg.Num
+	sortedsets1 := sortedsets0 + 1
+	sortedsetn0 := sortedsets1*rg.Num - rg.Param.SortedSetNum
+	// sortedsetn1 := rg.Param.SortedSetNum - sortedsets0*rg.Num
+
+	lists0 := rg.Param.ListNum / rg.Num
+	lists1 := lists0 + 1
+	listn0 := lists1*rg.Num - rg.Param.ListNum
+
+	streams0 := rg.Param.StreamNum / rg.Num
+	streams1 := streams0 + 1
+	streamn0 := streams1*rg.Num - rg.Param.StreamNum
+
+	counters0 := rg.Param.CounterNum / rg.Num
+	counters1 := counters0 + 1
+	countern0 := counters1*rg.Num - rg.Param.CounterNum
+
+	geos0 := rg.Param.GeoNum / rg.Num
+	geos1 := geos0 + 1
+	geon0 := geos1*rg.Num - rg.Param.GeoNum
+
+	hlls0 := rg.Param.HLLNum / rg.Num
+	hlls1 := hlls0 + 1
+	hlln0 := hlls1*rg.Num - rg.Param.HLLNum
+
+	bitmaps0 := rg.Param.BitmapNum / rg.Num
+	bitmaps1 := bitmaps0 + 1
+	bitmapn0 := bitmaps1*rg.Num - rg.Param.BitmapNum
+
+	rg.Range = make([]*RangeParam, rg.Num)
+	for i := range rg.Range {
+		r := &RangeParam{}
+		//key
+		if int64(i) < keyn0 {
+			r.KeyMin = int64(i) * keys0
+			r.KeySize = keys0
+		} else {
+			r.KeyMin = keyn0*keys0 + (int64(i)-keyn0)*keys1
+			r.KeySize = keys1
+		}
+		//hash
+		if int64(i) < hashn0 {
+			r.HashMin = int64(i) * hashs0
+			r.HashSize = hashs0
+		} else {
+			r.HashMin = hashn0*hashs0 + (int64(i)-hashn0)*hashs1
+			r.HashSize = hashs1
+		}
+		//set
+		if int64(i) < setn0 {
+			r.SetMin = int64(i) * sets0
+			r.SetSize = sets0
+		} else {
+			r.SetMin = setn0*sets0 + (int64(i)-setn0)*sets1
+			r.SetSize = sets1
+		}
+		//sortedset
+		if int64(i) < sortedsetn0 {
+			r.SortedSetMin = int64(i) * sortedsets0
+			r.SortedSetSize = sortedsets0
+		} else {
+			r.SortedSetMin = sortedsetn0*sortedsets0 + (int64(i)-sortedsetn0)*sortedsets1
+			r.SortedSetSize = sortedsets1
+		}
+		//list
+		if int64(i) < listn0 {
+			r.ListMin = int64(i) * lists0
+			r.ListSize = lists0
+		} else {
+			r.ListMin = listn0*lists0 + (int64(i)-listn0)*lists1
+			r.ListSize = lists1
+		}
+		//stream
+		if int64(i) < streamn0 {
+			r.StreamMin = int64(i) * streams0
+			r.StreamSize = streams0
+		} else {
+			r.StreamMin = streamn0*streams0 + (int64(i)-streamn0)*streams1
+			r.StreamSize = streams1
+		}
+		//counter
+		if int64(i) < countern0 {
+			r.CounterMin = int64(i) * counters0
+			r.CounterSize = counters0
+		} else {
+			r.CounterMin = countern0*counters0 + (int64(i)-countern0)*counters1
+			r.CounterSize = counters1
+		}
+		//geo
+		if int64(i) < geon0 {
+			r.GeoMin = int64(i) * geos0
+			r.GeoSize = geos0
+		} else {
+			r.GeoMin = geon0*geos0 + (int64(i)-geon0)*geos1
+			r.GeoSize = geos1
+		}
+		//hll
+		if int64(i) < hlln0 {
+			r.HLLMin = int64(i) * hlls0
+			r.HLLSize = hlls0
+		} else {
+			r.HLLMin = hlln0*hlls0 + (int64(i)-hlln0)*hlls1
+			r.HLLSize = hlls1
+		}
+		//bitmap
+		if int64(i) < bitmapn0 {
+			r.BitmapMin = int64(i) * bitmaps0
+			r.BitmapSize = bitmaps0
+		} else {
+			r.BitmapMin = bitmapn0*bitmaps0 + (int64(i)-bitmapn0)*bitmaps1
+			r.BitmapSize = bitmaps1
+		}
+
+		rg.Range[i] = r
+	}
+	rg.Rand = make([]*rand.Rand, rg.Num)
+	for i := range rg.Rand {
+		rg.Rand[i] = rand.New(rand.NewSource(streamSeed(rg.RandSeed, int64(i))))
+	}
+	rg.zipf = make([]*rand.Zipf, rg.Num)
+	for i := range rg.zipf {
+		rg.zipf[i] = rg.newZipf(rg.Rand[i])
+	}
+	rg.arena = make([]*keyArena, rg.Num)
+	for i := range rg.arena {
+		rg.arena[i] = &keyArena{}
+	}
+	// hash fields, set and sorted set members keep the historical names,
+	// the counter fields are apart from the hash fields
+	rg.members = map[string]*KeyTemplate{}
+	for _, typ := range []string{"hash", "set", "sortedset", "counter", "geo", "hll"} {
+		t := defaultTemplate()
+		t.Compile("", typ)
+		rg.members[typ] = t
+	}
+
+	// payload pool, the extra ValueLen bytes let every offset of the pool
+	// start a full value, the pool is seeded like a virtual worker -1
+	pr := rand.New(rand.NewSource(streamSeed(rg.RandSeed, -1)))
+	pool := make([]uint8, payloadPoolSize+rg.Param.ValueLen)
+	for i := range pool {
+		pool[i] = rg.Seed[pr.Intn(len(rg.Seed))]
+	}
+	rg.payload = string(pool)
+}
+
+// splitmix64 is the finalizer of the SplitMix64 generator, a bijection that
+// spreads close inputs over the whole range.
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// streamSeed returns the seed of the generator stream of seed: worker i uses
+// the stream i, the payload pool -1 and the scan jobs -2 and below. Mixing
+// the seed before adding the stream keeps the streams of nearby seeds apart,
+// seed+i would give worker i of seed s the stream of worker i-1 of seed s+1.
+func streamSeed(seed, stream int64) int64 {
+	return int64(splitmix64(splitmix64(uint64(seed)) + uint64(stream)))
+}
+
+// Replicate makes the workers id+k*Num, 0 < k < copies, generate exactly the
+// stream of worker id, so several targets can be driven with the same
+// commands. It must be called before anything is generated.
+func (rg *RandomGen) Replicate(copies int) {
+	num := int(rg.Num)
+	for c := 1; c < copies; c++ {
+		for i := 0; i < num; i++ {
+			rg.Range = append(rg.Range, rg.Range[i])
+			rg.Rand = append(rg.Rand, rand.New(rand.NewSource(streamSeed(rg.RandSeed, int64(i)))))
+			rg.zipf = append(rg.zipf, rg.newZipf(rg.Rand[len(rg.Rand)-1]))
+			rg.arena = append(rg.arena, &keyArena{})
+		}
+	}
+}
+
+// List gen random list key ...
+func (rg *RandomGen) List(id int) string {
+	r := rg.Range[id]
+	n := rg.Rand[id].Int63n(r.ListSize) + r.ListMin
+	return rg.format(id, rg.Param.ListTemplate, n)
+}
+
+// Queue returns one of the QueueNum queues, they are not partitioned.
+func (rg *RandomGen) Queue(id int) string {
+	n := rg.Rand[id].Int63n(rg.Param.QueueNum)
+	return rg.format(id, rg.Param.QueueTemplate, n)
+}
+
+// Stream gen random stream key ...
+func (rg *RandomGen) Stream(id int) string {
+	r := rg.Range[id]
+	n := rg.Rand[id].Int63n(r.StreamSize) + r.StreamMin
+	return rg.format(id, rg.Param.StreamTemplate, n)
+}
+
+// Counter returns a counter of the worker.
+func (rg *RandomGen) Counter(id int) string {
+	r := rg.Range[id]
+	n := rg.Rand[id].Int63n(r.CounterSize) + r.CounterMin
+	return rg.format(id, rg.Param.CounterTemplate, n)
+}
+
+// CounterField returns a counter field of the hashes, one of HashSize.
+func (rg *RandomGen) CounterField(id int) string {
+	n := rg.Rand[id].Int63n(rg.Param.HashSize)
+	return rg.format(id, rg.members["counter"], n)
+}
+
+// Geo returns a geo key of the worker.
+func (rg *RandomGen) Geo(id int) string {
+	r := rg.Range[id]
+	n := rg.Rand[id].Int63n(r.GeoSize) + r.GeoMin
+	return rg.format(id, rg.Param.GeoTemplate, n)
+}
+
+// GeoMember returns a member of the geo keys, one of GeoSize.
+func (rg *RandomGen) GeoMember(id int) string {
+	n := rg.Rand[id].Int63n(rg.Param.GeoSize)
+	return rg.format(id, rg.members["geo"], n)
+}
+
+// GeoPoint returns a longitude and a latitude of the geo area.
+func (rg *RandomGen) GeoPoint(id int) (lon, lat float64) {
+	g, rnd := rg.Param.Geo, rg.Rand[id]
+	return g.MinLon + rnd.Float64()*(g.MaxLon-g.MinLon), g.MinLat + rnd.Float64()*(g.MaxLat-g.MinLat)
+}
+
+// HLL returns a HyperLogLog of the worker.
+func (rg *RandomGen) HLL(id int) string {
+	r := rg.Range[id]
+	n := rg.Rand[id].Int63n(r.HLLSize) + r.HLLMin
+	return rg.format(id, rg.Param.HLLTemplate, n)
+}
+
+// HLLs returns count HyperLogLogs of worker id, see batch.
+func (rg *RandomGen) HLLs(id int, count int) []string {
+	r := rg.Range[id]
+	return rg.batch(id, rg.Param.HLLTemplate, r.HLLMin, r.HLLSize, count)
+}
+
+// HLLElements returns count distinct elements of the HyperLogLogs, of the
+// HLLSize ones, following each other from a random one.
+func (rg *RandomGen) HLLElements(id int, count int) []string {
+	size := rg.Param.HLLSize
+	if int64(count) > size {
+		count = int(size)
+	}
+	first := rg.Rand[id].Int63n(size)
+	elements := make([]string, count)
+	for i := range elements {
+		elements[i] = rg.format(id, rg.members["hll"], (first+int64(i))%size)
+	}
+	return elements
+}
+
+// Bitmap returns a bitmap of the worker.
+func (rg *RandomGen) Bitmap(id int) string {
+	r := rg.Range[id]
+	n := rg.Rand[id].Int63n(r.BitmapSize) + r.BitmapMin
+	return rg.format(id, rg.Param.BitmapTemplate, n)
+}
+
+// BitOffset returns a bit of the bitmaps, one of BitmapSize.
+func (rg *RandomGen) BitOffset(id int) int64 {
+	return rg.Rand[id].Int63n(rg.Param.BitmapSize)
+}
+
+// GroupStream returns one of the GroupNum streams, they are not partitioned.
+func (rg *RandomGen) GroupStream(id int) string {
+	n := rg.Rand[id].Int63n(rg.Param.GroupNum)
+	return rg.format(id, rg.Param.GroupTemplate, n)
+}
+
+// Channel returns one of the ChannelNum channels and its number, they are
+// not partitioned.
+func (rg *RandomGen) Channel(id int) (string, int64) {
+	n := rg.Rand[id].Int63n(rg.Param.ChannelNum)
+	return rg.format(id, rg.Param.ChannelTemplate, n), n
+}
+
+// Hot returns one of the HotNum hot keys, they are not partitioned.
+func (rg *RandomGen) Hot(id int) string {
+	n := rg.Rand[id].Int63n(rg.Param.HotNum)
+	return rg.format(id, rg.Param.HotTemplate, n)
+}
+
+// SortedSet gen random hash key ...
+func (rg *RandomGen) SortedSet(id int) string {
+	r := rg.Range[id]
+	n := rg.Rand[id].Int63n(r.SortedSetSize) + r.SortedSetMin
+	return rg.format(id, rg.Param.SortedSetTemplate, n)
+}
+
+// SortedSetField ...
+func (rg *RandomGen) SortedSetField(id int) string {
+	n := rg.Rand[id].Int63n(rg.Param.SortedSetSize)
+	return rg.format(id, rg.members["sortedset"], n)
+}
+
+// Set gen random hash key ...
+func (rg *RandomGen) Set(id int) string {
+	r := rg.Range[id]
+	n := rg.Rand[id].Int63n(r.SetSize) + r.SetMin
+	return rg.format(id, rg.Param.SetTemplate, n)
+}
+
+// SetField ...
+func (rg *RandomGen) SetField(id int) string {
+	n := rg.Rand[id].Int63n(rg.Param.SetSize)
+	return rg.format(id, rg.members["set"], n)
+}
+
+// Hash gen random hash key ...
+func (rg *RandomGen) Hash(id int) string {
+	r := rg.Range[id]
+	n := rg.Rand[id].Int63n(r.HashSize) + r.HashMin
+	return rg.format(id, rg.Param.HashTemplate, n)
+}
+
+// HashField ...
+func (rg *RandomGen) HashField(id int) string {
+	n := rg.Rand[id].Int63n(rg.Param.HashSize)
+	return rg.format(id, rg.members["hash"], n)
+}
+
+// Key gen random normal key ...
+func (rg *RandomGen) Key(id int) string {
+	r := rg.Range[id]
+	n := rg.Rand[id].Int63n(r.KeySize) + r.KeyMin
+	return rg.format(id, rg.Param.KeyTemplate, n)
+}
+
+// Keys returns count keys of worker id, see batch.
+func (rg *RandomGen) Keys(id int, count int) []string {
+	r := rg.Range[id]
+	return rg.batch(id, rg.Param.KeyTemplate, r.KeyMin, r.KeySize, count)
+}
+
+// Sets returns count sets of worker id, see batch.
+func (rg *RandomGen) Sets(id int, count int) []string {
+	r := rg.Range[id]
+	return rg.batch(id, rg.Param.SetTemplate, r.SetMin, r.SetSize, count)
+}
+
+// SortedSets returns count sorted sets of worker id, see batch.
+func (rg *RandomGen) SortedSets(id int, count int) []string {
+	r := rg.Range[id]
+	return rg.batch(id, rg.Param.SortedSetTemplate, r.SortedSetMin, r.SortedSetSize, count)
+}
+
+// batch returns count names of t numbered in [min, min+size). With -cluster
+// they share the hash tag of the first one, so a multi-key command on them
+// stays in one slot.
+func (rg *RandomGen) batch(id int, t *KeyTemplate, min, size int64, count int) []string {
+	rnd := rg.Rand[id]
+	kind := segLiteral
+	if Conf.Cluster {
+		kind, _ = t.hashTag()
+	}
+	first := rnd.Int63n(size) + min
+	names := make([]string, count)
+	for i := range names {
+		n := first
+		if i > 0 {
+			n = rnd.Int63n(size) + min
+		}
+		switch kind {
+		case segN:
+			n = first
+		case segTag:
+			// the number below n with the tag of first
+			n -= ((n-first)%t.Tags + t.Tags) % t.Tags
+			if n < min {
+				n += t.Tags
+			}
+			if n >= min+size {
+				n = first
+			}
+		case segGroup:
+			n = first/t.Group*t.Group + rnd.Int63n(t.Group)
+			if n < min || n >= min+size {
+				n = first
+			}
+		}
+		names[i] = rg.format(id, t, n)
+	}
+	return names
+}
+
+// Value returns a window of the payload pool, no allocation.
+func (rg *RandomGen) Value(id int) string {
+	off := rg.Rand[id].Int63n(payloadPoolSize)
+	return rg.payload[off : off+rg.Param.ValueLen]
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newTestGen() *RandomGen {
+	rg := &RandomGen{
+		Param: (&Param{}).Default(),
+		Num:   4,
+		Seed:  []uint8("abcdefghijklmnopqrstuvwxyz"),
+	}
+	if err := rg.Param.Compile(); err != nil {
+		panic(err)
+	}
+	rg.Init()
+	return rg
+}
+
+func TestKeyFormat(t *testing.T) {
+	rg := newTestGen()
+	for _, n := range []int64{0, 7, 123456789012, 999999999999} {
+		got := rg.format(0, rg.Param.KeyTemplate, n)
+		want := fmt.Sprintf("key_%012d_%012d_%012d", n, n, n)
+		if got != want {
+			t.Fatalf("expect %s, get %s", want, got)
+		}
+	}
+	// interned keys must survive later formatting
+	a := rg.format(0, rg.Param.KeyTemplate, 1)
+	rg.format(0, rg.Param.KeyTemplate, 2)
+	if a != fmt.Sprintf("key_%012d_%012d_%012d", 1, 1, 1) {
+		t.Fatalf("key overwritten: %s", a)
+	}
+}
+
+func TestGenAllocs(t *testing.T) {
+	rg := newTestGen()
+	if n := testing.AllocsPerRun(10000, func() { rg.Key(1) }); n > 0.01 {
+		t.Fatalf("Key allocates %v per run", n)
+	}
+	if n := testing.AllocsPerRun(10000, func() { rg.Value(1) }); n != 0 {
+		t.Fatalf("Value allocates %v per run", n)
+	}
+	if v := rg.Value(1); int64(len(v)) != rg.Param.ValueLen {
+		t.Fatalf("expect value length %d, get %d", rg.Param.ValueLen, len(v))
+	}
+}
+
+func BenchmarkKey(b *testing.B) {
+	rg := newTestGen()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rg.Key(0)
+	}
+}
+
+func BenchmarkValue(b *testing.B) {
+	rg := newTestGen()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rg.Value(0)
+	}
+}
+
+func TestReplicate(t *testing.T) {
+	rg := newTestGen()
+	rg.Replicate(2)
+	for i := 0; i < 100; i++ {
+		if a, b := rg.Key(1), rg.Key(5); a != b {
+			t.Fatalf("replica diverged: %s != %s", a, b)
+		}
+		if a, b := rg.Value(1), rg.Value(5); a != b {
+			t.Fatalf("replica diverged: %s != %s", a, b)
+		}
+	}
+}
+
+func TestStreamSeed(t *testing.T) {
+	seen := map[int64]string{}
+	for seed := int64(-2); seed < 10; seed++ {
+		for stream := int64(-4); stream < 100; stream++ {
+			s := streamSeed(seed, stream)
+			if prev, ok := seen[s]; ok {
+				t.Fatalf("seed %d stream %d overlaps %s", seed, stream, prev)
+			}
+			seen[s] = fmt.Sprintf("seed %d stream %d", seed, stream)
+		}
+	}
+}
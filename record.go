@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// recordMagic starts every command stream file.
+const recordMagic = "RPERF\x01"
+
+// Record is one command of a recorded stream.
+type Record struct {
+	// Time is the offset from the start of the recording
+	Time time.Duration
+	// Conn is the connection the command was sent on
+	Conn int
+	Args []string
+}
+
+// String ...
+func (rec *Record) String() string {
+	return strings.Join(rec.Args, " ")
+}
+
+// RecordWriter writes a command stream file.
+//
+// The file is the magic, the number of connections and then one record
+// after the other: the time delta to the previous record in microseconds,
+// the connection, the number of arguments and the length prefixed
+// arguments, all integers as uvarints. A name ending in .gz is gzipped.
+type RecordWriter struct {
+	mu    sync.Mutex
+	f     *os.File
+	gz    *gzip.Writer
+	w     *bufio.Writer
+	start time.Time
+	last  int64
+	tmp   [binary.MaxVarintLen64]byte
+	arg   []byte
+}
+
+// NewRecordWriter ...
+func NewRecordWriter(path string, conns int) (rw *RecordWriter, err error) {
+	rw = &RecordWriter{start: time.Now()}
+	if rw.f, err = os.Create(path); err != nil {
+		return nil, err
+	}
+	var w io.Writer = rw.f
+	if strings.HasSuffix(path, ".gz") {
+		rw.gz = gzip.NewWriter(rw.f)
+		w = rw.gz
+	}
+	rw.w = bufio.NewWriterSize(w, 1<<20)
+	rw.w.WriteString(recordMagic)
+	rw.uvarint(uint64(conns))
+	return rw, nil
+}
+
+func (rw *RecordWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(rw.tmp[:], v)
+	rw.w.Write(rw.tmp[:n])
+}
+
+// Write appends the command sent at now on conn.
+func (rw *RecordWriter) Write(now time.Time, conn int, cmd string, args []interface{}) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.w == nil {
+		return
+	}
+	us := int64(now.Sub(rw.start) / time.Microsecond)
+	if us < rw.last {
+		us = rw.last
+	}
+	rw.uvarint(uint64(us - rw.last))
+	rw.last = us
+	rw.uvarint(uint64(conn))
+	rw.uvarint(uint64(len(args) + 1))
+	rw.uvarint(uint64(len(cmd)))
+	rw.w.WriteString(cmd)
+	for _, arg := range args {
+		rw.arg = appendArg(rw.arg[:0], arg)
+		rw.uvarint(uint64(len(rw.arg)))
+		rw.w.Write(rw.arg)
+	}
+}
+
+// Close flushes and closes the file, later writes are dropped.
+func (rw *RecordWriter) Close() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.w == nil {
+		return nil
+	}
+	err := rw.w.Flush()
+	rw.w = nil
+	if rw.gz != nil {
+		if e := rw.gz.Close(); err == nil {
+			err = e
+		}
+	}
+	if e := rw.f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// Hook wraps conn so every command sent on it is recorded, see ConnHook.
+func (rw *RecordWriter) Hook(conn redis.Conn, id int) redis.Conn {
+	return &recordConn{Conn: conn, rw: rw, id: id}
+}
+
+// appendArg encodes an argument the way redigo puts it on the wire.
+func appendArg(b []byte, arg interface{}) []byte {
+	switch arg := arg.(type) {
+	case string:
+		return append(b, arg...)
+	case []byte:
+		return append(b, arg...)
+	case int:
+		return strconv.AppendInt(b, int64(arg), 10)
+	case int64:
+		return strconv.AppendInt(b, arg, 10)
+	case float64:
+		return strconv.AppendFloat(b, arg, 'g', -1, 64)
+	case bool:
+		if arg {
+			return append(b, '1')
+		}
+		return append(b, '0')
+	case nil:
+		return b
+	default:
+		return append(b, fmt.Sprint(arg)...)
+	}
+}
+
+// recordConn records the commands sent on a connection.
+type recordConn struct {
+	redis.Conn
+	rw *RecordWriter
+	id int
+}
+
+func (c *recordConn) Send(cmd string, args ...interface{}) error {
+	c.rw.Write(time.Now(), c.id, cmd, args)
+	return c.Conn.Send(cmd, args...)
+}
+
+func (c *recordConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "" {
+		c.rw.Write(time.Now(), c.id, cmd, args)
+	}
+	return c.Conn.Do(cmd, args...)
+}
+
+// RecordReader reads a command stream file.
+type RecordReader struct {
+	f     *os.File
+	r     *bufio.Reader
+	last  int64
+	Conns int
+}
+
+// OpenRecord ...
+func OpenRecord(path string) (rr *RecordReader, err error) {
+	rr = &RecordReader{}
+	if rr.f, err = os.Open(path); err != nil {
+		return nil, err
+	}
+	var r io.Reader = rr.f
+	if strings.HasSuffix(path, ".gz") {
+		if r, err = gzip.NewReader(rr.f); err != nil {
+			rr.f.Close()
+			return nil, err
+		}
+	}
+	rr.r = bufio.NewReaderSize(r, 1<<20)
+	magic := make([]byte, len(recordMagic))
+	if _, err := io.ReadFull(rr.r, magic); err != nil || string(magic) != recordMagic {
+		rr.f.Close()
+		return nil, fmt.Errorf("%s is not a command stream file", path)
+	}
+	conns, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		rr.f.Close()
+		return nil, err
+	}
+	rr.Conns = int(conns)
+	return rr, nil
+}
+
+// Next returns the next record, io.EOF at the end of the stream.
+func (rr *RecordReader) Next() (*Record, error) {
+	delta, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, err
+	}
+	rr.last += int64(delta)
+	rec := &Record{Time: time.Duration(rr.last) * time.Microsecond}
+	conn, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	rec.Conn = int(conn)
+	argc, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	rec.Args = make([]string, argc)
+	for i := range rec.Args {
+		n, err := binary.ReadUvarint(rr.r)
+		if err != nil {
+			return nil, truncated(err)
+		}
+		arg := make([]byte, n)
+		if _, err := io.ReadFull(rr.r, arg); err != nil {
+			return nil, truncated(err)
+		}
+		rec.Args[i] = string(arg)
+	}
+	return rec, nil
+}
+
+func truncated(err error) error {
+	if err == io.EOF {
+		return errors.New("truncated command stream")
+	}
+	return err
+}
+
+// Close ...
+func (rr *RecordReader) Close() error {
+	return rr.f.Close()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	for _, name := range []string{"stream.rec", "stream.rec.gz"} {
+		path := filepath.Join(t.TempDir(), name)
+		rw, err := NewRecordWriter(path, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := rw.start
+		rw.Write(now.Add(5*time.Millisecond), 2, "SET", []interface{}{"k", []byte("v v"), 12, int64(-3), 1.5})
+		rw.Write(now.Add(7*time.Millisecond), 0, "GET", []interface{}{"k"})
+		if err := rw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		rr, err := OpenRecord(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rr.Conns != 3 {
+			t.Fatalf("expect 3 conns, get %d", rr.Conns)
+		}
+		want := []*Record{
+			{Time: 5 * time.Millisecond, Conn: 2, Args: []string{"SET", "k", "v v", "12", "-3", "1.5"}},
+			{Time: 7 * time.Millisecond, Conn: 0, Args: []string{"GET", "k"}},
+		}
+		for _, w := range want {
+			rec, err := rr.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rec, w) {
+				t.Fatalf("expect %+v, get %+v", w, rec)
+			}
+		}
+		if _, err := rr.Next(); err != io.EOF {
+			t.Fatalf("expect EOF, get %v", err)
+		}
+		rr.Close()
+	}
+
+	path := filepath.Join(t.TempDir(), "bad")
+	os.WriteFile(path, []byte("nope"), 0644)
+	if _, err := OpenRecord(path); err == nil {
+		t.Fatal("expect error for a file without magic")
+	}
+}
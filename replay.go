@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io"
+	"log"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// RecordSource is a stream of commands to replay.
+type RecordSource interface {
+	// Next returns io.EOF once the stream is exhausted
+	Next() (*Record, error)
+}
+
+// NewReplay sends the commands of src to addr on conns connections. Commands
+// of the same recorded connection stay in order on one connection. speed
+// scales the recorded timing, 2 is twice as fast, 0 is as fast as possible.
+// result is closed once every reply has been read or Stop is closed.
+func NewReplay(addr string, src RecordSource, conns int, speed float64) (result chan *Result) {
+	perf := &Perf{
+		addr:         addr,
+		connTotalNum: int64(conns),
+	}
+	workers := make([]*TokenBucketWorker, conns)
+	queues := make([]chan *Record, conns)
+	for index := range workers {
+		workers[index] = newWorker(index, perf)
+		queues[index] = make(chan *Record, 1000)
+		tasks := workers[index].LoopReplay(queues[index])
+		workers[index].LoopReader(tasks)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		start := time.Now()
+		for {
+			rec, err := src.Next()
+			if err != nil {
+				if err != io.EOF {
+					log.Println("replay stopped:", err)
+				}
+				break
+			}
+			if speed > 0 {
+				if d := time.Duration(float64(rec.Time)/speed) - time.Since(start); d > 0 {
+					time.Sleep(d)
+				}
+			}
+			select {
+			case queues[rec.Conn%conns] <- rec:
+			case <-Stop:
+				return
+			}
+		}
+		for _, q := range queues {
+			close(q)
+		}
+		for _, w := range workers {
+			<-w.done
+		}
+		close(done)
+	}()
+
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+		case <-Stop:
+		}
+		close(stop)
+	}()
+
+	return GenResult(workers, stop)
+}
+
+// LoopReplay sends the records of queue, like LoopWriter does for the
+// executors. tasks is closed once queue is.
+func (w *TokenBucketWorker) LoopReplay(queue chan *Record) (tasks chan *Request) {
+	tasks = make(chan *Request, 100000)
+	go func() {
+		defer close(tasks)
+		var conn redis.Conn
+		for rec := range queue {
+			if len(rec.Args) == 0 {
+				continue
+			}
+			if conn == nil || conn.Err() != nil {
+				conn = w.dial()
+			}
+			args := make([]interface{}, len(rec.Args)-1)
+			for i, arg := range rec.Args[1:] {
+				args[i] = arg
+			}
+			conn.Send(rec.Args[0], args...)
+			conn.Flush()
+			r := &Request{
+				Opstr: rec.String(),
+				valid: replyError,
+				Conn:  conn,
+			}
+			r.RecordStart()
+			tasks <- r
+		}
+	}()
+	return tasks
+}
+
+// replyError accepts any reply, only errors count.
+func replyError(reply interface{}, err error) error {
+	return err
+}
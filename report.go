@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RunReport is the result file of a run, see -out and the compare command.
+type RunReport struct {
+	Version   string
+	Time      time.Time
+	Addr      string
+	Flags     map[string]string
+	Total     *ReportStats
+	Cmds      map[string]*ReportStats
+	Intervals []*ReportStats
+}
+
+// ReportStats are the statistics of a run, a command or an interval,
+// latencies in us and Err in percent.
+type ReportStats struct {
+	// Time is the end of an interval
+	Time time.Time `yaml:",omitempty"`
+	QPS  int64
+	Num  int64
+	Err  float64
+	Mean int64
+	P50  int64
+	P90  int64
+	P99  int64
+	P999 int64
+	Max  int64
+	// Cache are the cache reads per data type in cache mode
+	Cache map[string]*CacheStatus `yaml:",omitempty"`
+	// Queue is the time from push to pop of the queue workload
+	Queue *ReportStats `yaml:",omitempty"`
+	// Stream is the time from XADD to XREADGROUP of the group workload
+	Stream *ReportStats `yaml:",omitempty"`
+	// Pending is the length of the pending entries lists of a Stream and
+	// Claimed the messages recovered with XCLAIM
+	Pending int64 `yaml:",omitempty"`
+	Claimed int64 `yaml:",omitempty"`
+	// PubSub is the time from publish to receive of the pubsub workload,
+	// Lost the messages missed by a subscriber and FanOut the messages
+	// received per message published
+	PubSub *ReportStats `yaml:",omitempty"`
+	Lost   int64        `yaml:",omitempty"`
+	FanOut float64      `yaml:",omitempty"`
+	// Scan is the latency of the calls of the scan jobs and Iteration the
+	// time of their full iterations
+	Scan      *ReportStats `yaml:",omitempty"`
+	Iteration *ReportStats `yaml:",omitempty"`
+	// Conflict are the aborted WATCH transactions in percent
+	Conflict float64 `yaml:",omitempty"`
+	// Server is set on intervals when INFO was polled
+	Server *ServerStats `yaml:",omitempty"`
+	// Slow are the SLOWLOG and LATENCY entries of an interval, see -slowlog
+	Slow []*SlowEntry `yaml:",omitempty"`
+}
+
+// NewReportStats ...
+func NewReportStats(qps, num, errs int64, hist *Histogram) *ReportStats {
+	s := &ReportStats{QPS: qps, Num: num}
+	if num > 0 {
+		s.Err = 100 * float64(errs) / float64(num)
+	}
+	if hist != nil {
+		s.Mean = hist.Mean()
+		s.P50 = hist.Percentile(50)
+		s.P90 = hist.Percentile(90)
+		s.P99 = hist.Percentile(99)
+		s.P999 = hist.Percentile(99.9)
+		s.Max = hist.Max
+	}
+	return s
+}
+
+// NewStreamReport ...
+func NewStreamReport(qps int64, s *StreamStatus) *ReportStats {
+	stats := NewReportStats(qps, s.Num, s.Err, s.Hist)
+	stats.Pending = s.Pending
+	stats.Claimed = s.Claimed
+	return stats
+}
+
+// NewPubSubReport ...
+func NewPubSubReport(qps int64, p *PubSubStatus) *ReportStats {
+	stats := NewReportStats(qps, p.Num, p.Err, p.Hist)
+	stats.Lost = p.Lost
+	stats.FanOut = p.FanOut()
+	return stats
+}
+
+// NewScanReport returns the calls and the iterations of the scan jobs.
+func NewScanReport(qps int64, s *ScanStatus) (calls, iterations *ReportStats) {
+	return NewReportStats(qps, s.Calls, s.Err, s.Hist), NewReportStats(0, s.Iterations, 0, s.Iter)
+}
+
+// NewRunReport builds the result file from the whole run and its intervals.
+func NewRunReport(total *Result, intervals []*ReportStats) *RunReport {
+	rep := &RunReport{
+		Version:   Version,
+		Time:      time.Now(),
+		Addr:      Conf.Addr,
+		Flags:     map[string]string{},
+		Total:     NewReportStats(total.QPS, total.Num, total.Err, total.Hist),
+		Cmds:      map[string]*ReportStats{},
+		Intervals: intervals,
+	}
+	if len(total.Cache) > 0 {
+		rep.Total.Cache = total.Cache
+	}
+	rep.Total.Conflict = total.ConflictRate()
+	if q := total.Queue; q != nil {
+		qps := int64(0)
+		if total.Num > 0 {
+			qps = total.QPS * q.Num / total.Num
+		}
+		rep.Total.Queue = NewReportStats(qps, q.Num, q.Err, q.Hist)
+	}
+	if s := total.Stream; s != nil {
+		qps := int64(0)
+		if total.Num > 0 {
+			qps = total.QPS * s.Num / total.Num
+		}
+		rep.Total.Stream = NewStreamReport(qps, s)
+	}
+	if p := total.PubSub; p != nil {
+		qps := int64(0)
+		if total.Num > 0 {
+			qps = total.QPS * p.Num / total.Num
+		}
+		rep.Total.PubSub = NewPubSubReport(qps, p)
+	}
+	if s := total.Scan; s != nil {
+		qps := int64(0)
+		if total.Num > 0 {
+			qps = total.QPS * s.Calls / total.Num
+		}
+		rep.Total.Scan, rep.Total.Iteration = NewScanReport(qps, s)
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		rep.Flags[f.Name] = f.Value.String()
+	})
+	for name, c := range total.Cmds {
+		qps := int64(0)
+		if total.Num > 0 {
+			qps = total.QPS * c.Num / total.Num
+		}
+		rep.Cmds[name] = NewReportStats(qps, c.Num, c.Err, c.Hist)
+	}
+	return rep
+}
+
+// Save ...
+func (rep *RunReport) Save(path string) error {
+	content, err := yaml.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// LoadRunReport ...
+func LoadRunReport(path string) (*RunReport, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rep := &RunReport{}
+	if err := yaml.Unmarshal(content, rep); err != nil {
+		return nil, err
+	}
+	if rep.Total == nil {
+		rep.Total = &ReportStats{}
+	}
+	return rep, nil
+}
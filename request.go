@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
@@ -15,6 +16,12 @@ type Request struct {
 	Stop  int64
 	Last  bool
 	Conn  redis.Conn
+	// Read is the data type of a cache read, its nil reply is a miss in
+	// cache mode, see ErrMiss
+	Read string
+	// Done is set on the requests the scenario sent on a SideConn and
+	// validated already, the reader only records them
+	Done bool
 }
 
 // RecordStart ...
@@ -31,3 +38,17 @@ func (r *Request) RecordStop() {
 func (r *Request) ResponseTime() int64 {
 	return r.Stop - r.Start
 }
+
+// Cmd returns the upper case command name of Opstr.
+func (r *Request) Cmd() string {
+	name := r.Opstr
+	if i := strings.IndexByte(name, ' '); i >= 0 {
+		name = name[:i]
+	}
+	for i := 0; i < len(name); i++ {
+		if 'a' <= name[i] && name[i] <= 'z' {
+			return strings.ToUpper(name)
+		}
+	}
+	return name
+}
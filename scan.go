@@ -0,0 +1,524 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ScanExecutor sends the first call of SCAN, HSCAN, SSCAN and ZSCAN
+// iterations, ScanJobs iterate fully in the background.
+var ScanExecutor = &RandomExecutor{Name: "scan"}
+
+// scanCmds are the commands of -scan-cmds.
+var scanCmds = map[string]bool{"scan": true, "hscan": true, "sscan": true, "zscan": true}
+
+// scanTypes are the data types the model follows and their TYPE for SCAN.
+var scanTypes = []struct{ kind, typ string }{
+	{KeyExecutor.Name, "string"},
+	{HashExecutor.Name, "hash"},
+	{SetExecutor.Name, "set"},
+	{SortedSetExecutor.Name, "zset"},
+}
+
+func init() {
+	Executors = append(Executors, ScanExecutor)
+
+	// SCAN MATCH TYPE of the first keys of a data type
+	ScanExecutor.Add("scan", 1, func(conn redis.Conn, id int) (rs []*Request) {
+		t := scanTypes[RGen.Rand[id].Intn(len(scanTypes))]
+		pattern := scanTemplate(t.kind).Pattern()
+
+		conn.Send("SCAN", 0, "MATCH", pattern, "COUNT", Conf.ScanCount, "TYPE", t.typ)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("SCAN 0 MATCH %s COUNT %d TYPE %s", pattern, Conf.ScanCount, t.typ),
+			valid: func(reply interface{}, err error) error {
+				_, values, err := parseScan(reply, err)
+				if err != nil {
+					return err
+				}
+				_, err = scanElements("scan", pattern, values)
+				return err
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// HSCAN, SSCAN and ZSCAN of the first members of a collection, the
+	// whole collection when the iteration ends there
+	for _, cmd := range []string{"hscan", "sscan", "zscan"} {
+		cmd := cmd
+		ScanExecutor.Add(cmd, 3, func(conn redis.Conn, id int) (rs []*Request) {
+			kind := scanKind(cmd)
+			key := scanCollection(id, kind)
+			upper := strings.ToUpper(cmd)
+
+			conn.Send(upper, key, 0, "COUNT", Conf.ScanCount)
+			rs = append(rs, &Request{
+				Opstr: fmt.Sprintf("%s %s 0 COUNT %d", upper, key, Conf.ScanCount),
+				valid: func(reply interface{}, err error) error {
+					cursor, values, err := parseScan(reply, err)
+					if err != nil {
+						return err
+					}
+					elements, err := scanElements(cmd, "", values)
+					if err != nil || cursor != "0" {
+						return err
+					}
+					return Model.scanned(id, kind, key, elements)
+				},
+			})
+			conn.Flush()
+
+			return rs
+		})
+	}
+}
+
+// scanTemplate returns the key template of the data type kind.
+func scanTemplate(kind string) *KeyTemplate {
+	switch kind {
+	case HashExecutor.Name:
+		return RGen.Param.HashTemplate
+	case SetExecutor.Name:
+		return RGen.Param.SetTemplate
+	case SortedSetExecutor.Name:
+		return RGen.Param.SortedSetTemplate
+	}
+	return RGen.Param.KeyTemplate
+}
+
+// scanKind returns the data type iterated by HSCAN, SSCAN or ZSCAN.
+func scanKind(cmd string) string {
+	switch cmd {
+	case "hscan":
+		return HashExecutor.Name
+	case "sscan":
+		return SetExecutor.Name
+	}
+	return SortedSetExecutor.Name
+}
+
+// scanCollection returns a collection of kind of the worker.
+func scanCollection(id int, kind string) string {
+	switch kind {
+	case HashExecutor.Name:
+		return RGen.Hash(id)
+	case SetExecutor.Name:
+		return RGen.Set(id)
+	}
+	return RGen.SortedSet(id)
+}
+
+// parseScan parses the cursor and the values of a SCAN family reply.
+func parseScan(reply interface{}, err error) (cursor string, values []string, _ error) {
+	result, err := redis.Values(reply, err)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(result) != 2 {
+		return "", nil, fmt.Errorf("expect a cursor and values, get %d values", len(result))
+	}
+	if cursor, err = redis.String(result[0], nil); err != nil {
+		return "", nil, err
+	}
+	values, err = redis.Strings(result[1], nil)
+	return cursor, values, err
+}
+
+// scanElements returns the keys, fields or members of the values of cmd,
+// the keys of SCAN must match pattern and the scores of ZSCAN be numbers.
+func scanElements(cmd, pattern string, values []string) ([]string, error) {
+	switch cmd {
+	case "scan":
+		for _, key := range values {
+			if !globMatch(pattern, key) {
+				return nil, fmt.Errorf("%s does not match %s", key, pattern)
+			}
+		}
+		return values, nil
+	case "sscan":
+		return values, nil
+	}
+	if len(values)%2 != 0 {
+		return nil, fmt.Errorf("expect pairs, get %d values", len(values))
+	}
+	elements := make([]string, len(values)/2)
+	for i := range elements {
+		elements[i] = values[2*i]
+		if cmd != "zscan" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(values[2*i+1], 64); err != nil {
+			return nil, fmt.Errorf("%s: bad score %s", values[2*i], values[2*i+1])
+		}
+	}
+	return elements, nil
+}
+
+// globMatch matches s against pattern as redis does, for the * and the
+// escapes of KeyTemplate.Pattern.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+		}
+		if s == "" || s[0] != pattern[0] {
+			return false
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+// scanElem is a key, or a member of a collection, an iteration must return,
+// seq is its write.
+type scanElem struct {
+	id  int
+	k   keyField
+	seq int64
+}
+
+// known returns the live members of key of kind worker id wrote before
+// before, every one when before is zero.
+func (m *ConsistencyModel) known(id int, kind, key string, before time.Time) (elems []scanElem) {
+	w := m.workers[id]
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for field := range w.fields[key] {
+		k := keyField{key, field}
+		if e := w.get(k); e != nil && e.Kind == kind && !e.Deleted && (before.IsZero() || e.At.Before(before)) {
+			elems = append(elems, scanElem{id, k, e.Seq})
+		}
+	}
+	return elems
+}
+
+// scanChunk is the number of keys knownKeys looks up per lock of a worker,
+// the validators of the worker wait at most that long.
+const scanChunk = 256
+
+// knownKeys returns a live write of every key of kind the workers wrote
+// before before. The keys of a worker are walked scanChunk at a time, the
+// lock is released in between.
+func (m *ConsistencyModel) knownKeys(kind string, before time.Time) (elems []scanElem) {
+	for id, w := range m.workers {
+		for i := 0; ; i += scanChunk {
+			w.mu.Lock()
+			keys := w.kinds[kind]
+			if i >= len(keys) {
+				w.mu.Unlock()
+				break
+			}
+			if len(keys) > i+scanChunk {
+				keys = keys[:i+scanChunk]
+			}
+			for _, key := range keys[i:] {
+				for field := range w.fields[key] {
+					k := keyField{key, field}
+					if e := w.get(k); e != nil && e.Kind == kind && !e.Deleted && e.At.Before(before) {
+						elems = append(elems, scanElem{id, k, e.Seq})
+						break
+					}
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+	return elems
+}
+
+// alive tells whether the write of e is still the last one.
+func (m *ConsistencyModel) alive(e scanElem) bool {
+	w := m.workers[e.id]
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	last := w.get(e.k)
+	return last != nil && last.Seq == e.seq
+}
+
+// scanned checks that the elements of a whole iteration of key of kind by
+// worker id hold every member the model knows. The replies of the worker
+// are read in order, the model holds every write sent before.
+func (m *ConsistencyModel) scanned(id int, kind, key string, elements []string) error {
+	if m == nil || Conf.Cache {
+		return nil
+	}
+	seen := make(map[string]bool, len(elements))
+	for _, e := range elements {
+		seen[e] = true
+	}
+	for _, e := range m.known(id, kind, key, time.Time{}) {
+		if !seen[e.k.field] {
+			return fmt.Errorf("%s %s missing from the whole iteration", kind, e.k)
+		}
+	}
+	return nil
+}
+
+// scanGrace is how long the jobs wait for the replies of the deletes that
+// may have been applied during an iteration before they count an element
+// missing.
+const scanGrace = time.Second
+
+// ScanStatus counts the calls of the scan jobs during an interval, Hist is
+// their latency and Iter the time of a full iteration in us. Missing are the
+// elements the iterations did not return.
+type ScanStatus struct {
+	Calls      int64
+	Err        int64
+	Iterations int64
+	Missing    int64
+	Hist       *Histogram
+	Iter       *Histogram
+}
+
+func newScanStatus() *ScanStatus {
+	return &ScanStatus{Hist: NewHistogram(), Iter: NewHistogram()}
+}
+
+// Merge adds o into s.
+func (s *ScanStatus) Merge(o *ScanStatus) {
+	if o == nil {
+		return
+	}
+	s.Calls += o.Calls
+	s.Err += o.Err
+	s.Iterations += o.Iterations
+	s.Missing += o.Missing
+	s.Hist.Merge(o.Hist)
+	s.Iter.Merge(o.Iter)
+}
+
+// String ...
+func (s *ScanStatus) String() string {
+	return fmt.Sprintf("scan calls %d\tp99 %dus\titerations %d\tp50 %dms\tp99 %dms\tmissing %d",
+		s.Calls, s.Hist.Percentile(99), s.Iterations, s.Iter.Percentile(50)/1000, s.Iter.Percentile(99)/1000, s.Missing)
+}
+
+// ScanJobs iterate fully the keyspace, with SCAN MATCH TYPE of a data type,
+// and collections of the workers, with -scan-cmds in turn, on their own
+// connections next to the workload. The model tells the elements each
+// iteration must return.
+type ScanJobs struct {
+	addr   string
+	cmds   []string
+	mu     sync.Mutex
+	status *ScanStatus
+}
+
+// NewScanJobs starts -scan-jobs jobs iterating addr, nil unless -scan-jobs
+// is set.
+func NewScanJobs(addr string) *ScanJobs {
+	if Conf.ScanJobs <= 0 {
+		return nil
+	}
+	s := &ScanJobs{addr: addr, cmds: strings.Split(Conf.ScanCmds, ","), status: newScanStatus()}
+	for i := 0; i < Conf.ScanJobs; i++ {
+		go s.run(i)
+	}
+	return s
+}
+
+func (s *ScanJobs) run(job int) {
+	var conn redis.Conn
+	// the jobs draw apart from the workers and the payload pool
+	rnd := rand.New(rand.NewSource(streamSeed(RGen.RandSeed, -2-int64(job))))
+	b := &backoff{name: fmt.Sprintf("scan job %d %s", job, s.addr)}
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for n := job; ; n++ {
+		select {
+		case <-Stop:
+			return
+		default:
+		}
+		if conn == nil || conn.Err() != nil {
+			var err error
+			if conn, err = redis.Dial("tcp", s.addr, redis.DialConnectTimeout(time.Second),
+				redis.DialReadTimeout(5*time.Second), redis.DialWriteTimeout(5*time.Second)); err != nil {
+				conn = nil
+				if !b.retry(err) {
+					return
+				}
+				continue
+			}
+		}
+		if err := s.iterate(conn, rnd, s.cmds[n%len(s.cmds)]); err != nil {
+			if !b.retry(err) {
+				return
+			}
+			continue
+		}
+		b.ok()
+	}
+}
+
+// iterate runs one full iteration with cmd, it gives up on an error or on
+// Stop and returns the error.
+func (s *ScanJobs) iterate(conn redis.Conn, rnd *rand.Rand, cmd string) error {
+	var (
+		id          int
+		kind, key   string
+		pattern     string
+		args        []interface{}
+		upper       = strings.ToUpper(cmd)
+		seen        = map[string]bool{}
+		cursor      = "0"
+		start       = time.Now()
+		description string
+	)
+	if cmd == "scan" {
+		t := scanTypes[rnd.Intn(len(scanTypes))]
+		kind, pattern = t.kind, scanTemplate(t.kind).Pattern()
+		args = []interface{}{"MATCH", pattern, "COUNT", Conf.ScanCount, "TYPE", t.typ}
+		description = fmt.Sprintf("SCAN MATCH %s TYPE %s", pattern, t.typ)
+	} else {
+		kind = scanKind(cmd)
+		id, key = s.collection(rnd, kind)
+		args = []interface{}{"COUNT", Conf.ScanCount}
+		description = upper + " " + key
+	}
+
+	for {
+		select {
+		case <-Stop:
+			return nil
+		default:
+		}
+		call := append([]interface{}{cursor}, args...)
+		if key != "" {
+			call = append([]interface{}{key}, call...)
+		}
+		callStart := time.Now()
+		next, values, err := parseScan(conn.Do(upper, call...))
+		latency := time.Since(callStart)
+		var elements []string
+		if err == nil {
+			elements, err = scanElements(cmd, pattern, values)
+		}
+		s.record(latency, err)
+		if err != nil {
+			if Conf.Debug {
+				log.Println("scan", description, err)
+			}
+			return err
+		}
+		for _, e := range elements {
+			seen[e] = true
+		}
+		if next == "0" {
+			break
+		}
+		cursor = next
+	}
+	elapsed := time.Since(start)
+
+	var missing []scanElem
+	if Model != nil && !Conf.Cache {
+		var known []scanElem
+		if key == "" {
+			known = Model.knownKeys(kind, start)
+		} else {
+			known = Model.known(id, kind, key, start)
+		}
+		for _, e := range known {
+			name := e.k.field
+			if key == "" {
+				name = e.k.key
+			}
+			if !seen[name] {
+				missing = append(missing, e)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		select {
+		case <-Stop:
+			return nil
+		case <-time.After(scanGrace):
+		}
+		still := missing[:0]
+		for _, e := range missing {
+			if Model.alive(e) {
+				still = append(still, e)
+			}
+		}
+		missing = still
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Iterations++
+	s.status.Iter.Record(int64(elapsed / time.Microsecond))
+	for _, e := range missing {
+		s.status.Missing++
+		if Conf.Debug || s.status.Missing <= mirrorLogLimit {
+			log.Printf("scan: %s %s missing from %s started at %s\n", kind, e.k, description, start.Format(modelTime))
+		}
+	}
+	return nil
+}
+
+// collection returns a collection of kind of a random worker.
+func (s *ScanJobs) collection(rnd *rand.Rand, kind string) (int, string) {
+	id := rnd.Intn(int(RGen.Num))
+	r := RGen.Range[id]
+	switch kind {
+	case HashExecutor.Name:
+		return id, RGen.Param.HashTemplate.Render(id, r.HashMin+rnd.Int63n(r.HashSize))
+	case SetExecutor.Name:
+		return id, RGen.Param.SetTemplate.Render(id, r.SetMin+rnd.Int63n(r.SetSize))
+	}
+	return id, RGen.Param.SortedSetTemplate.Render(id, r.SortedSetMin+rnd.Int63n(r.SortedSetSize))
+}
+
+func (s *ScanJobs) record(latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Calls++
+	if err != nil {
+		s.status.Err++
+		return
+	}
+	s.status.Hist.Record(int64(latency / time.Microsecond))
+}
+
+// Attach sets r.Scan to the calls since the previous call, nil jobs attach
+// nothing.
+func (s *ScanJobs) Attach(r *Result) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.Scan = s.status
+	s.status = newScanStatus()
+}
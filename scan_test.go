@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestScanPattern(t *testing.T) {
+	cases := []struct {
+		t       KeyTemplate
+		pattern string
+		key     string
+	}{
+		{*defaultTemplate(), "key_*_*_*", "key_000000000001_000000000001_000000000001"},
+		{KeyTemplate{Format: "app:{${tag}}:*${n}", Tags: 4}, "app:{*}:\\**", "app:{1}:*5"},
+		{KeyTemplate{Format: "k${n}", Len: 10}, "k*", "k5xxxxxxxx"},
+	}
+	for _, c := range cases {
+		if err := c.t.Compile("", "key"); err != nil {
+			t.Fatal(err)
+		}
+		if p := c.t.Pattern(); p != c.pattern {
+			t.Fatalf("%s: expect %s, get %s", c.t.Format, c.pattern, p)
+		}
+		if key := c.t.Render(0, 5); !globMatch(c.pattern, key) {
+			t.Fatalf("%s: %s does not match %s", c.t.Format, key, c.pattern)
+		}
+		if !globMatch(c.pattern, c.key) {
+			t.Fatalf("%s does not match %s", c.key, c.pattern)
+		}
+	}
+	if globMatch("key_*_*_*", "hash_1_1_1") || globMatch("app:{*}:\\**", "app:{1}:5") {
+		t.Fatal("expect no match")
+	}
+}
+
+func TestScanElements(t *testing.T) {
+	if e, err := scanElements("zscan", "", []string{"a", "1.5", "b", "2"}); err != nil || len(e) != 2 || e[1] != "b" {
+		t.Fatalf("unexpected %v %v", e, err)
+	}
+	if _, err := scanElements("zscan", "", []string{"a", "x"}); err == nil {
+		t.Fatal("expect a bad score")
+	}
+	if _, err := scanElements("hscan", "", []string{"a"}); err == nil {
+		t.Fatal("expect pairs")
+	}
+	if _, err := scanElements("scan", "key_*", []string{"key_1", "set_1"}); err == nil {
+		t.Fatal("expect a key out of the pattern")
+	}
+}
+
+func TestScanKnown(t *testing.T) {
+	m := &ConsistencyModel{workers: []*workerModel{{entries: map[keyField]*modelEntry{}, forgot: map[string]int64{}}}}
+	w := m.workers[0]
+	before := time.Now().Add(-time.Second)
+	w.put(keyField{"s", "a"}, &modelEntry{Kind: SetExecutor.Name, At: before})
+	w.put(keyField{"s", "b"}, &modelEntry{Kind: SetExecutor.Name, At: before})
+	w.put(keyField{"s", "c"}, &modelEntry{Kind: SetExecutor.Name, Deleted: true, At: before})
+	w.put(keyField{"k", ""}, &modelEntry{Kind: KeyExecutor.Name, At: time.Now()})
+
+	if err := m.scanned(0, SetExecutor.Name, "s", []string{"b", "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.scanned(0, SetExecutor.Name, "s", []string{"a", "c"}); err == nil {
+		t.Fatal("expect b missing")
+	}
+	// k was written after the iteration started
+	if keys := m.knownKeys(KeyExecutor.Name, before.Add(time.Millisecond)); len(keys) != 0 {
+		t.Fatalf("expect no key, get %v", keys)
+	}
+	keys := m.knownKeys(SetExecutor.Name, time.Now())
+	if len(keys) != 1 || keys[0].k.key != "s" || !m.alive(keys[0]) {
+		t.Fatalf("expect s, get %v", keys)
+	}
+	w.put(keys[0].k, &modelEntry{Kind: SetExecutor.Name, Deleted: true, At: time.Now()})
+	if m.alive(keys[0]) {
+		t.Fatal("expect a deleted member not alive")
+	}
+	w.drop(keyField{"s", "a"})
+	w.drop(keyField{"s", "b"})
+	w.drop(keyField{"s", "c"})
+	if _, ok := w.fields["s"]; ok {
+		t.Fatal("expect the index of s dropped")
+	}
+
+	// more keys than a chunk, each found once
+	for i := 0; i < 2*scanChunk+10; i++ {
+		w.put(keyField{fmt.Sprintf("k%d", i), ""}, &modelEntry{Kind: KeyExecutor.Name, At: before})
+	}
+	if keys := m.knownKeys(KeyExecutor.Name, time.Now()); len(keys) != 2*scanChunk+11 {
+		t.Fatalf("expect %d keys, get %d", 2*scanChunk+11, len(keys))
+	}
+}
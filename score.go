@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// ScoreParam is the distribution of the sorted set scores, see
+// RandomGen.Score.
+type ScoreParam struct {
+	// Dist is uniform (floats from Min to Max), time (unix timestamps in ms
+	// within Span before now) or zipf (integers from Min to Max, Min the most
+	// frequent, with exponent Skew > 1)
+	Dist string
+	Min  float64
+	Max  float64
+	Span time.Duration
+	Skew float64
+}
+
+// Default ...
+func (s *ScoreParam) Default() *ScoreParam {
+	if s.Dist == "" {
+		s.Dist = "uniform"
+	}
+	if s.Max == 0 {
+		s.Max = 1000000
+	}
+	if s.Span == 0 {
+		s.Span = 24 * time.Hour
+	}
+	if s.Skew == 0 {
+		s.Skew = 1.1
+	}
+	return s
+}
+
+// Check ...
+func (s *ScoreParam) Check() error {
+	switch s.Dist {
+	case "uniform", "time", "zipf":
+	default:
+		return fmt.Errorf("unknown distribution %s, uniform, time or zipf", s.Dist)
+	}
+	if s.Max < s.Min {
+		return fmt.Errorf("expect Min <= Max, get %v %v", s.Min, s.Max)
+	}
+	if s.Dist == "zipf" && (s.Skew <= 1 || s.Max-s.Min < 1) {
+		return fmt.Errorf("zipf needs Skew > 1 and Max - Min >= 1, get %v %v", s.Skew, s.Max-s.Min)
+	}
+	if s.Span < time.Millisecond {
+		return fmt.Errorf("expect Span >= 1ms, get %v", s.Span)
+	}
+	return nil
+}
+
+// newZipf returns the zipf source of the worker drawing from r, nil unless
+// the scores are zipfian.
+func (rg *RandomGen) newZipf(r *rand.Rand) *rand.Zipf {
+	s := rg.Param.Score
+	if s.Dist != "zipf" {
+		return nil
+	}
+	return rand.NewZipf(r, s.Skew, 1, uint64(s.Max-s.Min))
+}
+
+// Score draws a score of the score distribution.
+func (rg *RandomGen) Score(id int) float64 {
+	s := rg.Param.Score
+	switch s.Dist {
+	case "time":
+		return float64(time.Now().Add(-time.Duration(rg.Rand[id].Int63n(int64(s.Span)))).UnixNano() / 1e6)
+	case "zipf":
+		return s.Min + float64(rg.zipf[id].Uint64())
+	}
+	return s.Min + rg.Rand[id].Float64()*(s.Max-s.Min)
+}
+
+// formatScore formats a score the shortest way that parses back to it.
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
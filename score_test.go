@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestScoreDist(t *testing.T) {
+	now := float64(time.Now().UnixNano() / 1e6)
+	cases := []struct {
+		score    ScoreParam
+		min, max float64
+		integer  bool
+	}{
+		{ScoreParam{Dist: "uniform", Min: -5, Max: 5}, -5, 5, false},
+		{ScoreParam{Dist: "time", Span: time.Hour}, now - 3600000, now + 60000, true},
+		{ScoreParam{Dist: "zipf", Min: 10, Max: 1000, Skew: 2}, 10, 1000, true},
+	}
+	for _, c := range cases {
+		rg := &RandomGen{
+			Param: (&Param{Score: &c.score}).Default(),
+			Num:   2,
+			Seed:  []uint8("abcdefghijklmnopqrstuvwxyz"),
+		}
+		if err := rg.Param.Compile(); err != nil {
+			t.Fatal(err)
+		}
+		rg.Init()
+		low := 0
+		for i := 0; i < 1000; i++ {
+			score := rg.Score(i % 2)
+			if score < c.min || score > c.max {
+				t.Fatalf("%s: %v out of [%v, %v]", c.score.Dist, score, c.min, c.max)
+			}
+			if c.integer && score != math.Trunc(score) {
+				t.Fatalf("%s: expect an integer, get %v", c.score.Dist, score)
+			}
+			if score < c.min+10 {
+				low++
+			}
+		}
+		// the zipf scores gather at Min
+		if c.score.Dist == "zipf" && low < 900 {
+			t.Fatalf("zipf: expect most scores near %v, get %d of 1000", c.min, low)
+		}
+	}
+}
+
+func TestScoreCheck(t *testing.T) {
+	for _, s := range []ScoreParam{
+		{Dist: "gauss"},
+		{Dist: "uniform", Min: 2, Max: 1},
+		{Dist: "zipf", Skew: 1},
+		{Dist: "zipf", Min: 1, Max: 1.5},
+	} {
+		if err := (&s).Default().Check(); err == nil {
+			t.Fatalf("%+v: expect an error", s)
+		}
+	}
+	if formatScore(1.5) != "1.5" || formatScore(1700000000000) != "1700000000000" {
+		t.Fatalf("expect the shortest decimal, get %s %s", formatScore(1.5), formatScore(1700000000000))
+	}
+}
+
+func TestCheckOrder(t *testing.T) {
+	entries, err := parseScores([]interface{}{
+		[]byte("a"), []byte("1"), []byte("b"), []byte("1"), []byte("a"), []byte("2.5"),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkOrder(entries, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkOrder(entries, true); err == nil {
+		t.Fatal("expect descending order to fail")
+	}
+	rev := []scoreEntry{entries[2], entries[1], entries[0]}
+	if err := checkOrder(rev, true); err != nil {
+		t.Fatal(err)
+	}
+	// equal scores are ordered by member
+	if err := checkOrder([]scoreEntry{entries[1], entries[0]}, false); err == nil {
+		t.Fatal("expect b before a to fail")
+	}
+	if _, err := parseScores([]interface{}{[]byte("a")}, nil); err == nil {
+		t.Fatal("expect an odd reply to fail")
+	}
+}
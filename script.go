@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ScriptExecutor runs the Scripts of the param file with EVALSHA and the
+// Functions with FCALL, its scenarios are added by BuildScripts.
+var ScriptExecutor = &RandomExecutor{Name: "script"}
+
+func init() {
+	Executors = append(Executors, ScriptExecutor)
+}
+
+// ScriptParam is a Lua script of the script workload or, in Functions, a
+// function of a library.
+type ScriptParam struct {
+	// Name is the scenario and, for a function, the function called
+	Name   string
+	Weight int64
+	// Source is the script or the library, File a file to read it from. A
+	// library is loaded with FUNCTION LOAD REPLACE, without Source the
+	// function must be loaded already
+	Source string `yaml:",omitempty"`
+	File   string `yaml:",omitempty"`
+	// Keys and Args are the generators of KEYS and ARGV, see scriptGens,
+	// "=literal" passes literal
+	Keys []string
+	Args []string
+}
+
+// scriptGens are the generators of the keys and arguments of scripts, the
+// keys stay in the partition of the worker.
+var scriptGens = map[string]func(id int) interface{}{
+	"key":            func(id int) interface{} { return RGen.Key(id) },
+	"hash":           func(id int) interface{} { return RGen.Hash(id) },
+	"set":            func(id int) interface{} { return RGen.Set(id) },
+	"sortedset":      func(id int) interface{} { return RGen.SortedSet(id) },
+	"list":           func(id int) interface{} { return RGen.List(id) },
+	"stream":         func(id int) interface{} { return RGen.Stream(id) },
+	"hot":            func(id int) interface{} { return RGen.Hot(id) },
+	"value":          func(id int) interface{} { return RGen.Value(id) },
+	"hashfield":      func(id int) interface{} { return RGen.HashField(id) },
+	"setfield":       func(id int) interface{} { return RGen.SetField(id) },
+	"sortedsetfield": func(id int) interface{} { return RGen.SortedSetField(id) },
+	"score":          func(id int) interface{} { return RGen.Score(id) },
+}
+
+// defaultScripts run when the param file has neither Scripts nor Functions.
+func defaultScripts() []*ScriptParam {
+	return []*ScriptParam{
+		{
+			Name:   "set_get",
+			Weight: 2,
+			Source: "redis.call('SET', KEYS[1], ARGV[1]) return redis.call('GET', KEYS[1])",
+			Keys:   []string{"key"},
+			Args:   []string{"value"},
+		},
+		{
+			Name:   "zadd_zrange",
+			Weight: 1,
+			Source: "redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2]) return redis.call('ZRANGE', KEYS[1], 0, 9)",
+			Keys:   []string{"sortedset"},
+			Args:   []string{"score", "sortedsetfield"},
+		},
+	}
+}
+
+// Check reads File and checks the generators.
+func (s *ScriptParam) Check() error {
+	if s.Name == "" {
+		return fmt.Errorf("script without name")
+	}
+	if s.Weight == 0 {
+		s.Weight = 1
+	}
+	if s.Weight < 0 {
+		return fmt.Errorf("%s: negative weight %d", s.Name, s.Weight)
+	}
+	if s.File != "" {
+		content, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.Name, err)
+		}
+		s.Source, s.File = string(content), ""
+	}
+	for _, gen := range append(append([]string{}, s.Keys...), s.Args...) {
+		if _, ok := scriptGens[gen]; !ok && !strings.HasPrefix(gen, "=") {
+			return fmt.Errorf("%s: unknown generator %s", s.Name, gen)
+		}
+	}
+	return nil
+}
+
+// generate returns the keys and arguments of one call.
+func (s *ScriptParam) generate(id int) (keysAndArgs []interface{}) {
+	for _, gens := range [][]string{s.Keys, s.Args} {
+		for _, gen := range gens {
+			keysAndArgs = append(keysAndArgs, generate(gen, id))
+		}
+	}
+	return keysAndArgs
+}
+
+// generate returns a value of the generator gen or the literal "=literal".
+func generate(gen string, id int) interface{} {
+	if strings.HasPrefix(gen, "=") {
+		return gen[1:]
+	}
+	return scriptGens[gen](id)
+}
+
+// scriptOpstr formats a script call, the source is left out.
+func scriptOpstr(cmd, name string, keysAndArgs []interface{}) string {
+	var b strings.Builder
+	b.WriteString(cmd)
+	b.WriteByte(' ')
+	b.WriteString(name)
+	for _, a := range keysAndArgs {
+		fmt.Fprintf(&b, " %v", a)
+	}
+	return b.String()
+}
+
+// BuildScripts adds a scenario to ScriptExecutor for every script and
+// function of param.
+func BuildScripts(param *Param) {
+	for _, s := range param.Scripts {
+		ScriptExecutor.Add(s.Name, s.Weight, scriptScenario(s))
+	}
+	for _, f := range param.Functions {
+		ScriptExecutor.Add(f.Name, f.Weight, functionScenario(f))
+	}
+}
+
+// scriptScenario runs s with EVALSHA. A worker sends EVAL first, which
+// loads the script, and again after a NOSCRIPT reply: the redis.Script
+// fallback, one call later since the replies are read in the background.
+func scriptScenario(s *ScriptParam) func(conn redis.Conn, id int) (rs []*Request) {
+	script := redis.NewScript(len(s.Keys), s.Source)
+	var loaded sync.Map
+	return func(conn redis.Conn, id int) (rs []*Request) {
+		keysAndArgs := s.generate(id)
+		cmd := "EVALSHA"
+		if _, ok := loaded.Load(id); ok {
+			script.SendHash(conn, keysAndArgs...)
+		} else {
+			cmd = "EVAL"
+			script.Send(conn, keysAndArgs...)
+			loaded.Store(id, true)
+		}
+		rs = append(rs, &Request{
+			Opstr: scriptOpstr(cmd, s.Name, keysAndArgs),
+			valid: func(reply interface{}, err error) error {
+				if e, ok := err.(redis.Error); ok && strings.HasPrefix(string(e), "NOSCRIPT ") {
+					loaded.Delete(id)
+				}
+				return err
+			},
+		})
+		conn.Flush()
+
+		return rs
+	}
+}
+
+// LoadFunctions loads the libraries of the functions with a Source on addr
+// with FUNCTION LOAD REPLACE, once before the run.
+func LoadFunctions(addr string) error {
+	if !Conf.Script {
+		return nil
+	}
+	var conn redis.Conn
+	for _, f := range RGen.Param.Functions {
+		if f.Source == "" {
+			continue
+		}
+		if conn == nil {
+			var err error
+			conn, err = redis.Dial("tcp", addr, redis.DialConnectTimeout(time.Second),
+				redis.DialReadTimeout(10*time.Second), redis.DialWriteTimeout(time.Second))
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+		}
+		if _, err := conn.Do("FUNCTION", "LOAD", "REPLACE", f.Source); err != nil {
+			return fmt.Errorf("FUNCTION LOAD %s on %s: %v", f.Name, addr, err)
+		}
+	}
+	return nil
+}
+
+// functionScenario calls f with FCALL. The library is loaded before the run,
+// with a Source a worker loads it again after a "Function not found" reply
+// (FUNCTION FLUSH, a failover).
+func functionScenario(f *ScriptParam) func(conn redis.Conn, id int) (rs []*Request) {
+	var missing sync.Map
+	return func(conn redis.Conn, id int) (rs []*Request) {
+		if _, ok := missing.Load(id); ok && f.Source != "" {
+			conn.Send("FUNCTION", "LOAD", "REPLACE", f.Source)
+			rs = append(rs, &Request{
+				Opstr: fmt.Sprintf("FUNCTION LOAD REPLACE %s", f.Name),
+				valid: func(reply interface{}, err error) error {
+					_, err = redis.String(reply, err)
+					return err
+				},
+			})
+			missing.Delete(id)
+		}
+
+		keysAndArgs := f.generate(id)
+		args := append([]interface{}{f.Name, len(f.Keys)}, keysAndArgs...)
+		conn.Send("FCALL", args...)
+		rs = append(rs, &Request{
+			Opstr: scriptOpstr("FCALL", f.Name, append([]interface{}{len(f.Keys)}, keysAndArgs...)),
+			valid: func(reply interface{}, err error) error {
+				if e, ok := err.(redis.Error); ok && strings.Contains(string(e), "Function not found") {
+					missing.Store(id, true)
+				}
+				return err
+			},
+		})
+		conn.Flush()
+
+		return rs
+	}
+}
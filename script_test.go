@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestScriptParam(t *testing.T) {
+	f, err := ioutil.TempFile("", "script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("return redis.call('GET', KEYS[1])")
+	f.Close()
+
+	s := &ScriptParam{Name: "get", File: f.Name(), Keys: []string{"key"}, Args: []string{"=10", "score"}}
+	if err := s.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Weight != 1 || s.File != "" || !strings.HasPrefix(s.Source, "return") {
+		t.Fatalf("unexpected %+v", s)
+	}
+
+	defer func(rg *RandomGen) { RGen = rg }(RGen)
+	RGen = newTestGen()
+	keysAndArgs := s.generate(0)
+	if len(keysAndArgs) != 3 || !strings.HasPrefix(keysAndArgs[0].(string), "key_") || keysAndArgs[1] != "10" {
+		t.Fatalf("unexpected %v", keysAndArgs)
+	}
+	if op := scriptOpstr("EVALSHA", "get", keysAndArgs[1:2]); op != "EVALSHA get 10" {
+		t.Fatalf("unexpected %s", op)
+	}
+
+	bad := &ScriptParam{Name: "bad", Keys: []string{"kye"}}
+	if err := bad.Check(); err == nil {
+		t.Fatal("expect error on an unknown generator")
+	}
+}
+
+// sendConn records the commands sent.
+type sendConn struct {
+	redis.Conn
+	cmds []string
+}
+
+func (c *sendConn) Send(cmd string, args ...interface{}) error {
+	c.cmds = append(c.cmds, cmd)
+	return nil
+}
+
+func (c *sendConn) Flush() error { return nil }
+
+func TestFunctionReload(t *testing.T) {
+	defer func(rg *RandomGen) { RGen = rg }(RGen)
+	RGen = newTestGen()
+	f := &ScriptParam{Name: "get", Source: "#!lua name=lib", Keys: []string{"key"}}
+	scenario := functionScenario(f)
+	conn := &sendConn{}
+
+	rs := scenario(conn, 0)
+	if len(rs) != 1 || strings.Join(conn.cmds, " ") != "FCALL" {
+		t.Fatalf("expect FCALL alone, the library is loaded before the run, get %v", conn.cmds)
+	}
+	rs[0].valid(nil, redis.Error("ERR Function not found"))
+	conn.cmds = nil
+	scenario(conn, 1)
+	scenario(conn, 0)
+	scenario(conn, 0)
+	if got := strings.Join(conn.cmds, " "); got != "FCALL FUNCTION FCALL FCALL" {
+		t.Fatalf("expect worker 0 to reload once, get %s", got)
+	}
+}
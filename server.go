@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ServerInfo is one INFO snapshot of the target.
+type ServerInfo struct {
+	Time   time.Time
+	Fields map[string]string
+	// Cmds are the cumulative calls and usec of the commandstats section
+	Cmds map[string][2]int64
+}
+
+// ParseInfo parses the reply of INFO.
+func ParseInfo(text string) *ServerInfo {
+	info := &ServerInfo{Time: time.Now(), Fields: map[string]string{}, Cmds: map[string][2]int64{}}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		key, value := line[:colon], line[colon+1:]
+		if strings.HasPrefix(key, "cmdstat_") {
+			var calls, usec int64
+			for _, kv := range strings.Split(value, ",") {
+				if strings.HasPrefix(kv, "calls=") {
+					calls, _ = strconv.ParseInt(kv[6:], 10, 64)
+				} else if strings.HasPrefix(kv, "usec=") {
+					usec, _ = strconv.ParseInt(kv[5:], 10, 64)
+				}
+			}
+			info.Cmds[strings.ToUpper(key[8:])] = [2]int64{calls, usec}
+			continue
+		}
+		info.Fields[key] = value
+	}
+	return info
+}
+
+// Int ...
+func (info *ServerInfo) Int(key string) int64 {
+	v, _ := strconv.ParseInt(info.Fields[key], 10, 64)
+	return v
+}
+
+// Float ...
+func (info *ServerInfo) Float(key string) float64 {
+	v, _ := strconv.ParseFloat(info.Fields[key], 64)
+	return v
+}
+
+// ServerStats is what the server did during one interval.
+type ServerStats struct {
+	// OPS is the rate of total_commands_processed
+	OPS int64
+	// CPU is used_cpu_sys + used_cpu_user in percent of one core
+	CPU        int64
+	UsedMemory int64
+	Clients    int64
+	Evicted    int64
+	Expired    int64
+	// ExpireCycle is the cpu time of the active expire cycles in ms, Capped
+	// the cycles which hit their time limit, since redis 6.0
+	ExpireCycle int64
+	Capped      int64
+	// Persisting is set while a bgsave or an aof rewrite runs
+	Persisting bool
+	// CmdUsec is the mean server side usec per call of every command
+	CmdUsec map[string]int64
+}
+
+// DiffInfo computes the stats of the interval between prev and cur.
+func DiffInfo(prev, cur *ServerInfo) *ServerStats {
+	s := &ServerStats{
+		UsedMemory:  cur.Int("used_memory"),
+		Clients:     cur.Int("connected_clients"),
+		Evicted:     cur.Int("evicted_keys") - prev.Int("evicted_keys"),
+		Expired:     cur.Int("expired_keys") - prev.Int("expired_keys"),
+		ExpireCycle: cur.Int("expire_cycle_cpu_milliseconds") - prev.Int("expire_cycle_cpu_milliseconds"),
+		Capped:      cur.Int("expired_time_cap_reached_count") - prev.Int("expired_time_cap_reached_count"),
+		Persisting:  cur.Int("rdb_bgsave_in_progress") == 1 || cur.Int("aof_rewrite_in_progress") == 1,
+		CmdUsec:     map[string]int64{},
+	}
+	if dt := cur.Time.Sub(prev.Time).Seconds(); dt > 0 {
+		ops := cur.Int("total_commands_processed") - prev.Int("total_commands_processed")
+		s.OPS = int64(float64(ops) / dt)
+		cpu := cur.Float("used_cpu_sys") + cur.Float("used_cpu_user") - prev.Float("used_cpu_sys") - prev.Float("used_cpu_user")
+		s.CPU = int64(100 * cpu / dt)
+	}
+	for name, c := range cur.Cmds {
+		p := prev.Cmds[name]
+		if calls := c[0] - p[0]; calls > 0 {
+			s.CmdUsec[name] = (c[1] - p[1]) / calls
+		}
+	}
+	return s
+}
+
+// String ...
+func (s *ServerStats) String() string {
+	extra := ""
+	if s.ExpireCycle > 0 || s.Capped > 0 {
+		extra += fmt.Sprintf("\texpire cycle %dms\tcapped %d", s.ExpireCycle, s.Capped)
+	}
+	if s.Persisting {
+		extra += "\tpersisting"
+	}
+	return fmt.Sprintf("server ops %d\tcpu %d%%\tmem %dMB\tclients %d\tevicted %d\texpired %d%s",
+		s.OPS, s.CPU, s.UsedMemory>>20, s.Clients, s.Evicted, s.Expired, extra)
+}
+
+// InfoPoller polls INFO on its own connection, Attach hands the difference
+// since the previous Attach to each result. It polls four times per
+// interval so every result finds a fresh snapshot, rates are computed from
+// the snapshot times.
+type InfoPoller struct {
+	addr     string
+	mu       sync.Mutex
+	latest   *ServerInfo
+	attached *ServerInfo
+}
+
+// NewInfoPoller starts polling addr every interval, nil when -info is off.
+func NewInfoPoller(addr string, interval time.Duration) *InfoPoller {
+	if !Conf.Info {
+		return nil
+	}
+	p := &InfoPoller{addr: addr}
+	go p.loop(interval / 4)
+	return p
+}
+
+func (p *InfoPoller) loop(period time.Duration) {
+	var conn redis.Conn
+	t := time.NewTicker(period)
+	defer t.Stop()
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		if conn == nil || conn.Err() != nil {
+			var err error
+			conn, err = redis.Dial("tcp", p.addr, redis.DialConnectTimeout(time.Second),
+				redis.DialReadTimeout(time.Second), redis.DialWriteTimeout(time.Second))
+			if err != nil {
+				conn = nil
+			}
+		}
+		if conn != nil {
+			text, err := redis.String(conn.Do("INFO", "all"))
+			if e, ok := err.(redis.Error); ok && strings.HasPrefix(string(e), "ERR unknown command") {
+				// proxies without INFO
+				log.Println("INFO not supported by", p.addr, err)
+				return
+			}
+			if err == nil {
+				info := ParseInfo(text)
+				p.mu.Lock()
+				p.latest = info
+				p.mu.Unlock()
+			} else if Conf.Debug {
+				// LOADING while a replica is promoted, polled again
+				log.Println("INFO", p.addr, err)
+			}
+		}
+		select {
+		case <-t.C:
+		case <-Stop:
+			return
+		}
+	}
+}
+
+// Attach sets r.Server to the activity since the previous call, a nil
+// poller attaches nothing.
+func (p *InfoPoller) Attach(r *Result) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.latest == nil || p.latest == p.attached {
+		return
+	}
+	if p.attached != nil {
+		r.Server = DiffInfo(p.attached, p.latest)
+	}
+	p.attached = p.latest
+}
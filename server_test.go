@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDiffInfo(t *testing.T) {
+	prev := ParseInfo("# Stats\r\ntotal_commands_processed:1000\r\nevicted_keys:5\r\n" +
+		"# CPU\r\nused_cpu_sys:1.50\r\nused_cpu_user:2.00\r\n" +
+		"# Commandstats\r\ncmdstat_get:calls=100,usec=300,usec_per_call=3.00\r\n")
+	cur := ParseInfo("# Stats\r\ntotal_commands_processed:3000\r\nevicted_keys:7\r\n" +
+		"# Memory\r\nused_memory:1048576\r\n# Clients\r\nconnected_clients:12\r\n" +
+		"# Persistence\r\nrdb_bgsave_in_progress:1\r\n" +
+		"# CPU\r\nused_cpu_sys:2.00\r\nused_cpu_user:2.50\r\n" +
+		"# Commandstats\r\ncmdstat_get:calls=300,usec=1300,usec_per_call=4.33\r\n")
+	cur.Time = prev.Time.Add(2 * time.Second)
+
+	s := DiffInfo(prev, cur)
+	want := ServerStats{OPS: 1000, CPU: 50, UsedMemory: 1 << 20, Clients: 12, Evicted: 2, Persisting: true}
+	if s.OPS != want.OPS || s.CPU != want.CPU || s.UsedMemory != want.UsedMemory ||
+		s.Clients != want.Clients || s.Evicted != want.Evicted || s.Persisting != want.Persisting {
+		t.Fatalf("expect %+v, get %+v", want, s)
+	}
+	if s.CmdUsec["GET"] != 5 {
+		t.Fatalf("expect GET 5 usec per call, get %d", s.CmdUsec["GET"])
+	}
+}
+
+// respServer answers the commands of one connection with replies in order,
+// the last one repeated.
+func respServer(t *testing.T, replies ...string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1024)
+		for i := 0; ; i++ {
+			if _, err := conn.Read(buf); err != nil {
+				return
+			}
+			if i >= len(replies) {
+				i = len(replies) - 1
+			}
+			conn.Write([]byte(replies[i]))
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestInfoPollerLoading(t *testing.T) {
+	Conf.Info = true
+	defer func() { Conf.Info = false }()
+	info := "# Stats\r\ntotal_commands_processed:1000\r\n"
+	addr := respServer(t, "-LOADING Redis is loading the dataset in memory\r\n",
+		fmt.Sprintf("$%d\r\n%s\r\n", len(info), info))
+	p := NewInfoPoller(addr, 40*time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for {
+		p.mu.Lock()
+		latest := p.latest
+		p.mu.Unlock()
+		if latest != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expect INFO polled again after LOADING")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// slowlogBatch is the number of entries read per SLOWLOG GET, the default
+// slowlog-max-len.
+const slowlogBatch = 128
+
+// clients are the local addresses of the open connections to the targets
+// while -slowlog is on, the client field of SLOWLOG tells our commands apart.
+var clients sync.Map
+
+// clientGrace is how long the address of a closed connection is kept, the
+// collector reads and marks the entries of its last commands within a
+// second, see SlowlogCollector.read.
+const clientGrace = 3 * time.Second
+
+// trackDial connects like redis.Dial and remembers the local address until
+// the connection is closed.
+func trackDial(network, addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, addr, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	local := conn.LocalAddr().String()
+	clients.Store(local, true)
+	return &trackedConn{Conn: conn, local: local}, nil
+}
+
+// trackedConn forgets its local address clientGrace after Close.
+type trackedConn struct {
+	net.Conn
+	local string
+	once  sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		time.AfterFunc(clientGrace, func() { clients.Delete(c.local) })
+	})
+	return c.Conn.Close()
+}
+
+// SlowEntry is a SLOWLOG entry or a LATENCY sample of the target.
+type SlowEntry struct {
+	Time time.Time
+	// Usec is the execution time, LATENCY samples have ms precision
+	Usec int64
+	// Event is the LATENCY event, empty for SLOWLOG entries
+	Event  string   `yaml:",omitempty"`
+	ID     int64    `yaml:",omitempty"`
+	Args   []string `yaml:",omitempty"`
+	Client string   `yaml:",omitempty"`
+	// Ours is set on the commands sent by redis-perf
+	Ours bool `yaml:",omitempty"`
+}
+
+// String ...
+func (e *SlowEntry) String() string {
+	at := e.Time.Format("15:04:05")
+	if e.Event != "" {
+		return fmt.Sprintf("%s latency %s %dms", at, e.Event, e.Usec/1000)
+	}
+	mark := ""
+	if e.Ours {
+		mark = "\tours"
+	}
+	return fmt.Sprintf("%s slowlog %dus\t%s\t%s%s", at, e.Usec, slowArgs(e.Args), e.Client, mark)
+}
+
+// slowArgs renders the arguments of an entry for the logs, binary values
+// are quoted and long ones cut.
+func slowArgs(args []string) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		if len(arg) > 64 {
+			arg = arg[:64] + "..."
+		}
+		parts[i] = strconv.Quote(arg)
+		if parts[i][1:len(parts[i])-1] == arg && !strings.ContainsAny(arg, " ") {
+			parts[i] = arg
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseSlowlog parses the reply of SLOWLOG GET, newest entry first. The
+// client fields only exist since redis 4.0.
+func ParseSlowlog(reply interface{}) ([]*SlowEntry, error) {
+	list, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*SlowEntry, 0, len(list))
+	for _, item := range list {
+		fields, err := redis.Values(item, nil)
+		if err != nil || len(fields) < 4 {
+			return nil, fmt.Errorf("unexpected slowlog entry %v", item)
+		}
+		e := &SlowEntry{}
+		e.ID, _ = redis.Int64(fields[0], nil)
+		ts, _ := redis.Int64(fields[1], nil)
+		e.Time = time.Unix(ts, 0)
+		e.Usec, _ = redis.Int64(fields[2], nil)
+		e.Args, _ = redis.Strings(fields[3], nil)
+		if len(fields) > 4 {
+			e.Client, _ = redis.String(fields[4], nil)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// SlowlogCollector resets SLOWLOG of the target at start and reads the new
+// entries every second on its own connection, so few are rotated out by
+// slowlog-max-len. The LATENCY samples are read at the end.
+type SlowlogCollector struct {
+	addr    string
+	start   time.Time
+	mu      sync.Mutex
+	conn    redis.Conn
+	entries []*SlowEntry
+	lastID  int64
+	// Dropped counts the entries rotated out between two reads
+	Dropped int64
+}
+
+// NewSlowlogCollector starts collecting, nil when -slowlog is off or the
+// target has no SLOWLOG.
+func NewSlowlogCollector(addr string) *SlowlogCollector {
+	if !Conf.Slowlog {
+		return nil
+	}
+	c := &SlowlogCollector{addr: addr, start: time.Now(), lastID: -1}
+	if _, err := c.do("SLOWLOG", "RESET"); err != nil {
+		log.Println("SLOWLOG not supported by", addr, err)
+		return nil
+	}
+	if Conf.LatencyMonitor > 0 {
+		c.enableLatency()
+	}
+	go c.loop()
+	return c
+}
+
+// do sends one command, reconnecting first when needed. The caller holds mu
+// once the collector runs.
+func (c *SlowlogCollector) do(cmd string, args ...interface{}) (interface{}, error) {
+	if c.conn == nil || c.conn.Err() != nil {
+		conn, err := redis.Dial("tcp", c.addr, redis.DialConnectTimeout(time.Second),
+			redis.DialReadTimeout(time.Second), redis.DialWriteTimeout(time.Second))
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+	}
+	return c.conn.Do(cmd, args...)
+}
+
+// enableLatency sets latency-monitor-threshold, the previous value is
+// restored at exit.
+func (c *SlowlogCollector) enableLatency() {
+	prev, err := redis.Strings(c.do("CONFIG", "GET", "latency-monitor-threshold"))
+	if err != nil || len(prev) != 2 {
+		log.Println("LATENCY monitoring not supported by", c.addr, err)
+		return
+	}
+	if _, err := c.do("CONFIG", "SET", "latency-monitor-threshold", Conf.LatencyMonitor); err != nil {
+		log.Println("enable LATENCY monitoring of", c.addr, err)
+		return
+	}
+	c.do("LATENCY", "RESET")
+	AtExit(func() {
+		conn, err := redis.Dial("tcp", c.addr, redis.DialConnectTimeout(time.Second),
+			redis.DialReadTimeout(time.Second), redis.DialWriteTimeout(time.Second))
+		if err != nil {
+			log.Println("restore latency-monitor-threshold of", c.addr, err)
+			return
+		}
+		defer conn.Close()
+		if _, err := conn.Do("CONFIG", "SET", "latency-monitor-threshold", prev[1]); err != nil {
+			log.Println("restore latency-monitor-threshold of", c.addr, err)
+		}
+	})
+}
+
+func (c *SlowlogCollector) loop() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-Stop:
+			return
+		}
+		c.mu.Lock()
+		if err := c.read(); err != nil && Conf.Debug {
+			log.Println("slowlog", err)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// read appends the entries logged since the previous read.
+func (c *SlowlogCollector) read() error {
+	reply, err := c.do("SLOWLOG", "GET", slowlogBatch)
+	if err != nil {
+		return err
+	}
+	entries, err := ParseSlowlog(reply)
+	if err != nil {
+		return err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.ID <= c.lastID {
+			continue
+		}
+		if c.lastID >= 0 && e.ID > c.lastID+1 {
+			c.Dropped += e.ID - c.lastID - 1
+		}
+		c.lastID = e.ID
+		if e.Client != "" {
+			_, e.Ours = clients.Load(e.Client)
+		}
+		c.entries = append(c.entries, e)
+	}
+	return nil
+}
+
+// latency returns the LATENCY HISTORY samples of every event since start.
+func (c *SlowlogCollector) latency() []*SlowEntry {
+	latest, err := redis.Values(c.do("LATENCY", "LATEST"))
+	if err != nil {
+		log.Println("LATENCY LATEST", err)
+		return nil
+	}
+	var samples []*SlowEntry
+	for _, item := range latest {
+		fields, err := redis.Values(item, nil)
+		if err != nil || len(fields) == 0 {
+			continue
+		}
+		event, _ := redis.String(fields[0], nil)
+		history, err := redis.Values(c.do("LATENCY", "HISTORY", event))
+		if err != nil {
+			continue
+		}
+		for _, h := range history {
+			sample, err := redis.Values(h, nil)
+			if err != nil || len(sample) != 2 {
+				continue
+			}
+			ts, _ := redis.Int64(sample[0], nil)
+			ms, _ := redis.Int64(sample[1], nil)
+			if ts < c.start.Unix() {
+				continue
+			}
+			samples = append(samples, &SlowEntry{Time: time.Unix(ts, 0), Usec: ms * 1000, Event: event})
+		}
+	}
+	return samples
+}
+
+// Collect reads the last entries and returns the entries of the run sorted
+// by time, the LATENCY samples included. The entries logging the client are
+// marked when read, cmds are the commands sent, they mark the entries of
+// servers which do not log the client.
+func (c *SlowlogCollector) Collect(cmds map[string]*CmdStatus) []*SlowEntry {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.read(); err != nil {
+		log.Println("slowlog", err)
+	}
+	entries := append([]*SlowEntry(nil), c.entries...)
+	if Conf.LatencyMonitor > 0 {
+		entries = append(entries, c.latency()...)
+	}
+	for _, e := range entries {
+		if e.Event == "" && e.Client == "" && len(e.Args) > 0 {
+			_, e.Ours = cmds[strings.ToUpper(e.Args[0])]
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries
+}
+
+// AttachSlow hands every entry to the first interval ending after it, the
+// server logs whole seconds so an entry may land one interval early.
+func AttachSlow(series []*ReportStats, entries []*SlowEntry) {
+	if len(series) == 0 {
+		return
+	}
+	for _, e := range entries {
+		i := sort.Search(len(series), func(i int) bool {
+			return !series[i].Time.Before(e.Time)
+		})
+		if i == len(series) {
+			i--
+		}
+		series[i].Slow = append(series[i].Slow, e)
+	}
+}
+
+// Report attaches the entries to the intervals of summary and formats them
+// next to the latencies the client saw, empty when nothing was slow.
+func (c *SlowlogCollector) Report(summary *Summary) string {
+	entries := c.Collect(summary.Cmds)
+	if len(entries) == 0 {
+		return ""
+	}
+	AttachSlow(summary.Series, entries)
+	var b bytes.Buffer
+	for i, s := range summary.Series {
+		if len(s.Slow) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "interval %d %s\tqps %d\tp99 %dus\tmax %dus\n",
+			i+1, s.Time.Format("15:04:05"), s.QPS, s.P99, s.Max)
+		for _, e := range s.Slow {
+			fmt.Fprintf(&b, "\t%s\n", e)
+		}
+	}
+	if c.Dropped > 0 {
+		fmt.Fprintf(&b, "%d entries rotated out of SLOWLOG, raise slowlog-max-len\n", c.Dropped)
+	}
+	return b.String()
+}
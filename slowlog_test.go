@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSlowlog(t *testing.T) {
+	reply := []interface{}{
+		[]interface{}{int64(7), int64(1700000001), int64(15000),
+			[]interface{}{[]byte("GET"), []byte("key:000001")}, []byte("127.0.0.1:50000"), []byte("")},
+		[]interface{}{int64(6), int64(1700000000), int64(12000),
+			[]interface{}{[]byte("KEYS"), []byte("*")}},
+	}
+	entries, err := ParseSlowlog(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expect 2 entries, get %d", len(entries))
+	}
+	e := entries[0]
+	if e.ID != 7 || e.Usec != 15000 || e.Time.Unix() != 1700000001 || e.Client != "127.0.0.1:50000" ||
+		len(e.Args) != 2 || e.Args[1] != "key:000001" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if entries[1].Client != "" || entries[1].Args[0] != "KEYS" {
+		t.Fatalf("unexpected entry %+v", entries[1])
+	}
+	if _, err := ParseSlowlog([]interface{}{[]interface{}{int64(1)}}); err == nil {
+		t.Fatal("expect error on a short entry")
+	}
+}
+
+func TestAttachSlow(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	series := []*ReportStats{{Time: start.Add(time.Second)}, {Time: start.Add(2 * time.Second)}, {Time: start.Add(3 * time.Second)}}
+	entries := []*SlowEntry{
+		{Time: start},
+		{Time: start.Add(2 * time.Second)},
+		{Time: start.Add(10 * time.Second), Event: "fork"},
+	}
+	AttachSlow(series, entries)
+	if len(series[0].Slow) != 1 || len(series[1].Slow) != 1 || len(series[2].Slow) != 1 {
+		t.Fatalf("expect one entry per interval, get %d %d %d", len(series[0].Slow), len(series[1].Slow), len(series[2].Slow))
+	}
+	if series[2].Slow[0].Event != "fork" {
+		t.Fatal("entries after the run go to the last interval")
+	}
+}
+
+func TestSlowlogOurs(t *testing.T) {
+	entry := func(id int64, client string) interface{} {
+		return []interface{}{id, int64(1700000000), int64(15000),
+			[]interface{}{[]byte("GET"), []byte("key")}, []byte(client), []byte("")}
+	}
+	clients.Store("127.0.0.1:50001", true)
+	conn := &doConn{replies: map[string][]interface{}{
+		"SLOWLOG": {
+			[]interface{}{entry(1, "127.0.0.1:50001")},
+			[]interface{}{entry(2, "127.0.0.1:50002"), entry(1, "127.0.0.1:50001")},
+		},
+	}}
+	c := &SlowlogCollector{conn: conn, lastID: -1}
+	if err := c.read(); err != nil {
+		t.Fatal(err)
+	}
+	// the connection is closed and forgotten before the end of the run
+	clients.Delete("127.0.0.1:50001")
+	entries := c.Collect(nil)
+	if len(entries) != 2 || !entries[0].Ours || entries[1].Ours {
+		t.Fatalf("expect the entry of the closed connection ours and the other not, get %v", entries)
+	}
+}
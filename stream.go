@@ -0,0 +1,541 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// streamGroup is the consumer group of the group workload.
+const streamGroup = "redis-perf"
+
+// streamEntry is one entry of a stream reply.
+type streamEntry struct {
+	ID     string
+	Fields []string
+}
+
+// parseEntries parses the entries of XRANGE, XCLAIM and of one stream of
+// XREAD.
+func parseEntries(reply interface{}) ([]streamEntry, error) {
+	list, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]streamEntry, 0, len(list))
+	for _, item := range list {
+		if item == nil {
+			// claimed entries deleted meanwhile
+			continue
+		}
+		pair, err := redis.Values(item, nil)
+		if err != nil || len(pair) != 2 {
+			return nil, fmt.Errorf("unexpected stream entry %v", item)
+		}
+		id, err := redis.String(pair[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := redis.Strings(pair[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, streamEntry{ID: id, Fields: fields})
+	}
+	return entries, nil
+}
+
+// parseStreams parses the reply of XREAD and XREADGROUP, nil on timeout.
+func parseStreams(reply interface{}) (streams []string, entries [][]streamEntry, err error) {
+	if reply == nil {
+		return nil, nil, nil
+	}
+	list, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, item := range list {
+		pair, err := redis.Values(item, nil)
+		if err != nil || len(pair) != 2 {
+			return nil, nil, fmt.Errorf("unexpected stream %v", item)
+		}
+		name, err := redis.String(pair[0], nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		e, err := parseEntries(pair[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		streams = append(streams, name)
+		entries = append(entries, e)
+	}
+	return streams, entries, nil
+}
+
+// field returns the value of name in the fields of an entry.
+func (e streamEntry) field(name string) string {
+	for i := 0; i+1 < len(e.Fields); i += 2 {
+		if e.Fields[i] == name {
+			return e.Fields[i+1]
+		}
+	}
+	return ""
+}
+
+var (
+	// StreamExecutor appends to and reads the streams of the worker
+	StreamExecutor = &RandomExecutor{Name: "stream"}
+	// GroupExecutor appends timestamped messages to the shared streams,
+	// StreamConsumers read them in a consumer group
+	GroupExecutor = &RandomExecutor{Name: "group"}
+)
+
+func init() {
+	Executors = append(Executors, StreamExecutor, GroupExecutor)
+
+	// XADD and XREVRANGE of the last entry
+	StreamExecutor.Add("xadd_xrevrange", 10, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Stream(id)
+		value := RGen.Value(id)
+		size := RGen.Param.StreamSize
+		var added string
+
+		conn.Send("XADD", key, "MAXLEN", "~", size, "*", "v", value)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("XADD %s MAXLEN ~ %d * v %s", key, size, value),
+			valid: func(reply interface{}, err error) error {
+				added, err = redis.String(reply, err)
+				return err
+			},
+		})
+		conn.Flush()
+
+		conn.Send("XREVRANGE", key, "+", "-", "COUNT", 1)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("XREVRANGE %s + - COUNT 1", key),
+			valid: func(reply interface{}, err error) error {
+				if err != nil {
+					return err
+				}
+				entries, err := parseEntries(reply)
+				if err != nil {
+					return err
+				}
+				if len(entries) != 1 || entries[0].ID != added || entries[0].field("v") != value {
+					return fmt.Errorf("expect entry %s, get %v", added, entries)
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// XADD and XLEN
+	StreamExecutor.Add("xadd_xlen", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Stream(id)
+		value := RGen.Value(id)
+		size := RGen.Param.StreamSize
+
+		conn.Send("XADD", key, "MAXLEN", "~", size, "*", "v", value)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("XADD %s MAXLEN ~ %d * v %s", key, size, value),
+			valid: func(reply interface{}, err error) error {
+				_, err = redis.String(reply, err)
+				return err
+			},
+		})
+		conn.Flush()
+
+		conn.Send("XLEN", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("XLEN %s", key),
+			valid: validPositive,
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// XREAD from the start
+	StreamExecutor.Add("xread", 3, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Stream(id)
+
+		conn.Send("XREAD", "COUNT", 10, "STREAMS", key, "0-0")
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("XREAD COUNT 10 STREAMS %s 0-0", key),
+			valid: func(reply interface{}, err error) error {
+				if err != nil {
+					return err
+				}
+				_, entries, err := parseStreams(reply)
+				if err != nil {
+					return err
+				}
+				if len(entries) > 1 || len(entries) == 1 && len(entries[0]) > 10 {
+					return fmt.Errorf("expect at most 10 entries of one stream, get %v", entries)
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// XLEN and XRANGE of the head
+	StreamExecutor.Add("xlen_xrange", 2, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Stream(id)
+		var length int
+
+		conn.Send("XLEN", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("XLEN %s", key),
+			valid: func(reply interface{}, err error) error {
+				length, err = redis.Int(reply, err)
+				return err
+			},
+		})
+		conn.Flush()
+
+		conn.Send("XRANGE", key, "-", "+", "COUNT", 10)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("XRANGE %s - + COUNT 10", key),
+			valid: func(reply interface{}, err error) error {
+				if err != nil {
+					return err
+				}
+				entries, err := parseEntries(reply)
+				if err != nil {
+					return err
+				}
+				if expect := minInt(length, 10); len(entries) != expect {
+					return fmt.Errorf("expect length %d, get %d", expect, len(entries))
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// XADD of a message stamped with the send time
+	GroupExecutor.Add("xadd", 1, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.GroupStream(id)
+		value := RGen.Value(id)
+		size := RGen.Param.StreamSize
+		ts := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+		conn.Send("XADD", key, "MAXLEN", "~", size, "*", "ts", ts, "v", value)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("XADD %s MAXLEN ~ %d * ts %s v %s", key, size, ts, value),
+			valid: func(reply interface{}, err error) error {
+				_, err = redis.String(reply, err)
+				return err
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// StreamStatus counts the messages consumed during an interval, Hist is the
+// time from XADD to XREADGROUP in us.
+type StreamStatus struct {
+	Num  int64
+	Err  int64
+	Hist *Histogram
+	// Claimed were recovered with XCLAIM, Pending is the length of the
+	// pending entries lists at the end of the interval and Growth its change
+	Claimed int64
+	Pending int64
+	Growth  int64
+}
+
+func newStreamStatus() *StreamStatus {
+	return &StreamStatus{Hist: NewHistogram()}
+}
+
+// Merge adds o into s, Pending is the one of o.
+func (s *StreamStatus) Merge(o *StreamStatus) {
+	if o == nil {
+		return
+	}
+	s.Num += o.Num
+	s.Err += o.Err
+	s.Hist.Merge(o.Hist)
+	s.Claimed += o.Claimed
+	s.Pending = o.Pending
+	s.Growth += o.Growth
+}
+
+// String ...
+func (s *StreamStatus) String() string {
+	return fmt.Sprintf("consumed %d\tstream p50 %dus\tp99 %dus\tpending %d (%+d)\tclaimed %d",
+		s.Num, s.Hist.Percentile(50), s.Hist.Percentile(99), s.Pending, s.Growth, s.Claimed)
+}
+
+// StreamConsumers read the group streams with XREADGROUP and acknowledge
+// with XACK, each consumer serves every Consumers-th stream. A recoverer
+// claims the messages pending for -claim-idle with XPENDING and XCLAIM.
+type StreamConsumers struct {
+	addr    string
+	streams []string
+	acked   int64
+
+	mu       sync.Mutex
+	status   *StreamStatus
+	pending  int64
+	attached int64
+}
+
+// NewStreamConsumers creates the group on the streams of addr and starts
+// -consumers consumers, nil unless the group workload runs.
+func NewStreamConsumers(addr string) *StreamConsumers {
+	if !Conf.Group {
+		return nil
+	}
+	s := &StreamConsumers{addr: addr, status: newStreamStatus()}
+	for n := int64(0); n < RGen.Param.GroupNum; n++ {
+		s.streams = append(s.streams, RGen.Param.GroupTemplate.Render(0, n))
+	}
+	conn, err := s.dial(time.Second)
+	if err != nil {
+		log.Println("stream consumers", err)
+		return nil
+	}
+	for _, stream := range s.streams {
+		_, err := conn.Do("XGROUP", "CREATE", stream, streamGroup, "$", "MKSTREAM")
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			log.Println("XGROUP CREATE", stream, err)
+			conn.Close()
+			return nil
+		}
+	}
+	conn.Close()
+
+	consumers := Conf.Consumers
+	if consumers <= 0 || consumers > len(s.streams) {
+		consumers = len(s.streams)
+	}
+	for i := 0; i < consumers; i++ {
+		var streams []string
+		for j := i; j < len(s.streams); j += consumers {
+			streams = append(streams, s.streams[j])
+		}
+		go s.consume(fmt.Sprintf("consumer-%d", i), streams)
+	}
+	go s.recoverLoop()
+	return s
+}
+
+func (s *StreamConsumers) dial(timeout time.Duration) (redis.Conn, error) {
+	return redis.Dial("tcp", s.addr, redis.DialConnectTimeout(time.Second),
+		redis.DialReadTimeout(timeout), redis.DialWriteTimeout(time.Second))
+}
+
+func (s *StreamConsumers) consume(name string, streams []string) {
+	args := []interface{}{"GROUP", streamGroup, name, "COUNT", 10, "BLOCK", 1000, "STREAMS"}
+	for _, stream := range streams {
+		args = append(args, stream)
+	}
+	for range streams {
+		args = append(args, ">")
+	}
+	var conn redis.Conn
+	b := &backoff{name: "stream " + name + " " + s.addr}
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		select {
+		case <-Stop:
+			return
+		default:
+		}
+		if conn == nil || conn.Err() != nil {
+			var err error
+			if conn, err = s.dial(0); err != nil {
+				conn = nil
+				if !b.retry(err) {
+					return
+				}
+				continue
+			}
+		}
+		reply, err := conn.Do("XREADGROUP", args...)
+		now := time.Now()
+		names, entries, perr := parseStreams(reply)
+		if err == nil {
+			err = perr
+		}
+		if err != nil {
+			s.record(now, nil, err)
+			if !b.retry(err) {
+				return
+			}
+			continue
+		}
+		b.ok()
+		sent := 0
+		for i, stream := range names {
+			s.record(now, entries[i], nil)
+			ack := []interface{}{stream, streamGroup}
+			for _, e := range entries[i] {
+				// leave every -noack-th message pending for the recoverer
+				if Conf.NoAck > 0 && atomic.AddInt64(&s.acked, 1)%Conf.NoAck == 0 {
+					continue
+				}
+				ack = append(ack, e.ID)
+			}
+			if len(ack) > 2 {
+				conn.Send("XACK", ack...)
+				sent++
+			}
+		}
+		conn.Flush()
+		for ; sent > 0; sent-- {
+			if _, err := conn.Receive(); err != nil {
+				s.record(now, nil, err)
+			}
+		}
+	}
+}
+
+// record accounts entries consumed at now.
+func (s *StreamConsumers) record(now time.Time, entries []streamEntry, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.status.Err++
+		if Conf.Debug {
+			log.Println("stream consumer", err)
+		}
+		return
+	}
+	for _, e := range entries {
+		ns, err := strconv.ParseInt(e.field("ts"), 10, 64)
+		if err != nil {
+			s.status.Err++
+			continue
+		}
+		s.status.Num++
+		s.status.Hist.Record((now.UnixNano() - ns) / 1000)
+	}
+}
+
+func (s *StreamConsumers) recoverLoop() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	var conn redis.Conn
+	for {
+		select {
+		case <-t.C:
+		case <-Stop:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if conn == nil || conn.Err() != nil {
+			var err error
+			if conn, err = s.dial(time.Second); err != nil {
+				conn = nil
+				continue
+			}
+		}
+		if err := s.recover(conn); err != nil && Conf.Debug {
+			log.Println("stream recover", err)
+		}
+	}
+}
+
+// recover claims and acknowledges the messages idle for -claim-idle and
+// measures the pending entries lists.
+func (s *StreamConsumers) recover(conn redis.Conn) error {
+	idle := int64(Conf.ClaimIdle / time.Millisecond)
+	var pending, claimed int64
+	for _, stream := range s.streams {
+		reply, err := redis.Values(conn.Do("XPENDING", stream, streamGroup, "-", "+", 100))
+		if err != nil {
+			return err
+		}
+		args := []interface{}{stream, streamGroup, "recoverer", idle}
+		for _, item := range reply {
+			p, err := redis.Values(item, nil)
+			if err != nil || len(p) != 4 {
+				return fmt.Errorf("unexpected pending entry %v", item)
+			}
+			if ms, _ := redis.Int64(p[2], nil); ms >= idle {
+				args = append(args, p[0])
+			}
+		}
+		if len(args) > 4 {
+			reply, err := conn.Do("XCLAIM", args...)
+			if err != nil {
+				return err
+			}
+			entries, err := parseEntries(reply)
+			if err != nil {
+				return err
+			}
+			ack := []interface{}{stream, streamGroup}
+			for _, e := range entries {
+				ack = append(ack, e.ID)
+			}
+			if len(ack) > 2 {
+				if _, err := conn.Do("XACK", ack...); err != nil {
+					return err
+				}
+			}
+			claimed += int64(len(entries))
+		}
+		summary, err := redis.Values(conn.Do("XPENDING", stream, streamGroup))
+		if err != nil {
+			return err
+		}
+		if len(summary) > 0 {
+			n, _ := redis.Int64(summary[0], nil)
+			pending += n
+		}
+	}
+	s.mu.Lock()
+	s.status.Claimed += claimed
+	s.pending = pending
+	s.mu.Unlock()
+	return nil
+}
+
+// Attach sets r.Stream to the messages consumed since the previous call, a
+// nil consumer attaches nothing.
+func (s *StreamConsumers) Attach(r *Result) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Pending = s.pending
+	s.status.Growth = s.pending - s.attached
+	s.attached = s.pending
+	r.Stream = s.status
+	s.status = newStreamStatus()
+}
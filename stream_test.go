@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseStreams(t *testing.T) {
+	entry := func(id string, fields ...interface{}) interface{} {
+		return []interface{}{[]byte(id), fields}
+	}
+	reply := []interface{}{
+		[]interface{}{[]byte("s1"), []interface{}{
+			entry("1-0", []byte("ts"), []byte("1000"), []byte("v"), []byte("a")),
+			entry("2-0", []byte("v"), []byte("b")),
+		}},
+		[]interface{}{[]byte("s2"), []interface{}{nil}},
+	}
+	streams, entries, err := parseStreams(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 2 || streams[0] != "s1" || len(entries[0]) != 2 || len(entries[1]) != 0 {
+		t.Fatalf("unexpected %v %v", streams, entries)
+	}
+	if e := entries[0][0]; e.ID != "1-0" || e.field("ts") != "1000" || e.field("v") != "a" || e.field("x") != "" {
+		t.Fatalf("unexpected entry %v", e)
+	}
+	if streams, _, err := parseStreams(nil); err != nil || streams != nil {
+		t.Fatalf("expect nothing on timeout, get %v %v", streams, err)
+	}
+	if _, err := parseEntries([]interface{}{[]byte("1-0")}); err == nil {
+		t.Fatal("expect error on a malformed entry")
+	}
+}
+
+func TestStreamStatusMerge(t *testing.T) {
+	s := newStreamStatus()
+	for _, pending := range []int64{10, 4} {
+		o := newStreamStatus()
+		o.Num, o.Claimed, o.Pending, o.Growth = 5, 1, pending, pending-s.Pending
+		o.Hist.Record(100)
+		s.Merge(o)
+	}
+	if s.Num != 10 || s.Claimed != 2 || s.Pending != 4 || s.Growth != 4 || s.Hist.Total != 2 {
+		t.Fatalf("unexpected %+v", s)
+	}
+}
+
+func TestGroupPartition(t *testing.T) {
+	rg := newTestGen()
+	seen := map[string]int{}
+	for id := 0; id < int(rg.Num); id++ {
+		for i := 0; i < 1000; i++ {
+			key := rg.Stream(id)
+			if owner, ok := seen[key]; ok && owner != id {
+				t.Fatalf("stream %s used by workers %d and %d", key, owner, id)
+			}
+			seen[key] = id
+		}
+	}
+	rg.Param.GroupTemplate = &KeyTemplate{Format: "g{${worker}}_${n}"}
+	if err := rg.Param.Compile(); err == nil {
+		t.Fatal("expect error on a per worker group template")
+	}
+}
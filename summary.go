@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Summary accumulates the results of a whole run.
+type Summary struct {
+	Start  time.Time
+	Num    int64
+	Err    int64
+	Delay  int64
+	CPU    int64
+	Gen    int64
+	Hist   *Histogram
+	Cmds   map[string]*CmdStatus
+	Cache  map[string]*CacheStatus
+	Queue  *QueueStatus
+	Stream *StreamStatus
+	PubSub *PubSubStatus
+	Scan   *ScanStatus
+	// Intervals is the number of results added, Series their statistics
+	Intervals int64
+	Series    []*ReportStats
+}
+
+// NewSummary ...
+func NewSummary() *Summary {
+	return &Summary{Start: time.Now(), Hist: NewHistogram(), Cmds: map[string]*CmdStatus{}, Cache: map[string]*CacheStatus{}}
+}
+
+// Add ...
+func (s *Summary) Add(r *Result) {
+	s.Num += r.Num
+	s.Err += r.Err
+	s.Delay += r.Delay * r.Num
+	s.CPU += r.CPU
+	s.Gen += r.Gen * r.Num
+	s.Hist.Merge(r.Hist)
+	mergeCmds(s.Cmds, r.Cmds)
+	mergeCache(s.Cache, r.Cache)
+	if r.Queue != nil {
+		if s.Queue == nil {
+			s.Queue = newQueueStatus()
+		}
+		s.Queue.Merge(r.Queue)
+	}
+	if r.Stream != nil {
+		if s.Stream == nil {
+			s.Stream = newStreamStatus()
+		}
+		s.Stream.Merge(r.Stream)
+	}
+	if r.PubSub != nil {
+		if s.PubSub == nil {
+			s.PubSub = newPubSubStatus()
+		}
+		s.PubSub.Merge(r.PubSub)
+	}
+	if r.Scan != nil {
+		if s.Scan == nil {
+			s.Scan = newScanStatus()
+		}
+		s.Scan.Merge(r.Scan)
+	}
+	s.Intervals++
+	stats := NewReportStats(r.QPS, r.Num, r.Err, r.Hist)
+	stats.Time = time.Now()
+	stats.Server = r.Server
+	stats.Cache = r.Cache
+	if r.Queue != nil {
+		stats.Queue = NewReportStats(r.Queue.Num, r.Queue.Num, r.Queue.Err, r.Queue.Hist)
+	}
+	if r.Stream != nil {
+		stats.Stream = NewStreamReport(r.Stream.Num, r.Stream)
+	}
+	if r.PubSub != nil {
+		stats.PubSub = NewPubSubReport(r.PubSub.Num, r.PubSub)
+	}
+	if r.Scan != nil {
+		stats.Scan, stats.Iteration = NewScanReport(r.Scan.Calls, r.Scan)
+	}
+	s.Series = append(s.Series, stats)
+}
+
+// Result returns the whole run as one result, QPS is the mean rate.
+func (s *Summary) Result() *Result {
+	r := &Result{
+		Num:    s.Num,
+		Err:    s.Err,
+		Hist:   s.Hist,
+		Cmds:   s.Cmds,
+		Cache:  s.Cache,
+		Queue:  s.Queue,
+		Stream: s.Stream,
+		PubSub: s.PubSub,
+		Scan:   s.Scan,
+		P50:    s.Hist.Percentile(50),
+		P99:    s.Hist.Percentile(99),
+		P999:   s.Hist.Percentile(99.9),
+	}
+	if elapsed := time.Since(s.Start).Seconds(); elapsed > 0 {
+		r.QPS = int64(float64(s.Num) / elapsed)
+	}
+	if s.Num > 0 {
+		r.Delay = s.Delay / s.Num
+		r.Gen = s.Gen / s.Num
+	}
+	if s.Intervals > 0 {
+		r.CPU = s.CPU / s.Intervals
+	}
+	return r
+}
+
+// ErrRate returns the share of failed requests in percent.
+func (r *Result) ErrRate() float64 {
+	if r.Num == 0 {
+		return 0
+	}
+	return 100 * float64(r.Err) / float64(r.Num)
+}
+
+// CmdReport formats the per command breakdown, one line per command.
+func (r *Result) CmdReport() string {
+	names := make([]string, 0, len(r.Cmds))
+	for name := range r.Cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	for _, name := range names {
+		c := r.Cmds[name]
+		fmt.Fprintf(&b, "%-16s num %d\tmean %dus\tp50 %dus\tp99 %dus\tp999 %dus\terr %d\n",
+			name, c.Num, c.Hist.Mean(), c.Hist.Percentile(50), c.Hist.Percentile(99), c.Hist.Percentile(99.9), c.Err)
+	}
+	return b.String()
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeyTemplate describes how the key names of one data type are built.
+//
+// Format is a literal string with placeholders:
+//
+//	${prefix}  the run prefix (Param.Prefix)
+//	${type}    the data type: key, hash, set, sortedset
+//	${n}       the key number, ${n:12} left pads it with zeros to 12 digits
+//	${tag}     n % Tags, the same key number always maps to the same tag
+//	${group}   n / Group, consecutive key numbers share the same group
+//	${worker}  the id of the worker owning the key
+//	${pad}     filler up to Len, appended at the end when missing
+//
+// Everything else is copied as is, so "{${tag}}" or "{${worker}}" become
+// redis cluster hash tags: keys with the same tag share a slot, Tags spreads
+// the key space across a controlled number of slots.
+type KeyTemplate struct {
+	Format string
+	Len    int
+	Tags   int64
+	Group  int64
+
+	segs []segment
+}
+
+type segKind int
+
+const (
+	segLiteral segKind = iota
+	segN
+	segTag
+	segGroup
+	segWorker
+	segPad
+)
+
+type segment struct {
+	kind  segKind
+	text  string
+	width int
+}
+
+// Compile parses Format, the prefix and type are resolved once here. Format
+// must hold ${n}, else every key number renders the same name.
+func (t *KeyTemplate) Compile(prefix, typ string) error {
+	t.segs = nil
+	if t.Format == "" {
+		return fmt.Errorf("empty template")
+	}
+	rest := t.Format
+	for len(rest) > 0 {
+		i := strings.Index(rest, "${")
+		if i < 0 {
+			t.literal(rest)
+			break
+		}
+		t.literal(rest[:i])
+		j := strings.IndexByte(rest[i:], '}')
+		if j < 0 {
+			return fmt.Errorf("template %q: unclosed placeholder", t.Format)
+		}
+		name, width := rest[i+2:i+j], 0
+		if k := strings.IndexByte(name, ':'); k >= 0 {
+			w, err := strconv.Atoi(name[k+1:])
+			if err != nil || w < 0 || w > 20 {
+				return fmt.Errorf("template %q: bad width in ${%s}", t.Format, name)
+			}
+			name, width = name[:k], w
+		}
+		switch name {
+		case "prefix":
+			t.literal(prefix)
+		case "type":
+			t.literal(typ)
+		case "n":
+			t.segs = append(t.segs, segment{kind: segN, width: width})
+		case "tag":
+			if t.Tags <= 0 {
+				return fmt.Errorf("template %q: ${tag} needs Tags > 0", t.Format)
+			}
+			t.segs = append(t.segs, segment{kind: segTag, width: width})
+		case "group":
+			if t.Group <= 0 {
+				return fmt.Errorf("template %q: ${group} needs Group > 0", t.Format)
+			}
+			t.segs = append(t.segs, segment{kind: segGroup, width: width})
+		case "worker":
+			t.segs = append(t.segs, segment{kind: segWorker, width: width})
+		case "pad":
+			t.segs = append(t.segs, segment{kind: segPad})
+		default:
+			return fmt.Errorf("template %q: unknown placeholder ${%s}", t.Format, name)
+		}
+		rest = rest[i+j+1:]
+	}
+	for _, s := range t.segs {
+		if s.kind == segN {
+			return nil
+		}
+	}
+	return fmt.Errorf("template %q: ${n} is missing", t.Format)
+}
+
+func (t *KeyTemplate) literal(s string) {
+	if s == "" {
+		return
+	}
+	if n := len(t.segs); n > 0 && t.segs[n-1].kind == segLiteral {
+		t.segs[n-1].text += s
+		return
+	}
+	t.segs = append(t.segs, segment{kind: segLiteral, text: s})
+}
+
+// Append renders the key number n of worker id onto b.
+func (t *KeyTemplate) Append(b []byte, id int, n int64) []byte {
+	start, pad := len(b), -1
+	for _, s := range t.segs {
+		switch s.kind {
+		case segLiteral:
+			b = append(b, s.text...)
+		case segN:
+			b = appendPad(b, n, s.width)
+		case segTag:
+			b = appendPad(b, n%t.Tags, s.width)
+		case segGroup:
+			b = appendPad(b, n/t.Group, s.width)
+		case segWorker:
+			b = appendPad(b, int64(id), s.width)
+		case segPad:
+			pad = len(b)
+		}
+	}
+	fill := t.Len - (len(b) - start)
+	if fill <= 0 {
+		return b
+	}
+	if pad < 0 {
+		pad = len(b)
+	}
+	for i := 0; i < fill; i++ {
+		b = append(b, 'x')
+	}
+	copy(b[pad+fill:], b[pad:len(b)-fill])
+	for i := pad; i < pad+fill; i++ {
+		b[i] = 'x'
+	}
+	return b
+}
+
+// PerWorker tells whether the names depend on the worker.
+func (t *KeyTemplate) PerWorker() bool {
+	for _, s := range t.segs {
+		if s.kind == segWorker {
+			return true
+		}
+	}
+	return false
+}
+
+// hashTag returns what the cluster hash tag of the names depends on:
+// segN, segTag, segGroup, segWorker or segLiteral for a constant tag, ok is
+// false without hash tag. Redis hashes the text between the first { and the
+// next }, when it is not empty.
+func (t *KeyTemplate) hashTag() (kind segKind, ok bool) {
+	open := false
+	kind = segLiteral
+	for _, s := range t.segs {
+		if s.kind != segLiteral {
+			switch {
+			case !open || s.kind == segPad:
+			case kind == segLiteral:
+				kind = s.kind
+			case kind != s.kind:
+				// only the key number itself gives the same tag twice
+				kind = segN
+			}
+			continue
+		}
+		text := s.text
+		if !open {
+			i := strings.IndexByte(text, '{')
+			if i < 0 {
+				continue
+			}
+			open, text = true, text[i+1:]
+			if j := strings.IndexByte(text, '}'); j >= 0 {
+				// a constant tag, or {} which is no tag
+				return segLiteral, j > 0
+			}
+			continue
+		}
+		if strings.IndexByte(text, '}') >= 0 {
+			return kind, true
+		}
+	}
+	return segLiteral, false
+}
+
+// Pattern returns the glob matching every name of the template, for SCAN
+// MATCH: the placeholders and the padding become *.
+func (t *KeyTemplate) Pattern() string {
+	var b strings.Builder
+	starred := false
+	star := func() {
+		if !starred {
+			b.WriteByte('*')
+		}
+		starred = true
+	}
+	for _, s := range t.segs {
+		if s.kind != segLiteral {
+			star()
+			continue
+		}
+		starred = false
+		for _, c := range []byte(s.text) {
+			switch c {
+			case '*', '?', '[', ']', '\\':
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		}
+	}
+	if t.Len > 0 {
+		star()
+	}
+	return b.String()
+}
+
+// Render is the allocating form of Append, for tools and tests.
+func (t *KeyTemplate) Render(id int, n int64) string {
+	return string(t.Append(nil, id, n))
+}
+
+// defaultTemplate keeps the historical "<type>_%012d_%012d_%012d" names.
+func defaultTemplate() *KeyTemplate {
+	return &KeyTemplate{Format: "${prefix}${type}_${n:12}_${n:12}_${n:12}"}
+}
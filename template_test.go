@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestKeyTemplate(t *testing.T) {
+	cases := []struct {
+		tpl  KeyTemplate
+		id   int
+		n    int64
+		want string
+	}{
+		{KeyTemplate{Format: "${prefix}${type}:${n}"}, 0, 42, "run1:key:42"},
+		{KeyTemplate{Format: "${prefix}{${tag}}:${n:6}", Tags: 16}, 0, 35, "run1:{3}:000035"},
+		{KeyTemplate{Format: "{w${worker}}${type}_${group:3}_${n}", Group: 10}, 7, 35, "{w7}key_003_35"},
+		{KeyTemplate{Format: "${type}:${n}", Len: 12}, 0, 5, "key:5xxxxxxx"},
+		{KeyTemplate{Format: "${type}:${pad}:${n}", Len: 12}, 0, 5, "key:xxxxxx:5"},
+		{KeyTemplate{Format: "${type}:${n}", Len: 3}, 0, 5, "key:5"},
+	}
+	for _, c := range cases {
+		if err := c.tpl.Compile("run1:", "key"); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.tpl.Render(c.id, c.n); got != c.want {
+			t.Fatalf("%s: expect %s, get %s", c.tpl.Format, c.want, got)
+		}
+	}
+
+	for _, format := range []string{"", "key", "${type}_${worker}", "${n", "${nope}", "${tag}", "${n:x}"} {
+		tpl := KeyTemplate{Format: format}
+		if err := tpl.Compile("", "key"); err == nil {
+			t.Fatalf("%s: expect error", format)
+		}
+	}
+}
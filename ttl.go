@@ -0,0 +1,517 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// expiryGrace is how long after its deadline a key is checked, the
+// deadline is taken before the write is even sent.
+const expiryGrace = 250 * time.Millisecond
+
+// TTLParam is the distribution of the expirations, see RandomGen.TTL.
+type TTLParam struct {
+	// Dist is fixed (Min), uniform (Min to Max) or exp (exponential of mean
+	// Mean within Min and Max)
+	Dist string
+	Min  time.Duration
+	Max  time.Duration
+	Mean time.Duration
+}
+
+// Default ...
+func (t *TTLParam) Default() *TTLParam {
+	if t.Dist == "" {
+		t.Dist = "uniform"
+	}
+	if t.Min == 0 {
+		t.Min = time.Second
+	}
+	if t.Max == 0 {
+		t.Max = 10 * time.Second
+	}
+	if t.Mean == 0 {
+		t.Mean = (t.Min + t.Max) / 2
+	}
+	return t
+}
+
+// Check ...
+func (t *TTLParam) Check() error {
+	switch t.Dist {
+	case "fixed", "uniform", "exp":
+	default:
+		return fmt.Errorf("unknown distribution %s, fixed, uniform or exp", t.Dist)
+	}
+	if t.Min < time.Millisecond || t.Max < t.Min {
+		return fmt.Errorf("expect 1ms <= Min <= Max, get %v %v", t.Min, t.Max)
+	}
+	return nil
+}
+
+// TTL draws an expiration of the TTL distribution.
+func (rg *RandomGen) TTL(id int) time.Duration {
+	t := rg.Param.TTL
+	var ttl time.Duration
+	switch t.Dist {
+	case "fixed":
+		ttl = t.Min
+	case "uniform":
+		ttl = t.Min + time.Duration(rg.Rand[id].Int63n(int64(t.Max-t.Min)+1))
+	case "exp":
+		ttl = time.Duration(rg.Rand[id].ExpFloat64() * float64(t.Mean))
+	}
+	if ttl < t.Min {
+		ttl = t.Min
+	}
+	if ttl > t.Max {
+		ttl = t.Max
+	}
+	return ttl.Truncate(time.Millisecond)
+}
+
+// seconds rounds ttl up to whole seconds, for EX, SETEX and EXPIRE.
+func seconds(ttl time.Duration) int64 {
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+// validTTL checks the TTL or PTTL reply, in unit, of a key written with ttl
+// at sent. The key may be gone once ttl has passed.
+func validTTL(ttl, unit time.Duration, sent time.Time) func(reply interface{}, err error) error {
+	return func(reply interface{}, err error) error {
+		result, err := redis.Int64(reply, err)
+		if err != nil {
+			return err
+		}
+		if result == -2 && time.Since(sent) >= ttl {
+			return nil
+		}
+		if max := int64(ttl / unit); result < 0 || result > max {
+			return fmt.Errorf("expect ttl in [0, %d], get %d", max, result)
+		}
+		return nil
+	}
+}
+
+// validOne expects 1, 0 once ttl has passed since sent.
+func validOne(ttl time.Duration, sent time.Time) func(reply interface{}, err error) error {
+	return func(reply interface{}, err error) error {
+		result, err := redis.Int(reply, err)
+		if err != nil {
+			return err
+		}
+		if result == 0 && time.Since(sent) >= ttl {
+			return nil
+		}
+		if result != 1 {
+			return fmt.Errorf("expect 1, get %d", result)
+		}
+		return nil
+	}
+}
+
+func validOK(reply interface{}, err error) error {
+	result, err := redis.String(reply, err)
+	if err != nil {
+		return err
+	}
+	if result != "OK" {
+		return fmt.Errorf("expect OK, get %s", result)
+	}
+	return nil
+}
+
+// TTLExecutor writes keys with expirations and reads them back.
+var TTLExecutor = &RandomExecutor{Name: "ttl"}
+
+func init() {
+	Executors = append(Executors, TTLExecutor)
+
+	// SET EX and TTL
+	TTLExecutor.Add("set_ex_ttl", 10, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Key(id)
+		value := RGen.Value(id)
+		ex := seconds(RGen.TTL(id))
+		ttl := time.Duration(ex) * time.Second
+		sent := time.Now()
+		Expiry.Track(key, ttl)
+
+		conn.Send("SET", key, value, "EX", ex)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("SET %s %s EX %d", key, value, ex),
+			valid: Model.Forget(id, key, validOK),
+		})
+		conn.Flush()
+
+		conn.Send("TTL", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("TTL %s", key),
+			valid: validTTL(ttl, time.Second, sent),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// SET PX and PTTL
+	TTLExecutor.Add("set_px_pttl", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Key(id)
+		value := RGen.Value(id)
+		ttl := RGen.TTL(id)
+		px := int64(ttl / time.Millisecond)
+		sent := time.Now()
+		Expiry.Track(key, ttl)
+
+		conn.Send("SET", key, value, "PX", px)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("SET %s %s PX %d", key, value, px),
+			valid: Model.Forget(id, key, validOK),
+		})
+		conn.Flush()
+
+		conn.Send("PTTL", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("PTTL %s", key),
+			valid: validTTL(ttl, time.Millisecond, sent),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// SETEX and TTL
+	TTLExecutor.Add("setex_ttl", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Key(id)
+		value := RGen.Value(id)
+		ex := seconds(RGen.TTL(id))
+		ttl := time.Duration(ex) * time.Second
+		sent := time.Now()
+		Expiry.Track(key, ttl)
+
+		conn.Send("SETEX", key, ex, value)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("SETEX %s %d %s", key, ex, value),
+			valid: Model.Forget(id, key, validOK),
+		})
+		conn.Flush()
+
+		conn.Send("TTL", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("TTL %s", key),
+			valid: validTTL(ttl, time.Second, sent),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// SET, EXPIRE and TTL
+	TTLExecutor.Add("set_expire_ttl", 5, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Key(id)
+		value := RGen.Value(id)
+		ex := seconds(RGen.TTL(id))
+		ttl := time.Duration(ex) * time.Second
+		sent := time.Now()
+		Expiry.Track(key, ttl)
+
+		conn.Send("SET", key, value)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("SET %s %s", key, value),
+			valid: Model.Forget(id, key, validOK),
+		})
+		conn.Flush()
+
+		conn.Send("EXPIRE", key, ex)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("EXPIRE %s %d", key, ex),
+			valid: validOne(ttl, sent),
+		})
+		conn.Flush()
+
+		conn.Send("TTL", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("TTL %s", key),
+			valid: validTTL(ttl, time.Second, sent),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// SET, PEXPIRE and PTTL
+	TTLExecutor.Add("set_pexpire_pttl", 3, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Key(id)
+		value := RGen.Value(id)
+		ttl := RGen.TTL(id)
+		px := int64(ttl / time.Millisecond)
+		sent := time.Now()
+		Expiry.Track(key, ttl)
+
+		conn.Send("SET", key, value)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("SET %s %s", key, value),
+			valid: Model.Forget(id, key, validOK),
+		})
+		conn.Flush()
+
+		conn.Send("PEXPIRE", key, px)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("PEXPIRE %s %d", key, px),
+			valid: validOne(ttl, sent),
+		})
+		conn.Flush()
+
+		conn.Send("PTTL", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("PTTL %s", key),
+			valid: validTTL(ttl, time.Millisecond, sent),
+		})
+		conn.Flush()
+
+		return rs
+	})
+
+	// SET EX, PERSIST and TTL
+	TTLExecutor.Add("set_ex_persist", 2, func(conn redis.Conn, id int) (rs []*Request) {
+		key := RGen.Key(id)
+		value := RGen.Value(id)
+		ex := seconds(RGen.TTL(id))
+		ttl := time.Duration(ex) * time.Second
+		sent := time.Now()
+		Expiry.Track(key, 0)
+
+		conn.Send("SET", key, value, "EX", ex)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("SET %s %s EX %d", key, value, ex),
+			valid: Model.Forget(id, key, validOK),
+		})
+		conn.Flush()
+
+		conn.Send("PERSIST", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("PERSIST %s", key),
+			valid: validOne(ttl, sent),
+		})
+		conn.Flush()
+
+		conn.Send("TTL", key)
+		rs = append(rs, &Request{
+			Opstr: fmt.Sprintf("TTL %s", key),
+			valid: func(reply interface{}, err error) error {
+				result, err := redis.Int(reply, err)
+				if err != nil {
+					return err
+				}
+				if result == -2 && time.Since(sent) >= ttl {
+					return nil
+				}
+				if result != -1 {
+					return fmt.Errorf("expect -1, get %d", result)
+				}
+				return nil
+			},
+		})
+		conn.Flush()
+
+		return rs
+	})
+}
+
+type expiryCheck struct {
+	key string
+	at  time.Time
+}
+
+// expiryQueue is a heap of checks, the earliest first.
+type expiryQueue []*expiryCheck
+
+func (q expiryQueue) Len() int            { return len(q) }
+func (q expiryQueue) Less(i, j int) bool  { return q[i].at.Before(q[j].at) }
+func (q expiryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(*expiryCheck)) }
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// Expiry is the expiry checker of the run, nil unless -ttl-check is set.
+var Expiry *ExpiryChecker
+
+// ExpiryChecker samples keys written with a TTL and confirms with PTTL on
+// its own connection that they are gone once it has passed. Later writes of
+// a sampled key move its deadline, PERSIST cancels the check.
+type ExpiryChecker struct {
+	addr   string
+	every  int64
+	writes int64
+
+	mu       sync.Mutex
+	deadline map[string]time.Time
+	queue    expiryQueue
+	conn     redis.Conn
+	// Expired keys were gone when checked, Alive were not, Rewritten were
+	// persisted or written without TTL meanwhile
+	Expired   int64
+	Alive     int64
+	Rewritten int64
+}
+
+// NewExpiryChecker checks one TTL write in -ttl-check against addr, nil
+// when it is 0.
+func NewExpiryChecker(addr string) *ExpiryChecker {
+	if Conf.TTLCheck <= 0 {
+		return nil
+	}
+	c := &ExpiryChecker{addr: addr, every: Conf.TTLCheck, deadline: map[string]time.Time{}}
+	go c.loop()
+	return c
+}
+
+// Track accounts a write of key expiring in ttl, 0 is a write without TTL.
+func (c *ExpiryChecker) Track(key string, ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	sample := atomic.AddInt64(&c.writes, 1)%c.every == 0
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, tracked := c.deadline[key]
+	if !tracked && (!sample || ttl == 0) {
+		return
+	}
+	var at time.Time
+	if ttl > 0 {
+		at = time.Now().Add(ttl)
+	}
+	c.deadline[key] = at
+	if !tracked {
+		heap.Push(&c.queue, &expiryCheck{key: key, at: at.Add(expiryGrace)})
+	}
+}
+
+func (c *ExpiryChecker) loop() {
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-Stop:
+			return
+		}
+		if err := c.check(); err != nil && Conf.Debug {
+			log.Println("expiry check", err)
+		}
+	}
+}
+
+// due pops the checks whose deadline, moved by later writes, has passed.
+func (c *ExpiryChecker) due(now time.Time) (checks []*expiryCheck) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.queue) > 0 && !c.queue[0].at.After(now) {
+		check := heap.Pop(&c.queue).(*expiryCheck)
+		at := c.deadline[check.key]
+		if at.IsZero() {
+			delete(c.deadline, check.key)
+			c.Rewritten++
+			continue
+		}
+		if at = at.Add(expiryGrace); at.After(now) {
+			check.at = at
+			heap.Push(&c.queue, check)
+			continue
+		}
+		check.at = at
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// check sends PTTL for the due keys, the result only counts when no write
+// moved the deadline meanwhile.
+func (c *ExpiryChecker) check() error {
+	checks := c.due(time.Now())
+	if len(checks) == 0 {
+		return nil
+	}
+	if c.conn == nil || c.conn.Err() != nil {
+		conn, err := redis.Dial("tcp", c.addr, redis.DialConnectTimeout(time.Second),
+			redis.DialReadTimeout(time.Second), redis.DialWriteTimeout(time.Second))
+		if err != nil {
+			c.requeue(checks)
+			return err
+		}
+		c.conn = conn
+	}
+	for _, check := range checks {
+		c.conn.Send("PTTL", check.key)
+	}
+	if err := c.conn.Flush(); err != nil {
+		c.requeue(checks)
+		return err
+	}
+	pttls := make([]int64, len(checks))
+	for i := range checks {
+		pttl, err := redis.Int64(c.conn.Receive())
+		if err != nil {
+			c.requeue(checks)
+			return err
+		}
+		pttls[i] = pttl
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, check := range checks {
+		at := c.deadline[check.key]
+		if at.IsZero() || at.Add(expiryGrace).After(check.at) {
+			// written again while checking
+			c.requeueLocked(check)
+			continue
+		}
+		delete(c.deadline, check.key)
+		switch {
+		case pttls[i] == -2:
+			c.Expired++
+		case pttls[i] == -1:
+			c.Rewritten++
+		default:
+			c.Alive++
+			if Conf.Debug || c.Alive <= mirrorLogLimit {
+				log.Printf("expiry check: %s alive %v after its ttl, pttl %dms\n",
+					check.key, time.Since(at).Truncate(time.Millisecond), pttls[i])
+			}
+		}
+	}
+	return nil
+}
+
+func (c *ExpiryChecker) requeue(checks []*expiryCheck) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, check := range checks {
+		c.requeueLocked(check)
+	}
+}
+
+func (c *ExpiryChecker) requeueLocked(check *expiryCheck) {
+	check.at = time.Now()
+	heap.Push(&c.queue, check)
+}
+
+// Report summarizes the checks, the pending keys had not expired yet at the
+// end of the run.
+func (c *ExpiryChecker) Report() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fmt.Sprintf("expiry check: expired %d\talive %d\trewritten %d\tpending %d",
+		c.Expired, c.Alive, c.Rewritten, len(c.deadline))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLDistribution(t *testing.T) {
+	rg := newTestGen()
+	for _, dist := range []string{"fixed", "uniform", "exp"} {
+		rg.Param.TTL = (&TTLParam{Dist: dist, Min: 100 * time.Millisecond, Max: 2 * time.Second}).Default()
+		if err := rg.Param.TTL.Check(); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			ttl := rg.TTL(0)
+			if ttl < rg.Param.TTL.Min || ttl > rg.Param.TTL.Max || ttl%time.Millisecond != 0 {
+				t.Fatalf("%s: ttl %v out of [%v, %v]", dist, ttl, rg.Param.TTL.Min, rg.Param.TTL.Max)
+			}
+		}
+	}
+	if err := (&TTLParam{Dist: "zipf"}).Default().Check(); err == nil {
+		t.Fatal("expect error on an unknown distribution")
+	}
+	if seconds(1500*time.Millisecond) != 2 || seconds(time.Second) != 1 {
+		t.Fatal("seconds rounds up")
+	}
+}
+
+func TestValidTTL(t *testing.T) {
+	now := time.Now()
+	valid := validTTL(10*time.Second, time.Second, now)
+	if err := valid(int64(10), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := valid(int64(11), nil); err == nil {
+		t.Fatal("expect error on a ttl above the one written")
+	}
+	if err := valid(int64(-2), nil); err == nil {
+		t.Fatal("expect error on a key gone before its ttl")
+	}
+	if err := validTTL(time.Second, time.Second, now.Add(-2*time.Second))(int64(-2), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpiryCheckerDue(t *testing.T) {
+	c := &ExpiryChecker{every: 1, deadline: map[string]time.Time{}}
+	c.Track("a", time.Second)
+	c.Track("b", time.Second)
+	c.Track("c", time.Second)
+	// b is written again later, c persisted
+	c.Track("b", 3*time.Second)
+	c.Track("c", 0)
+	c.every = 1000
+	c.Track("d", time.Second)
+
+	checks := c.due(time.Now().Add(time.Second + expiryGrace))
+	if len(checks) != 1 || checks[0].key != "a" {
+		t.Fatalf("expect a due, get %v", checks)
+	}
+	if c.Rewritten != 1 {
+		t.Fatalf("expect c rewritten, get %d", c.Rewritten)
+	}
+	if len(c.queue) != 1 || c.queue[0].key != "b" {
+		t.Fatalf("expect b requeued, get %v", c.queue)
+	}
+	if _, ok := c.deadline["d"]; ok {
+		t.Fatal("d is not sampled")
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ErrConflict is returned by the validator of EXEC on a nil reply, the
+// transaction was aborted since a WATCHed key changed. It is counted as a
+// conflict of EXEC and not as an error.
+var ErrConflict = errors.New("transaction conflict")
+
+// TxExecutor runs the Transactions of the param file in MULTI/EXEC and
+// WATCH transactions on the hot keys, its MULTI scenarios are added by
+// BuildTransactions.
+var TxExecutor = &RandomExecutor{Name: "tx"}
+
+// TxParam is a MULTI/EXEC block of the tx workload.
+type TxParam struct {
+	Name   string
+	Weight int64
+	// Commands are a command name followed by generators each, see
+	// scriptGens. A generator gives the same value to every command of one
+	// block, so [SET, key, value] and [GET, key] use the same key
+	Commands [][]string
+}
+
+func init() {
+	Executors = append(Executors, TxExecutor)
+
+	// WATCH, GET, MULTI, SET, EXEC incrementing a hot key
+	TxExecutor.Add("watch", 1, func(conn redis.Conn, id int) (rs []*Request) {
+		return watchIncr(SideConn(id), RGen.Hot(id))
+	})
+}
+
+// watchRetries bounds the attempts of a WATCH transaction.
+const watchRetries = 5
+
+// watchIncr increments key in a WATCH transaction, waiting for the GET to
+// compute the SET like a client does. An aborted EXEC is retried up to
+// watchRetries times, every attempt is a WATCH and an EXEC so the conflicts
+// are counted per attempt. A value other than an integer restarts at 1.
+func watchIncr(conn redis.Conn, key string) (rs []*Request) {
+	do := func(valid func(reply interface{}, err error) error, opstr, cmd string, args ...interface{}) (interface{}, error) {
+		r := &Request{Opstr: opstr, valid: valid, Done: true}
+		r.RecordStart()
+		reply, err := conn.Do(cmd, args...)
+		r.RecordStop()
+		r.Err = valid(reply, err)
+		rs = append(rs, r)
+		return reply, r.Err
+	}
+	for attempt := 0; attempt < watchRetries; attempt++ {
+		if _, err := do(validOK, "WATCH "+key, "WATCH", key); err != nil {
+			return rs
+		}
+		reply, err := do(validGet, "GET "+key, "GET", key)
+		if err != nil {
+			do(validOK, "UNWATCH", "UNWATCH")
+			return rs
+		}
+		n, _ := redis.Int64(reply, nil)
+		value := strconv.FormatInt(n+1, 10)
+
+		if _, err := do(validOK, "MULTI", "MULTI"); err != nil {
+			return rs
+		}
+		if _, err := do(validQueued, "SET "+key+" "+value, "SET", key, value); err != nil {
+			do(validOK, "DISCARD", "DISCARD")
+			return rs
+		}
+		if _, err := do(validExec(1), "EXEC", "EXEC"); err != ErrConflict {
+			return rs
+		}
+	}
+	return rs
+}
+
+// validGet accepts a value or nil.
+func validGet(reply interface{}, err error) error {
+	if reply == nil && err == nil {
+		return nil
+	}
+	_, err = redis.String(reply, err)
+	return err
+}
+
+// defaultTransactions run when the param file has no Transactions.
+func defaultTransactions() []*TxParam {
+	return []*TxParam{
+		{Name: "set_get", Weight: 1, Commands: [][]string{{"SET", "key", "value"}, {"GET", "key"}}},
+		{Name: "hset_hlen", Weight: 1, Commands: [][]string{{"HSET", "hash", "hashfield", "value"}, {"HLEN", "hash"}}},
+	}
+}
+
+// Check checks the generators.
+func (tx *TxParam) Check() error {
+	if tx.Name == "" {
+		return fmt.Errorf("transaction without name")
+	}
+	if tx.Weight == 0 {
+		tx.Weight = 1
+	}
+	if tx.Weight < 0 {
+		return fmt.Errorf("%s: negative weight %d", tx.Name, tx.Weight)
+	}
+	if len(tx.Commands) == 0 {
+		return fmt.Errorf("%s: no commands", tx.Name)
+	}
+	for _, cmd := range tx.Commands {
+		if len(cmd) == 0 {
+			return fmt.Errorf("%s: empty command", tx.Name)
+		}
+		for _, gen := range cmd[1:] {
+			if _, ok := scriptGens[gen]; !ok && !strings.HasPrefix(gen, "=") {
+				return fmt.Errorf("%s: unknown generator %s", tx.Name, gen)
+			}
+		}
+	}
+	return nil
+}
+
+// BuildTransactions adds a MULTI scenario to TxExecutor for every
+// transaction of param.
+func BuildTransactions(param *Param) {
+	for _, tx := range param.Transactions {
+		TxExecutor.Add("multi_"+tx.Name, tx.Weight, txScenario(tx))
+	}
+}
+
+// txScenario sends the commands of tx in MULTI/EXEC.
+func txScenario(tx *TxParam) func(conn redis.Conn, id int) (rs []*Request) {
+	return func(conn redis.Conn, id int) (rs []*Request) {
+		values := map[string]interface{}{}
+
+		conn.Send("MULTI")
+		rs = append(rs, &Request{
+			Opstr: "MULTI",
+			valid: validOK,
+		})
+		for _, cmd := range tx.Commands {
+			args := make([]interface{}, len(cmd)-1)
+			for i, gen := range cmd[1:] {
+				v, ok := values[gen]
+				if !ok {
+					v = generate(gen, id)
+					values[gen] = v
+				}
+				args[i] = v
+			}
+			conn.Send(cmd[0], args...)
+			rs = append(rs, &Request{
+				Opstr: strings.TrimSpace(cmd[0] + " " + joinArgs(args)),
+				valid: validQueued,
+			})
+		}
+		conn.Send("EXEC")
+		rs = append(rs, &Request{
+			Opstr: "EXEC " + tx.Name,
+			valid: validExec(len(tx.Commands)),
+		})
+		conn.Flush()
+
+		return rs
+	}
+}
+
+func validQueued(reply interface{}, err error) error {
+	result, err := redis.String(reply, err)
+	if err != nil {
+		return err
+	}
+	if result != "QUEUED" {
+		return fmt.Errorf("expect QUEUED, get %s", result)
+	}
+	return nil
+}
+
+// validExec expects the replies of n commands, a nil reply is a conflict.
+func validExec(n int) func(reply interface{}, err error) error {
+	return func(reply interface{}, err error) error {
+		if reply == nil && err == nil {
+			return ErrConflict
+		}
+		result, err := redis.Values(reply, err)
+		if err != nil {
+			return err
+		}
+		if len(result) != n {
+			return fmt.Errorf("expect %d replies, get %d", n, len(result))
+		}
+		for _, r := range result {
+			if e, ok := r.(redis.Error); ok {
+				return e
+			}
+		}
+		return nil
+	}
+}
+
+// Conflicts returns the WATCH transactions and the aborted ones.
+func (r *Result) Conflicts() (watched, aborted int64) {
+	if c := r.Cmds["WATCH"]; c != nil {
+		watched = c.Num
+	}
+	if c := r.Cmds["EXEC"]; c != nil {
+		aborted = c.Conflict
+	}
+	return watched, aborted
+}
+
+// ConflictRate returns the aborted WATCH transactions in percent.
+func (r *Result) ConflictRate() float64 {
+	watched, aborted := r.Conflicts()
+	if watched == 0 {
+		return 0
+	}
+	return 100 * float64(aborted) / float64(watched)
+}
+
+// ConflictReport formats the conflicts of the WATCH transactions against
+// the concurrency, empty without WATCH.
+func (r *Result) ConflictReport() string {
+	watched, aborted := r.Conflicts()
+	if watched == 0 {
+		return ""
+	}
+	return fmt.Sprintf("WATCH transactions %d\tconflict %d\t%.2f%% with %d workers on %d hot keys\n",
+		watched, aborted, r.ConflictRate(), RGen.Num, RGen.Param.HotNum)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestValidExec(t *testing.T) {
+	valid := validExec(2)
+	if err := valid(nil, nil); err != ErrConflict {
+		t.Fatalf("expect conflict, get %v", err)
+	}
+	if err := valid([]interface{}{"OK", []byte("v")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := valid([]interface{}{"OK", redis.Error("WRONGTYPE")}, nil); err == nil {
+		t.Fatal("expect the error of a queued command")
+	}
+	if err := valid([]interface{}{"OK"}, nil); err == nil {
+		t.Fatal("expect error on a missing reply")
+	}
+}
+
+func TestConflictRate(t *testing.T) {
+	s := newBucketStatus()
+	s.Record(&Request{Opstr: "WATCH hot"})
+	s.Record(&Request{Opstr: "WATCH hot"})
+	s.Record(&Request{Opstr: "EXEC", Err: ErrConflict})
+	s.Record(&Request{Opstr: "EXEC"})
+	r := &Result{Cmds: s.Cmds}
+	if s.Err != 0 || r.ConflictRate() != 50 {
+		t.Fatalf("expect 50%% conflicts and no error, get %.2f%% %d", r.ConflictRate(), s.Err)
+	}
+
+	tx := &TxParam{Name: "t", Commands: [][]string{{"SET", "hot", "=1"}}}
+	if err := tx.Check(); err != nil || tx.Weight != 1 {
+		t.Fatalf("unexpected %v %+v", err, tx)
+	}
+	tx.Commands = append(tx.Commands, []string{"GET", "hto"})
+	if err := tx.Check(); err == nil {
+		t.Fatal("expect error on an unknown generator")
+	}
+}
+
+// doConn replies to Do from replies per command, in order.
+type doConn struct {
+	redis.Conn
+	replies map[string][]interface{}
+	sent    []string
+}
+
+func (c *doConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c.sent = append(c.sent, strings.TrimSpace(fmt.Sprintln(append([]interface{}{cmd}, args...)...)))
+	l := c.replies[cmd]
+	if len(l) == 0 {
+		return "OK", nil
+	}
+	c.replies[cmd] = l[1:]
+	return l[0], nil
+}
+
+func TestWatchIncr(t *testing.T) {
+	conn := &doConn{replies: map[string][]interface{}{
+		"GET":  {[]byte("41"), []byte("42")},
+		"SET":  {"QUEUED", "QUEUED"},
+		"EXEC": {nil, []interface{}{"OK"}},
+	}}
+	rs := watchIncr(conn, "hot")
+	if len(rs) != 10 {
+		t.Fatalf("expect 2 attempts of 5 commands, get %d", len(rs))
+	}
+	s := newBucketStatus()
+	for _, r := range rs {
+		if !r.Done {
+			t.Fatalf("%s: expect done", r.Opstr)
+		}
+		s.Record(r)
+	}
+	if r := (&Result{Cmds: s.Cmds}); r.ConflictRate() != 50 || s.Err != 0 {
+		t.Fatalf("expect 50%% conflicts per attempt, get %.2f%% %d errors", r.ConflictRate(), s.Err)
+	}
+	if conn.sent[3] != "SET hot 42" || conn.sent[8] != "SET hot 43" {
+		t.Fatalf("expect the SET to increment the GET, get %v", conn.sent)
+	}
+}
+
+func (c *doConn) Err() error { return nil }